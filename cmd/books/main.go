@@ -2,24 +2,44 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	stdlog "log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/Rustam2595/library_service/internal/admin"
 	"github.com/Rustam2595/library_service/internal/config"
 	authservicev1 "github.com/Rustam2595/library_service/internal/gen/go"
 	books_servicev1 "github.com/Rustam2595/library_service/internal/genBooks/go"
+	"github.com/Rustam2595/library_service/internal/health"
 	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/mail"
+	"github.com/Rustam2595/library_service/internal/metrics"
+	"github.com/Rustam2595/library_service/internal/password"
+	"github.com/Rustam2595/library_service/internal/requestid"
 	serv "github.com/Rustam2595/library_service/internal/server"
 	store "github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/internal/storage/cache"
+	"github.com/Rustam2595/library_service/internal/tracing"
+	"github.com/Rustam2595/library_service/migrations"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// shutdownTimeout - сколько ждём завершения запросов в обработке после остановки листенера
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	cnf := config.ReadConfig()
-	log := logger.Get(cnf.Debug) //add zerolog
+	if err := logger.Init(cnf.Debug, cnf.LogLevels); err != nil {
+		stdlog.Fatalf("failed to init logger: %v", err)
+	}
+	log := logger.Get() //add zerolog
 	log.Debug().Msg("logger was inited")
 	log.Debug().Any("config", cnf).Send()
 	//<-- graceful shutdown
@@ -31,19 +51,55 @@ func main() {
 		cancel()
 	}()
 	//-->
-	var str serv.Storage
-	str, err := store.NewRepo(context.Background(), cnf.DBDsn)
+	args := flag.Args()
+	if len(args) == 0 {
+		// без подкоманды бинарник, как и раньше, запускает сервер
+		args = []string{"serve"}
+	}
+	switch args[0] {
+	case "serve":
+		serve(ctx, cnf)
+	case "migrate":
+		if err := runMigrate(ctx, cnf, args[1:], os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("migration failed")
+		}
+	default:
+		log.Fatal().Msgf("unknown command %q, want serve or migrate", args[0])
+	}
+}
+
+// serve запускает HTTP-сервер и админский листенер и работает до отмены ctx.
+func serve(ctx context.Context, cnf config.Config) {
+	log := logger.Get()
+	shutdownTracing, err := tracing.Init(ctx, cnf.TraceExporter, cnf.OTLPEndpoint, serv.ServiceName)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to init tracing")
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error().Err(err).Msg("failed to flush traces")
+		}
+	}()
+	checker := health.New()
+	str, closeStorage, err := openStorage(ctx, cnf, checker)
 	if err != nil {
-		str = store.New()
-		log.Fatal().Err(err).Msg("failed to connect to database")
+		log.Fatal().Err(err).Msg("failed to open storage")
 	}
-	if err = store.Migrations(cnf.DBDsn, cnf.MigratePath); err != nil {
-		log.Fatal().Err(err).Msg("Migrations failed")
+	defer closeStorage()
+	cached := cache.New(str, cache.Options{Size: cnf.CacheSize, TTL: cnf.CacheTTL})
+	if err = metrics.RegisterCache("books", cached); err != nil {
+		log.Fatal().Err(err).Msg("failed to register cache metrics")
 	}
 	log.Debug().Msg("go to client")
 	//auth_service:
 	// Подключаемся к серверу
-	connAuth, err := grpc.NewClient(cnf.AuthAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	connAuth, err := grpc.NewClient(cnf.AuthAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithChainUnaryInterceptor(
+			metrics.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+			logger.UnaryClientInterceptor()))
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to connect to grpc auth server")
 	}
@@ -57,7 +113,13 @@ func main() {
 
 	//books_service:
 	// Подключаемся к серверу
-	connBooks, err := grpc.NewClient(cnf.BooksAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	connBooks, err := grpc.NewClient(cnf.BooksAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithChainUnaryInterceptor(
+			metrics.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+			logger.UnaryClientInterceptor()))
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to connect to grpc books server")
 	}
@@ -69,7 +131,24 @@ func main() {
 	// Создаём клиента
 	clientBooks := books_servicev1.NewBooksServiceClient(connBooks)
 
-	server := serv.New(cnf.Host, str, clientAuth, clientBooks)
+	var mailer serv.Mailer
+	if cnf.SMTPURL != "" {
+		smtpMailer, err := mail.NewSMTP(cnf.SMTPURL, cnf.MailFrom)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to configure mail")
+		}
+		mailer = smtpMailer
+	} else {
+		log.Warn().Msg("smtp is not configured, email changes are disabled")
+	}
+	server := serv.New(cnf.Host, cached, clientAuth, clientBooks, cnf.TOTPSkew, cnf.ImportMaxBytes, mailer)
+	adminServer := admin.New(cnf.AdminHost)
+	adminServer.Handle("/metrics", metrics.Handler())
+	adminServer.Handle("/log/level", logger.LevelHandler())
+	checker.Add("auth_service", health.GRPCConnCheck(connAuth))
+	checker.Add("books_service", health.GRPCConnCheck(connBooks))
+	adminServer.Handle("/healthz", checker.Liveness())
+	adminServer.Handle("/readyz", checker.Readiness())
 
 	group, gCtx := errgroup.WithContext(ctx)
 	group.Go(func() error {
@@ -79,16 +158,106 @@ func main() {
 		}
 		return nil
 	})
+	group.Go(func() error {
+		log.Info().Str("host", cnf.AdminHost).Msg("starting admin server")
+		return adminServer.Run()
+	})
 	group.Go(func() error {
 		return <-server.ErrChan
 	})
 	group.Go(func() error {
 		<-gCtx.Done()
-
-		return server.ShutdownServer(gCtx)
+		// сначала снимаем готовность, чтобы балансировщик перестал слать запросы,
+		// и только потом закрываем листенер
+		checker.Drain()
+		log.Info().Dur("delay", cnf.ShutdownDelay).Msg("readiness disabled, draining traffic")
+		time.Sleep(cnf.ShutdownDelay)
+		// gCtx уже отменён, поэтому запросам в обработке даём свой срок
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := server.ShutdownServer(shutdownCtx)
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("failed to stop admin server")
+		}
+		return err
 	})
 	if err = group.Wait(); err != nil {
 		log.Fatal().Err(err).Msg("fatal server stopped")
 	}
 	log.Info().Msg("server was stopped")
 }
+
+// openStorage открывает хранилище, выбранное схемой DSN, применяет его миграции
+// и регистрирует проверку готовности. Возвращаемая функция закрывает хранилище.
+func openStorage(ctx context.Context, cnf config.Config, checker *health.Checker) (serv.Storage, func(), error) {
+	log := logger.Get()
+	backend, err := cnf.Backend()
+	if err != nil {
+		return nil, nil, err
+	}
+	hasher := password.Default()
+	if cnf.LegacyPlaintextPasswords {
+		log.Warn().Msg("passwords stored in plaintext are accepted until their owners log in again")
+		hasher = password.Legacy()
+	}
+	switch backend {
+	case config.BackendPostgres:
+		repo, err := store.NewRepo(ctx, cnf.DBDsn, store.RepoOptions{
+			ReplicaDSNs:      cnf.DBReplicaDSNs,
+			ReplicaMaxLag:    cnf.DBReplicaMaxLag,
+			MaxConns:         int32(cnf.DBMaxConns),
+			MaxConnLifetime:  cnf.DBConnLifetime,
+			StatementTimeout: cnf.DBStatementTimeout,
+			ReadTimeout:      cnf.DBReadTimeout,
+			WriteTimeout:     cnf.DBWriteTimeout,
+			ExportTimeout:    cnf.DBExportTimeout,
+			SlowQuery:        cnf.DBSlowQuery,
+			Hasher:           hasher,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		if err = metrics.RegisterPool("primary", func() metrics.PoolStat { return repo.Stat() }); err != nil {
+			return nil, nil, fmt.Errorf("failed to register pool metrics: %w", err)
+		}
+		err = metrics.RegisterReplicas(func() []metrics.ReplicaStat {
+			var stats []metrics.ReplicaStat
+			for _, r := range repo.Replicas() {
+				stats = append(stats, metrics.ReplicaStat{Name: r.Name, Healthy: r.Healthy, Lag: r.Lag})
+			}
+			return stats
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to register replica metrics: %w", err)
+		}
+		if _, err = store.CheckMigrations(cnf.DBDsn, migrations.Postgres()); err != nil {
+			return nil, nil, err
+		}
+		checker.Add("postgres", health.PingCheck(repo))
+		return repo, repo.Close, nil
+	case config.BackendSQLite:
+		if _, err = store.CheckMigrations(cnf.DBDsn, migrations.SQLite()); err != nil {
+			return nil, nil, err
+		}
+		lite, err := store.NewSQLite(ctx, cnf.DBDsn, hasher)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open sqlite: %w", err)
+		}
+		checker.Add("sqlite", health.PingCheck(lite))
+		return lite, func() {
+			if err := lite.Close(); err != nil {
+				log.Error().Err(err).Msg("failed to close sqlite")
+			}
+		}, nil
+	default:
+		mem, err := store.OpenMemory(cnf.DBDsn, hasher)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open memory storage: %w", err)
+		}
+		return mem, func() {
+			if err := mem.Close(); err != nil {
+				log.Error().Err(err).Msg("failed to close memory storage")
+			}
+		}, nil
+	}
+}
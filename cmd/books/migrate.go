@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+
+	"github.com/Rustam2595/library_service/internal/config"
+	store "github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/migrations"
+)
+
+var errMigrateUsage = errors.New("usage: migrate up | migrate down N | migrate status | migrate force V")
+
+// runMigrate выполняет подкоманду migrate и печатает итоговое состояние схемы в out.
+func runMigrate(ctx context.Context, cnf config.Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+	src, err := migrationsFor(cnf)
+	if err != nil {
+		return err
+	}
+	mg, err := store.NewMigrator(cnf.DBDsn, src)
+	if err != nil {
+		return err
+	}
+	defer mg.Close()
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+		err = mg.Up(ctx)
+	case "down":
+		n, convErr := intArg(args)
+		if convErr != nil {
+			return convErr
+		}
+		err = mg.Down(ctx, n)
+	case "force":
+		version, convErr := intArg(args)
+		if convErr != nil {
+			return convErr
+		}
+		err = mg.Force(ctx, version)
+	case "status":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+	default:
+		return errMigrateUsage
+	}
+	if err != nil {
+		return err
+	}
+	status, err := mg.Status()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "version: %d\nlatest: %d\ndirty: %t\n", status.Version, status.Latest, status.Dirty)
+	return err
+}
+
+// intArg разбирает единственный числовой аргумент подкоманды: down N, force V.
+func intArg(args []string) (int, error) {
+	if len(args) != 2 {
+		return 0, errMigrateUsage
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is not a number", errMigrateUsage, args[1])
+	}
+	return n, nil
+}
+
+// migrationsFor выбирает встроенные миграции по схеме DSN.
+func migrationsFor(cnf config.Config) (fs.FS, error) {
+	backend, err := cnf.Backend()
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case config.BackendPostgres:
+		return migrations.Postgres(), nil
+	case config.BackendSQLite:
+		return migrations.SQLite(), nil
+	default:
+		return nil, fmt.Errorf("%s storage has no migrations", backend)
+	}
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Server - служебный HTTP-листенер для метрик и операционных ручек.
+// Работает на отдельном адресе, чтобы не светить их наружу вместе с API.
+type Server struct {
+	serve *http.Server
+	mux   *http.ServeMux
+}
+
+func New(host string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		serve: &http.Server{
+			Addr:              host,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		mux: mux,
+	}
+}
+
+// Handle регистрирует обработчик на админском листенере.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Run() error {
+	if err := s.serve.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.serve.Shutdown(ctx)
+}
@@ -7,15 +7,27 @@ const (
 	// UserNotFoundError указывает, что пользователь с указанными данными не найден в системе.
 	UserNotFoundError = "user not found"
 
-	// UserListEmptyError сигнализирует, что в базе пользователей нет ни одной записи.
-	UserListEmptyError = "user database is empty"
-
 	// BookNotFoundError возвращается, когда книга с указанным идентификатором не найдена.
 	BookNotFoundError = "book not found"
 
-	// BooksListEmptyError означает, что в базе книг отсутствуют какие‑либо записи.
-	BooksListEmptyError = "book database is empty"
-
 	// BookWasDeletedError указывает, что запрошенная книга была ранее удалена и недоступна.
 	BookWasDeletedError = "the book has been deleted"
+
+	// RecoveryCodeInvalidError возвращается, когда код восстановления не найден или уже был использован.
+	RecoveryCodeInvalidError = "invalid recovery code"
+
+	// TOTPNotEnrolledError указывает, что пользователь не начинал подключение двухфакторной аутентификации.
+	TOTPNotEnrolledError = "two-factor authentication is not enrolled"
+
+	// APIKeyNotFoundError возвращается, когда API-ключ с указанными данными не найден.
+	APIKeyNotFoundError = "api key not found"
+
+	// EmailTakenError указывает, что адрес электронной почты уже занят другим пользователем.
+	EmailTakenError = "email is already taken"
+
+	// EmailTokenInvalidError возвращается, когда код подтверждения почты неверен или просрочен.
+	EmailTokenInvalidError = "invalid or expired email verification token"
+
+	// VersionMismatchError возвращается, когда запись изменили после того, как клиент её прочитал.
+	VersionMismatchError = "the record has been modified, reload it and retry"
 )
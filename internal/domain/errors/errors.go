@@ -0,0 +1,68 @@
+package errors
+
+// Code - стабильный машинный код ошибки. В отличие от текста, код не меняется
+// между версиями, и клиенты могут на него опираться.
+type Code string
+
+const (
+	CodeBadRequest        Code = "bad_request"
+	CodeValidation        Code = "validation_failed"
+	CodeUnauthenticated   Code = "unauthenticated"
+	CodeForbidden         Code = "forbidden"
+	CodeNotFound          Code = "not_found"
+	CodeConflict          Code = "conflict"
+	CodeInternal          Code = "internal"
+	CodeUnavailable       Code = "unavailable"
+	CodeInvalidAuthData   Code = "invalid_auth_data"
+	CodeUserNotFound      Code = "user_not_found"
+	CodeBookNotFound      Code = "book_not_found"
+	CodeBookDeleted       Code = "book_deleted"
+	CodeRecoveryCode      Code = "recovery_code_invalid"
+	CodeTOTPNotEnrolled   Code = "totp_not_enrolled"
+	CodeTOTPInvalid       Code = "totp_invalid"
+	CodeAPIKeyNotFound    Code = "api_key_not_found"
+	CodeEmailTaken        Code = "email_taken"
+	CodeEmailTokenInvalid Code = "email_token_invalid"
+	CodeUserExists        Code = "user_exists"
+	CodeWrongPassword     Code = "current_password_invalid"
+	CodeChallengeExpired  Code = "challenge_expired"
+	CodeExportNotFound    Code = "export_not_found"
+	CodeExportNotReady    Code = "export_not_ready"
+	CodeVersionMismatch   Code = "version_mismatch"
+	CodeIfMatchRequired   Code = "precondition_required"
+	CodeUnsupportedMedia  Code = "unsupported_media_type"
+	CodePayloadTooLarge   Code = "payload_too_large"
+	CodeNotImplemented    Code = "not_implemented"
+)
+
+// Error - доменная ошибка с машинным кодом. errors.Is сравнивает такие ошибки по коду,
+// поэтому обёртка с другим текстом всё равно совпадает с исходной переменной.
+type Error struct {
+	Code    Code
+	Message string
+	// Err - первопричина, клиенту не показывается
+	Err error
+}
+
+// New создаёт доменную ошибку.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap создаёт доменную ошибку с первопричиной err.
+func Wrap(code Code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Области доступа (scopes) API-ключей и пользователей.
+const (
+	ScopeBooksRead  = "books:read"
+	ScopeBooksWrite = "books:write"
+	ScopeUsersAdmin = "users:admin"
+)
+
+// APIKey представляет ключ доступа для машинных клиентов. Сам ключ не хранится, только его хеш.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"-" redact:"secret"`
+	OwnerUID  string     `json:"owner_uid"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Revoked   bool       `json:"revoked"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Expired сообщает, истёк ли срок действия ключа на момент now.
+func (k APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && now.After(*k.ExpiresAt)
+}
+
+// Principal описывает аутентифицированного клиента, от имени которого выполняется запрос:
+// пользователя с JWT или машинного клиента с API-ключом.
+type Principal struct {
+	UserID   string
+	Role     string
+	Scopes   []string
+	APIKeyID string
+}
+
+// HasScope проверяет, разрешена ли клиенту указанная область доступа.
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// ScopesForRole возвращает области доступа, которые положены пользователю с данной ролью.
+func ScopesForRole(role string) []string {
+	if role == RoleAdmin {
+		return []string{ScopeBooksRead, ScopeBooksWrite, ScopeUsersAdmin}
+	}
+	return []string{ScopeBooksRead, ScopeBooksWrite}
+}
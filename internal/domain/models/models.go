@@ -2,13 +2,29 @@ package models
 
 import "time"
 
+// Роли пользователей.
+const (
+	RoleMember    = "member"
+	RoleLibrarian = "librarian"
+	RoleAdmin     = "admin"
+)
+
 // User представляет доменную модель пользователя.
 type User struct {
 	UID         string `json:"uid"`
 	Name        string `json:"name" validate:"required"`
-	Email       string `json:"email" validate:"required,email"`
-	Pass        string `json:"pass" validate:"required"`
+	Email       string `json:"email" validate:"required,email" redact:"email"`
+	Pass        string `json:"pass" validate:"required" redact:"secret"`
 	DeletedUser bool   `json:"deleted_user"`
+	Role        string `json:"role,omitempty"`
+	TOTPSecret  string `json:"-" redact:"secret"`
+	TOTPEnabled bool   `json:"totp_enabled,omitempty"`
+	Version     int64  `json:"version"`
+}
+
+// Privileged сообщает, относится ли пользователь к библиотекарям или администраторам.
+func (u User) Privileged() bool {
+	return u.Role == RoleLibrarian || u.Role == RoleAdmin
 }
 
 // Book представляет доменную модель предмета(книг).
@@ -19,4 +35,16 @@ type Book struct {
 	Deleted   bool      `json:"delete"`
 	UserUID   string    `json:"user_uid" validate:"required"`
 	CreatedAt time.Time `json:"created_at"`
+	Version   int64     `json:"version"`
+}
+
+// BookRevision - прежняя версия книги в истории изменений: поля книги до изменения,
+// кто и когда заменил эту версию следующей.
+type BookRevision struct {
+	BID      string    `json:"bid"`
+	Version  int64     `json:"version"`
+	Label    string    `json:"label"`
+	Author   string    `json:"author"`
+	EditedBy string    `json:"edited_by"`
+	EditedAt time.Time `json:"edited_at"`
 }
@@ -0,0 +1,147 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	checkTimeout = 2 * time.Second
+
+	statusUp   = "up"
+	statusDown = "down"
+)
+
+// Check проверяет одну зависимость сервиса. nil - зависимость доступна.
+type Check func(ctx context.Context) error
+
+// Pinger - зависимость с методом Ping, например *pgxpool.Pool.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker отдаёт /healthz и /readyz. После Drain сервис считается не готовым
+// принимать трафик, даже если все зависимости доступны.
+type Checker struct {
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+func New() *Checker {
+	return &Checker{}
+}
+
+// Add регистрирует проверку зависимости для /readyz. Вызывается до запуска листенера.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Drain переводит /readyz в 503. Вызывается в начале остановки сервиса,
+// чтобы балансировщик успел снять с него трафик.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+type dependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]dependencyStatus `json:"dependencies,omitempty"`
+}
+
+// Liveness отвечает 200, пока процесс жив и обслуживает запросы.
+func (c *Checker) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, readinessResponse{Status: statusUp})
+	})
+}
+
+// Readiness опрашивает все зависимости параллельно и отвечает 200, только если все они доступны.
+func (c *Checker) Readiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.draining.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, readinessResponse{Status: "draining"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+		resp := readinessResponse{
+			Status:       statusUp,
+			Dependencies: make(map[string]dependencyStatus, len(c.checks)),
+		}
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		for _, nc := range c.checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dep := dependencyStatus{Status: statusUp}
+				if err := nc.check(ctx); err != nil {
+					dep = dependencyStatus{Status: statusDown, Error: err.Error()}
+				}
+				mu.Lock()
+				resp.Dependencies[nc.name] = dep
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		code := http.StatusOK
+		for _, dep := range resp.Dependencies {
+			if dep.Status != statusUp {
+				resp.Status = statusDown
+				code = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, code, resp)
+	})
+}
+
+// PingCheck проверяет зависимость через Ping.
+func PingCheck(p Pinger) Check {
+	return p.Ping
+}
+
+// GRPCConnCheck проверяет состояние gRPC-соединения. Простаивающее соединение
+// будится и проверка ждёт, пока оно станет Ready, но не дольше таймаута контекста.
+func GRPCConnCheck(conn *grpc.ClientConn) Check {
+	return func(ctx context.Context) error {
+		state := conn.GetState()
+		if state == connectivity.Idle {
+			conn.Connect()
+		}
+		for state != connectivity.Ready {
+			if state == connectivity.Shutdown {
+				return fmt.Errorf("connection is %s", state)
+			}
+			if !conn.WaitForStateChange(ctx, state) {
+				return fmt.Errorf("connection is %s", state)
+			}
+			state = conn.GetState()
+		}
+		return nil
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestReadiness(t *testing.T) {
+	up := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("connection refused") }
+	testCases := []struct {
+		name       string
+		checks     map[string]Check
+		drain      bool
+		statusCode int
+		contains   []string
+	}{
+		{
+			name:       "Test Readiness() func; Case 1: all dependencies up",
+			checks:     map[string]Check{"postgres": up, "auth_service": up},
+			statusCode: http.StatusOK,
+			contains:   []string{`"postgres":{"status":"up"}`, `"auth_service":{"status":"up"}`},
+		},
+		{
+			name:       "Test Readiness() func; Case 2: one dependency down",
+			checks:     map[string]Check{"postgres": down, "auth_service": up},
+			statusCode: http.StatusServiceUnavailable,
+			contains:   []string{`"status":"down"`, `"postgres":{"status":"down","error":"connection refused"}`},
+		},
+		{
+			name:       "Test Readiness() func; Case 3: draining",
+			checks:     map[string]Check{"postgres": up},
+			drain:      true,
+			statusCode: http.StatusServiceUnavailable,
+			contains:   []string{`"status":"draining"`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New()
+			for name, check := range tc.checks {
+				c.Add(name, check)
+			}
+			if tc.drain {
+				c.Drain()
+			}
+			w := httptest.NewRecorder()
+			c.Readiness().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tc.statusCode, w.Code)
+			for _, s := range tc.contains {
+				assert.Contains(t, w.Body.String(), s)
+			}
+		})
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	c := New()
+	c.Add("postgres", func(context.Context) error { return errors.New("down") })
+	c.Drain()
+	w := httptest.NewRecorder()
+	c.Liveness().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGRPCConnCheck(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := grpc.NewServer()
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, GRPCConnCheck(conn)(ctx))
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	assert.NoError(t, closed.Close())
+	deadConn, err := grpc.NewClient(closed.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer deadConn.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Error(t, GRPCConnCheck(deadConn)(ctx))
+}
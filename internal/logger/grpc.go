@@ -0,0 +1,33 @@
+package logger
+
+import (
+	"context"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor пишет исходящие gRPC-вызовы в логгер модуля grpc:
+// успешные - на уровне debug, ошибки - на уровне warn.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		zLog := Trace(ctx, Named(ModuleGRPC))
+		event := zLog.Debug()
+		if err != nil {
+			event = zLog.Warn().Err(err)
+		}
+		if id, ok := requestid.FromContext(ctx); ok {
+			event = event.Str("request_id", id)
+		}
+		event.Str("method", method).
+			Str("code", status.Code(err).String()).
+			Dur("latency", time.Since(start)).
+			Msg("grpc call")
+		return err
+	}
+}
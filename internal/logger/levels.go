@@ -0,0 +1,168 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// levels хранит текущий уровень по умолчанию и уровни модулей.
+// Модуль без своего уровня пишет с уровнем по умолчанию.
+var levels = struct {
+	sync.RWMutex
+	def     zerolog.Level
+	modules map[string]zerolog.Level
+}{
+	def:     zerolog.InfoLevel,
+	modules: map[string]zerolog.Level{},
+}
+
+// loggerSet - готовые логгеры с выставленным уровнем. Выключенный уровень отсекается самим
+// zerolog: Debug() возвращает nil, и поля записи не вычисляются.
+type loggerSet struct {
+	base     zerolog.Logger
+	defLevel zerolog.Level
+	def      zerolog.Logger
+	modules  map[string]zerolog.Logger
+}
+
+func (s *loggerSet) named(module string, level zerolog.Level) zerolog.Logger {
+	return s.base.With().Str("module", module).Logger().Level(level)
+}
+
+// loggers пересобирается при каждой смене уровней, чтение идёт без блокировок.
+var loggers atomic.Pointer[loggerSet]
+
+// rebuild собирает логгеры по текущим уровням. Вызывается под levels.Lock,
+// чтобы при одновременных SetLevel последним сохранился набор с последними уровнями.
+func rebuild() {
+	b := root()
+	set := &loggerSet{
+		base:     b,
+		defLevel: levels.def,
+		def:      b.Level(levels.def),
+		modules:  make(map[string]zerolog.Logger, len(levels.modules)+3),
+	}
+	for _, module := range []string{ModuleServer, ModuleStorage, ModuleGRPC} {
+		set.modules[module] = set.named(module, levels.def)
+	}
+	for module, level := range levels.modules {
+		set.modules[module] = set.named(module, level)
+	}
+	loggers.Store(set)
+}
+
+// current возвращает текущий набор логгеров, до Init - с уровнем info.
+func current() *loggerSet {
+	if set := loggers.Load(); set != nil {
+		return set
+	}
+	levels.Lock()
+	defer levels.Unlock()
+	if loggers.Load() == nil {
+		rebuild()
+	}
+	return loggers.Load()
+}
+
+func setLevels(def zerolog.Level, modules map[string]zerolog.Level) {
+	levels.Lock()
+	defer levels.Unlock()
+	levels.def = def
+	levels.modules = modules
+	rebuild()
+}
+
+// SetLevel меняет уровень модуля во время работы. Пустой module меняет уровень по умолчанию.
+// Новый уровень действует для логгеров, полученных из Get и Named после вызова.
+func SetLevel(module string, level zerolog.Level) {
+	levels.Lock()
+	defer levels.Unlock()
+	if module == "" {
+		levels.def = level
+	} else {
+		levels.modules[module] = level
+	}
+	rebuild()
+}
+
+// ParseLevels разбирает строку вида "server=debug,storage=warn".
+func ParseLevels(s string) (map[string]zerolog.Level, error) {
+	modules := make(map[string]zerolog.Level)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		module, value, ok := strings.Cut(pair, "=")
+		if !ok || module == "" {
+			return nil, fmt.Errorf("invalid log level %q, want module=level", pair)
+		}
+		level, err := zerolog.ParseLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module, err)
+		}
+		modules[module] = level
+	}
+	return modules, nil
+}
+
+type levelsResponse struct {
+	Default string            `json:"default"`
+	Modules map[string]string `json:"modules"`
+}
+
+type setLevelRequest struct {
+	Module string `json:"module"`
+	Level  string `json:"level"`
+}
+
+func currentLevels() levelsResponse {
+	levels.RLock()
+	defer levels.RUnlock()
+	resp := levelsResponse{Default: levels.def.String(), Modules: make(map[string]string, len(levels.modules))}
+	for module, level := range levels.modules {
+		resp.Modules[module] = level.String()
+	}
+	return resp
+}
+
+// LevelHandler - ручка админского листенера: GET отдаёт текущие уровни,
+// PUT {"module":"storage","level":"debug"} меняет уровень модуля (без module - уровень по умолчанию).
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req setLevelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			level, err := zerolog.ParseLevel(req.Level)
+			if err != nil || req.Level == "" {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("unknown level %q", req.Level))
+				return
+			}
+			SetLevel(req.Module, level)
+			zLog := Get()
+			zLog.Info().Str("module", req.Module).Str("level", level.String()).Msg("log level changed")
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(currentLevels())
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
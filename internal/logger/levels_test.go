@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLevels(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		want    map[string]zerolog.Level
+		wantErr bool
+	}{
+		{name: "Test ParseLevels() func; Case 1: empty", in: "", want: map[string]zerolog.Level{}},
+		{
+			name: "Test ParseLevels() func; Case 2: modules",
+			in:   "server=debug, storage=warn",
+			want: map[string]zerolog.Level{ModuleServer: zerolog.DebugLevel, ModuleStorage: zerolog.WarnLevel},
+		},
+		{name: "Test ParseLevels() func; Case 3: no level", in: "server", wantErr: true},
+		{name: "Test ParseLevels() func; Case 4: unknown level", in: "server=loud", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseLevels(tc.in)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestModuleLevels(t *testing.T) {
+	assert.NoError(t, Init(false, "storage=warn"))
+	defer func() { assert.NoError(t, Init(false, "")) }()
+	var buf bytes.Buffer
+	storageLog := Named(ModuleStorage).Output(&buf)
+	serverLog := Named(ModuleServer).Output(&buf)
+
+	storageLog.Info().Msg("storage info")
+	serverLog.Info().Msg("server info")
+	serverLog.Debug().Msg("server debug")
+	assert.NotContains(t, buf.String(), "storage info")
+	assert.Contains(t, buf.String(), `"module":"server"`)
+	assert.NotContains(t, buf.String(), "server debug")
+
+	// уровни меняются на лету, новый уровень получают логгеры, взятые после смены
+	SetLevel(ModuleStorage, zerolog.DebugLevel)
+	SetLevel("", zerolog.DebugLevel)
+	buf.Reset()
+	storageLog = Named(ModuleStorage).Output(&buf)
+	serverLog = Named(ModuleServer).Output(&buf)
+	storageLog.Debug().Msg("storage debug")
+	serverLog.Debug().Msg("server debug")
+	assert.Contains(t, buf.String(), "storage debug")
+	assert.Contains(t, buf.String(), "server debug")
+}
+
+func TestDisabledLevelSkipsEvent(t *testing.T) {
+	assert.NoError(t, Init(false, "storage=warn,custom=error"))
+	defer func() { assert.NoError(t, Init(false, "")) }()
+	storageLog := Named(ModuleStorage)
+	customLog := Named("custom")
+	otherLog := Named("other")
+	zLog := Get()
+
+	// выключенный уровень отсекается до создания записи, а не в хуке
+	assert.Nil(t, storageLog.Info())
+	assert.NotNil(t, storageLog.Warn())
+	assert.Nil(t, customLog.Warn())
+	assert.Nil(t, otherLog.Debug())
+	assert.NotNil(t, otherLog.Info())
+	assert.Nil(t, zLog.Debug())
+}
+
+func TestGetWithoutInit(t *testing.T) {
+	assert.NotPanics(t, func() {
+		zLog := Get()
+		zLog.Debug().Msg("no init")
+	})
+}
+
+func TestLevelHandler(t *testing.T) {
+	assert.NoError(t, Init(false, ""))
+	defer func() { assert.NoError(t, Init(false, "")) }()
+	h := LevelHandler()
+	testCases := []struct {
+		name       string
+		method     string
+		body       string
+		statusCode int
+		contains   string
+	}{
+		{name: "Test LevelHandler() func; Case 1: get", method: http.MethodGet, statusCode: http.StatusOK, contains: `"default":"info"`},
+		{
+			name:       "Test LevelHandler() func; Case 2: set module level",
+			method:     http.MethodPut,
+			body:       `{"module":"grpc","level":"debug"}`,
+			statusCode: http.StatusOK,
+			contains:   `"grpc":"debug"`,
+		},
+		{name: "Test LevelHandler() func; Case 3: bad level", method: http.MethodPut, body: `{"module":"grpc","level":"loud"}`, statusCode: http.StatusBadRequest},
+		{name: "Test LevelHandler() func; Case 4: method", method: http.MethodDelete, statusCode: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tc.method, "/log/level", strings.NewReader(tc.body)))
+			assert.Equal(t, tc.statusCode, w.Code)
+			assert.Contains(t, w.Body.String(), tc.contains)
+		})
+	}
+	assert.Equal(t, zerolog.DebugLevel, Named(ModuleGRPC).GetLevel())
+	assert.Equal(t, zerolog.InfoLevel, Named(ModuleStorage).GetLevel())
+}
@@ -1,49 +1,138 @@
 package logger
 
 import (
+	"context"
 	"os"
 	"strconv"
 	"sync"
 
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var once sync.Once //такая переменная, что отработает 1 раз
-
-var log zerolog.Logger
-
-func Get(flags ...bool) zerolog.Logger {
-	once.Do(func() {
-		zerolog.TimestampFieldName = "Time"
-		zerolog.LevelFieldName = "Level"
-		zerolog.CallerMarshalFunc = func(_ uintptr, file string, line int) string {
-			short := file
-			for i := len(file) - 1; i > 0; i-- {
-				if file[i] == '/' {
-					short = file[i+1:]
-					break
-				}
+// Имена модулей для Named и для уровней в конфиге.
+const (
+	ModuleServer  = "server"
+	ModuleStorage = "storage"
+	ModuleGRPC    = "grpc"
+)
+
+var (
+	mu   sync.RWMutex
+	base *zerolog.Logger
+)
+
+func init() {
+	zerolog.TimestampFieldName = "Time"
+	zerolog.LevelFieldName = "Level"
+	zerolog.CallerMarshalFunc = func(_ uintptr, file string, line int) string {
+		short := file
+		for i := len(file) - 1; i > 0; i-- {
+			if file[i] == '/' {
+				short = file[i+1:]
+				break
 			}
-			file = short
-			return file + ":" + strconv.Itoa(line)
-		} //добавили номер строки и название файла
-		if flags[0] {
-			log = zerolog.New(os.Stdout).
-				Level(zerolog.DebugLevel).
-				With().
-				Timestamp().
-				Caller().
-				Logger().
-				Output(zerolog.ConsoleWriter{Out: os.Stderr})
-		} else {
-			log = zerolog.New(os.Stdout).
-				Level(zerolog.InfoLevel).
-				With().
-				Timestamp().
-				Caller().
-				Logger()
-		} //если flag поля Config.Debug = true, все логи уровня debug и выше выводим на экран
-		//если flag поля Config.Debug = false, все логи уровня info и выше выводим на экран(без debug)
-	})
-	return log
+		}
+		file = short
+		return file + ":" + strconv.Itoa(line)
+	} //добавили номер строки и название файла
+}
+
+// Init настраивает вывод и уровни логирования. debug включает уровень debug по умолчанию
+// и человекочитаемый вывод в stderr; levels задаёт уровни модулей, например "storage=warn,grpc=debug".
+// Вызывается один раз при старте, до этого Get отдаёт логгер уровня info.
+func Init(debug bool, levels string) error {
+	modules, err := ParseLevels(levels)
+	if err != nil {
+		return err
+	}
+	defaultLevel := zerolog.InfoLevel
+	if debug {
+		defaultLevel = zerolog.DebugLevel
+	}
+	l := newBase(debug)
+	mu.Lock()
+	base = &l
+	mu.Unlock()
+	setLevels(defaultLevel, modules)
+	return nil
+}
+
+// newBase пропускает все уровни: уровень выставляют логгеры модулей, которые собирает rebuild.
+func newBase(debug bool) zerolog.Logger {
+	if debug {
+		return zerolog.New(os.Stdout).
+			Level(zerolog.TraceLevel).
+			With().
+			Timestamp().
+			Caller().
+			Logger().
+			Output(NewRedactWriter(zerolog.ConsoleWriter{Out: os.Stderr}))
+	}
+	return zerolog.New(NewRedactWriter(os.Stdout)).
+		Level(zerolog.TraceLevel).
+		With().
+		Timestamp().
+		Caller().
+		Logger()
+}
+
+func root() zerolog.Logger {
+	mu.RLock()
+	l := base
+	mu.RUnlock()
+	if l != nil {
+		return *l
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if base == nil {
+		b := newBase(false)
+		base = &b
+	}
+	return *base
+}
+
+// Get возвращает общий логгер с уровнем по умолчанию.
+func Get() zerolog.Logger {
+	return current().def
+}
+
+// Named возвращает логгер модуля: в записях есть поле module, уровень берётся из настроек модуля.
+// Уровень фиксируется при вызове, поэтому логгер берут заново в каждой функции, а не хранят в полях.
+func Named(module string) zerolog.Logger {
+	set := current()
+	if l, ok := set.modules[module]; ok {
+		return l
+	}
+	return set.named(module, set.defLevel)
+}
+
+// Trace добавляет к l trace_id и span_id активного спана из ctx,
+// чтобы записи лога можно было сопоставить с трассой запроса.
+func Trace(ctx context.Context, l zerolog.Logger) zerolog.Logger {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() {
+		return l
+	}
+	return l.With().
+		Str("trace_id", spanCtx.TraceID().String()).
+		Str("span_id", spanCtx.SpanID().String()).
+		Logger()
+}
+
+type ctxKey struct{}
+
+// NewContext кладёт логгер запроса в контекст.
+func NewContext(ctx context.Context, l zerolog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext возвращает логгер запроса, положенный middleware в контекст.
+// Если его нет, возвращается логгер модуля server с trace_id из ctx.
+func FromContext(ctx context.Context) zerolog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(zerolog.Logger); ok {
+		return l
+	}
+	return Trace(ctx, Named(ModuleServer))
 }
@@ -0,0 +1,49 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// CacheStat - счётчики кеша. Ему удовлетворяет *cache.Storage.
+type CacheStat interface {
+	Hits() uint64
+	Misses() uint64
+	Len() int
+}
+
+// cacheCollector снимает счётчики кеша при каждом запросе /metrics.
+type cacheCollector struct {
+	stat CacheStat
+
+	hits    *prometheus.Desc
+	misses  *prometheus.Desc
+	entries *prometheus.Desc
+}
+
+// RegisterCache регистрирует метрики кеша с меткой cache=name.
+func RegisterCache(name string, stat CacheStat) error {
+	return Registry.Register(newCacheCollector(name, stat))
+}
+
+func newCacheCollector(name string, stat CacheStat) *cacheCollector {
+	labels := prometheus.Labels{"cache": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache", metric), help, nil, labels)
+	}
+	return &cacheCollector{
+		stat:    stat,
+		hits:    desc("hits_total", "Ответы, отданные из кеша."),
+		misses:  desc("misses_total", "Промахи, ушедшие в хранилище."),
+		entries: desc("entries", "Записи в кеше."),
+	}
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.entries
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(c.stat.Hits()))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(c.stat.Misses()))
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(c.stat.Len()))
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcRequests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc_client",
+		Name:      "requests_total",
+		Help:      "Количество исходящих gRPC-вызовов по методу и коду ответа.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc_client",
+		Name:      "request_duration_seconds",
+		Help:      "Время исходящих gRPC-вызовов.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// UnaryClientInterceptor считает исходящие unary-вызовы gRPC и их длительность.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		grpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+		grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
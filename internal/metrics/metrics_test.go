@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinMiddleware())
+	r.GET("/book/:id", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	for _, path := range []string{"/book/1", "/book/2", "/missing"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	assert.Equal(t, float64(2), testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/book/:id", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")))
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor()
+	invoker := func(_ context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	err := interceptor(context.Background(), "/auth.AuthService/Login", nil, nil, nil, invoker)
+	assert.Error(t, err)
+	assert.Equal(t, float64(1),
+		testutil.ToFloat64(grpcRequests.WithLabelValues("/auth.AuthService/Login", codes.Unauthenticated.String())))
+}
+
+type fakeStat struct{}
+
+func (fakeStat) AcquireCount() int64            { return 10 }
+func (fakeStat) AcquireDuration() time.Duration { return time.Second }
+func (fakeStat) AcquiredConns() int32           { return 2 }
+func (fakeStat) CanceledAcquireCount() int64    { return 0 }
+func (fakeStat) ConstructingConns() int32       { return 0 }
+func (fakeStat) EmptyAcquireCount() int64       { return 1 }
+func (fakeStat) IdleConns() int32               { return 3 }
+func (fakeStat) MaxConns() int32                { return 4 }
+func (fakeStat) TotalConns() int32              { return 5 }
+
+func TestPoolCollector(t *testing.T) {
+	collector := newPoolCollector("test", func() PoolStat { return fakeStat{} })
+	expected := `
+# HELP library_db_pool_idle_conns Простаивающие соединения.
+# TYPE library_db_pool_idle_conns gauge
+library_db_pool_idle_conns{pool="test"} 3
+# HELP library_db_pool_max_conns Максимальный размер пула.
+# TYPE library_db_pool_max_conns gauge
+library_db_pool_max_conns{pool="test"} 4
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"library_db_pool_idle_conns", "library_db_pool_max_conns"))
+}
+
+type fakeCache struct{}
+
+func (fakeCache) Hits() uint64   { return 7 }
+func (fakeCache) Misses() uint64 { return 3 }
+func (fakeCache) Len() int       { return 2 }
+
+func TestCacheCollector(t *testing.T) {
+	collector := newCacheCollector("test", fakeCache{})
+	expected := `
+# HELP library_cache_hits_total Ответы, отданные из кеша.
+# TYPE library_cache_hits_total counter
+library_cache_hits_total{cache="test"} 7
+# HELP library_cache_misses_total Промахи, ушедшие в хранилище.
+# TYPE library_cache_misses_total counter
+library_cache_misses_total{cache="test"} 3
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"library_cache_hits_total", "library_cache_misses_total"))
+}
+
+func TestReplicaCollector(t *testing.T) {
+	collector := newReplicaCollector(func() []ReplicaStat {
+		return []ReplicaStat{
+			{Name: "db-replica-1:5432", Healthy: true, Lag: 1500 * time.Millisecond},
+			{Name: "db-replica-2:5432", Healthy: false},
+		}
+	})
+	expected := `
+# HELP library_db_replica_healthy 1, если реплика обслуживает чтения, 0 - если чтения ушли в primary.
+# TYPE library_db_replica_healthy gauge
+library_db_replica_healthy{replica="db-replica-1:5432"} 1
+library_db_replica_healthy{replica="db-replica-2:5432"} 0
+# HELP library_db_replica_lag_seconds Отставание реплики от primary.
+# TYPE library_db_replica_lag_seconds gauge
+library_db_replica_lag_seconds{replica="db-replica-1:5432"} 1.5
+library_db_replica_lag_seconds{replica="db-replica-2:5432"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
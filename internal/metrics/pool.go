@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolStat - снимок состояния пула соединений. Ему удовлетворяет *pgxpool.Stat.
+type PoolStat interface {
+	AcquireCount() int64
+	AcquireDuration() time.Duration
+	AcquiredConns() int32
+	CanceledAcquireCount() int64
+	ConstructingConns() int32
+	EmptyAcquireCount() int64
+	IdleConns() int32
+	MaxConns() int32
+	TotalConns() int32
+}
+
+// poolCollector снимает статистику пула при каждом запросе /metrics.
+type poolCollector struct {
+	stat func() PoolStat
+
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	acquiredConns        *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+	constructingConns    *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	idleConns            *prometheus.Desc
+	maxConns             *prometheus.Desc
+	totalConns           *prometheus.Desc
+}
+
+// RegisterPool регистрирует метрики пула соединений с меткой pool=name.
+func RegisterPool(name string, stat func() PoolStat) error {
+	return Registry.Register(newPoolCollector(name, stat))
+}
+
+func newPoolCollector(name string, stat func() PoolStat) *poolCollector {
+	labels := prometheus.Labels{"pool": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", metric), help, nil, labels)
+	}
+	return &poolCollector{
+		stat:                 stat,
+		acquireCount:         desc("acquire_total", "Количество успешных получений соединения из пула."),
+		acquireDuration:      desc("acquire_duration_seconds_total", "Суммарное время ожидания соединения."),
+		acquiredConns:        desc("acquired_conns", "Соединения, занятые в данный момент."),
+		canceledAcquireCount: desc("canceled_acquire_total", "Получения соединения, отменённые контекстом."),
+		constructingConns:    desc("constructing_conns", "Соединения, которые сейчас устанавливаются."),
+		emptyAcquireCount:    desc("empty_acquire_total", "Получения, которым пришлось ждать свободное соединение."),
+		idleConns:            desc("idle_conns", "Простаивающие соединения."),
+		maxConns:             desc("max_conns", "Максимальный размер пула."),
+		totalConns:           desc("total_conns", "Всего соединений в пуле."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquireCount
+	ch <- c.acquireDuration
+	ch <- c.acquiredConns
+	ch <- c.canceledAcquireCount
+	ch <- c.constructingConns
+	ch <- c.emptyAcquireCount
+	ch <- c.idleConns
+	ch <- c.maxConns
+	ch <- c.totalConns
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stat()
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquireCount, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.constructingConns, prometheus.GaugeValue, float64(s.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(s.TotalConns()))
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReplicaStat - состояние реплики БД на момент последней проверки.
+type ReplicaStat struct {
+	Name    string
+	Healthy bool
+	Lag     time.Duration
+}
+
+// replicaCollector снимает состояние реплик при каждом запросе /metrics.
+type replicaCollector struct {
+	stat func() []ReplicaStat
+
+	lag     *prometheus.Desc
+	healthy *prometheus.Desc
+}
+
+// RegisterReplicas регистрирует метрики отставания и доступности реплик с меткой replica.
+func RegisterReplicas(stat func() []ReplicaStat) error {
+	return Registry.Register(newReplicaCollector(stat))
+}
+
+func newReplicaCollector(stat func() []ReplicaStat) *replicaCollector {
+	labels := []string{"replica"}
+	return &replicaCollector{
+		stat: stat,
+		lag: prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_replica", "lag_seconds"),
+			"Отставание реплики от primary.", labels, nil),
+		healthy: prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_replica", "healthy"),
+			"1, если реплика обслуживает чтения, 0 - если чтения ушли в primary.", labels, nil),
+	}
+}
+
+func (c *replicaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lag
+	ch <- c.healthy
+}
+
+func (c *replicaCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.stat() {
+		healthy := 0.0
+		if s.Healthy {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, s.Lag.Seconds(), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.healthy, prometheus.GaugeValue, healthy, s.Name)
+	}
+}
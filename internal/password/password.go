@@ -0,0 +1,72 @@
+package password
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher хеширует пароли пользователей и проверяет их при входе.
+type Hasher interface {
+	Hash(pass string) (string, error)
+	// Verify сравнивает пароль с сохранённым значением. needsRehash = true, когда пароль верный,
+	// но сохранённое значение устарело и его нужно перезаписать свежим хешем.
+	Verify(stored, pass string) (ok bool, needsRehash bool, err error)
+}
+
+// Bcrypt реализует Hasher на bcrypt с заданной стоимостью.
+type Bcrypt struct {
+	cost int
+	// plaintext разрешает записи, сохранённые до перехода на bcrypt в открытом виде
+	plaintext bool
+}
+
+// NewBcrypt создаёт Bcrypt. Хеши с другой стоимостью прозрачно перехешируются при входе.
+// Значение, не похожее на bcrypt-хеш, ни с каким паролем не совпадает.
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{cost: cost}
+}
+
+// NewLegacyBcrypt - NewBcrypt, который принимает и пароли, хранящиеся в открытом виде, и просит их перехешировать.
+// Нужен только на время перевода старых записей: пока он включён, утечка базы раскрывает такие пароли.
+func NewLegacyBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{cost: cost, plaintext: true}
+}
+
+// Default возвращает Hasher, которым пользуются хранилища по умолчанию.
+func Default() Hasher {
+	return NewBcrypt(bcrypt.DefaultCost)
+}
+
+// Legacy - Default, который ещё принимает пароли в открытом виде, см. NewLegacyBcrypt.
+func Legacy() Hasher {
+	return NewLegacyBcrypt(bcrypt.DefaultCost)
+}
+
+func (b *Bcrypt) Hash(pass string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(stored, pass string) (bool, bool, error) {
+	cost, err := bcrypt.Cost([]byte(stored))
+	if err != nil {
+		if !b.plaintext {
+			return false, false, nil
+		}
+		// старые записи хранят пароль в открытом виде: сверяем и сразу просим перехешировать
+		ok := subtle.ConstantTimeCompare([]byte(stored), []byte(pass)) == 1
+		return ok, ok, nil
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(stored), []byte(pass)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, cost != b.cost, nil
+}
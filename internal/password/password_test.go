@@ -0,0 +1,87 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBcryptVerify(t *testing.T) {
+	hasher := NewBcrypt(bcrypt.MinCost)
+	hash, err := hasher.Hash("qwerty1234")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "qwerty1234", hash)
+	weakHash, err := NewBcrypt(bcrypt.MinCost + 1).Hash("qwerty1234")
+	assert.NoError(t, err)
+
+	type want struct {
+		ok          bool
+		needsRehash bool
+	}
+	tests := []struct {
+		name   string
+		legacy bool
+		stored string
+		pass   string
+		want   want
+	}{
+		{
+			name:   "Test Verify() func; Case 1: valid hash",
+			stored: hash,
+			pass:   "qwerty1234",
+			want:   want{ok: true, needsRehash: false},
+		},
+		{
+			name:   "Test Verify() func; Case 2: wrong password",
+			stored: hash,
+			pass:   "qwerty",
+			want:   want{ok: false, needsRehash: false},
+		},
+		{
+			name:   "Test Verify() func; Case 3: other cost",
+			stored: weakHash,
+			pass:   "qwerty1234",
+			want:   want{ok: true, needsRehash: true},
+		},
+		{
+			name:   "Test Verify() func; Case 4: plaintext rejected",
+			stored: "qwerty1234",
+			pass:   "qwerty1234",
+			want:   want{ok: false, needsRehash: false},
+		},
+		{
+			name:   "Test Verify() func; Case 5: legacy plaintext",
+			legacy: true,
+			stored: "qwerty1234",
+			pass:   "qwerty1234",
+			want:   want{ok: true, needsRehash: true},
+		},
+		{
+			name:   "Test Verify() func; Case 6: legacy plaintext mismatch",
+			legacy: true,
+			stored: "qwerty1234",
+			pass:   "qwerty",
+			want:   want{ok: false, needsRehash: false},
+		},
+		{
+			name:   "Test Verify() func; Case 7: legacy mode valid hash",
+			legacy: true,
+			stored: hash,
+			pass:   "qwerty1234",
+			want:   want{ok: true, needsRehash: false},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := hasher
+			if tc.legacy {
+				h = NewLegacyBcrypt(bcrypt.MinCost)
+			}
+			ok, needsRehash, err := h.Verify(tc.stored, tc.pass)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.ok, ok)
+			assert.Equal(t, tc.want.needsRehash, needsRehash)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Header - HTTP-заголовок с идентификатором запроса.
+	Header = "X-Request-ID"
+	// MetadataKey - ключ gRPC-метаданных, в котором идентификатор уходит в auth и books сервисы.
+	MetadataKey = "x-request-id"
+
+	maxLen = 128
+)
+
+type ctxKey struct{}
+
+// New генерирует новый идентификатор запроса.
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid проверяет идентификатор, пришедший от клиента: непустой, не длиннее 128 символов
+// и только из печатных ASCII-символов, чтобы его можно было безопасно писать в лог.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext кладёт идентификатор запроса в контекст.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext достаёт идентификатор запроса из контекста.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxKey{}).(string)
+	return id, ok && id != ""
+}
+
+// UnaryClientInterceptor передаёт идентификатор запроса из контекста в исходящие gRPC-метаданные.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if id, ok := FromContext(ctx); ok {
+			ctx = metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
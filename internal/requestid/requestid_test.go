@@ -0,0 +1,47 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestValid(t *testing.T) {
+	testCases := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{name: "Test Valid() func; Case 1: uuid", id: New(), want: true},
+		{name: "Test Valid() func; Case 2: empty", id: "", want: false},
+		{name: "Test Valid() func; Case 3: too long", id: strings.Repeat("a", maxLen+1), want: false},
+		{name: "Test Valid() func; Case 4: newline", id: "abc\ninjected", want: false},
+		{name: "Test Valid() func; Case 5: space", id: "abc def", want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Valid(tc.id))
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	interceptor := UnaryClientInterceptor()
+	var got []string
+	invoker := func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		got = md.Get(MetadataKey)
+		return nil
+	}
+
+	ctx := NewContext(context.Background(), "req-1")
+	assert.NoError(t, interceptor(ctx, "/auth.AuthService/Login", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"req-1"}, got)
+
+	assert.NoError(t, interceptor(context.Background(), "/auth.AuthService/Login", nil, nil, nil, invoker))
+	assert.Empty(t, got)
+}
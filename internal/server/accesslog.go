@@ -0,0 +1,51 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// AccessLog принимает X-Request-ID от клиента или генерирует новый, возвращает его в ответе,
+// кладёт в контекст запроса логгер с request_id и trace_id и пишет строку access-лога.
+// Должен стоять после otelgin, чтобы в логгер попал trace_id.
+func AccessLog() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		id := ctx.GetHeader(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		ctx.Header(requestid.Header, id)
+		reqCtx := requestid.NewContext(ctx.Request.Context(), id)
+		zLog := logger.Trace(reqCtx, logger.Named(logger.ModuleServer)).With().Str("request_id", id).Logger()
+		ctx.Request = ctx.Request.WithContext(logger.NewContext(reqCtx, zLog))
+
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ctx.Writer.Status()
+		var event *zerolog.Event
+		switch {
+		case status >= 500:
+			event = zLog.Error()
+		case status >= 400:
+			event = zLog.Warn()
+		default:
+			event = zLog.Info()
+		}
+		event.Str("method", ctx.Request.Method).
+			Str("route", route).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Int("bytes", ctx.Writer.Size()).
+			Str("client_ip", ctx.ClientIP()).
+			Msg("request")
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(AccessLog())
+	var (
+		seenID string
+		logBuf bytes.Buffer
+	)
+	r.GET("/book/:id", func(ctx *gin.Context) {
+		seenID, _ = requestid.FromContext(ctx.Request.Context())
+		zLog := logger.FromContext(ctx.Request.Context()).Output(&logBuf)
+		zLog.Info().Msg("handler")
+		ctx.Status(http.StatusOK)
+	})
+	testCases := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "Test AccessLog() func; Case 1: generated id", incoming: ""},
+		{name: "Test AccessLog() func; Case 2: client id is kept", incoming: "client-req-42", keep: true},
+		{name: "Test AccessLog() func; Case 3: invalid client id is replaced", incoming: "bad id\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logBuf.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/book/1", nil)
+			if tc.incoming != "" {
+				req.Header.Set(requestid.Header, tc.incoming)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			got := w.Header().Get(requestid.Header)
+			assert.True(t, requestid.Valid(got))
+			assert.Equal(t, got, seenID)
+			if tc.keep {
+				assert.Equal(t, tc.incoming, got)
+			} else {
+				assert.NotEqual(t, tc.incoming, got)
+			}
+			assert.Contains(t, logBuf.String(), `"request_id":"`+got+`"`)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	apiKeyPrefix      = "lsk_"
+	apiKeySecretBytes = 24
+	apiKeyShownPrefix = 12
+)
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name" validate:"required"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=books:read books:write users:admin"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyHandler выпускает API-ключ для текущего пользователя.
+// Ключ возвращается в ответе один раз, в хранилище попадает только его хеш.
+func (s *Server) CreateAPIKeyHandler(ctx *gin.Context) {
+	zLog := logger.FromContext(ctx.Request.Context())
+	principal, ok := principalFrom(ctx)
+	if !ok || principal.APIKeyID != "" {
+		writeProblem(ctx, errMess.New(errMess.CodeForbidden, "api keys can only be managed with a user token"))
+		return
+	}
+	var req createAPIKeyRequest
+	if err := ctx.ShouldBindBodyWithJSON(&req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.validator.Struct(req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !principal.HasScope(scope) {
+			writeProblem(ctx, errMess.New(errMess.CodeForbidden, "scope "+scope+" is not allowed"))
+			return
+		}
+	}
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		writeProblem(ctx, errMess.New(errMess.CodeBadRequest, "expires_at must be in the future"))
+		return
+	}
+	secret, err := randomHex(apiKeySecretBytes)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	raw := apiKeyPrefix + secret
+	key := models.APIKey{
+		ID:        uuid.NewString(),
+		Name:      req.Name,
+		Prefix:    raw[:apiKeyShownPrefix],
+		Hash:      hashToken(raw),
+		OwnerUID:  principal.UserID,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: now,
+	}
+	if err = s.storage.SaveAPIKey(ctx.Request.Context(), key); err != nil {
+		zLog.Error().Err(err).Msg("failed to save api key")
+		writeProblem(ctx, err)
+		return
+	}
+	zLog.Info().Str("key_id", key.ID).Str("owner", key.OwnerUID).Strs("scopes", key.Scopes).Msg("api key created")
+	ctx.JSON(http.StatusCreated, gin.H{"key": raw, "api_key": key})
+}
+
+// APIKeysHandler возвращает ключи текущего пользователя без самих секретов.
+func (s *Server) APIKeysHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	keys, err := s.storage.GetAPIKeysByOwner(ctx.Request.Context(), principal.UserID)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKeyHandler отзывает ключ. Чужие ключи может отзывать только клиент с users:admin.
+func (s *Server) RevokeAPIKeyHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	id := ctx.Param("id")
+	key, err := s.storage.GetAPIKeyByID(ctx.Request.Context(), id)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if key.OwnerUID != principal.UserID && !principal.HasScope(models.ScopeUsersAdmin) {
+		writeProblem(ctx, storage.ErrAPIKeyNotFound)
+		return
+	}
+	if err = s.storage.RevokeAPIKey(ctx.Request.Context(), id); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key successfully revoked"})
+}
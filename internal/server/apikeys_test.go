@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAuthenticate(t *testing.T) {
+	srv := &Server{
+		validator: validator.New(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/books", srv.Authenticate(), RequireScope(models.ScopeBooksWrite), func(ctx *gin.Context) {
+		principal, _ := principalFrom(ctx)
+		ctx.JSON(http.StatusOK, gin.H{"uid": principal.UserID, "key": principal.APIKeyID})
+	})
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	past := time.Now().Add(-time.Hour)
+	testCases := []struct {
+		name       string
+		headers    map[string]string
+		mockSetup  func(*mocks.MockStorage)
+		statusCode int
+		contains   string
+	}{
+		{
+			name:    "Test Authenticate() func; Case 1: api key",
+			headers: map[string]string{apiKeyHeader: "lsk_valid"},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetAPIKeyByHash(gomock.Any(), hashToken("lsk_valid")).Return(models.APIKey{
+					ID: "kid", OwnerUID: "uid", Scopes: []string{models.ScopeBooksWrite},
+				}, nil)
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleMember}, nil)
+			},
+			statusCode: http.StatusOK,
+			contains:   `"key":"kid"`,
+		},
+		{
+			name:    "Test Authenticate() func; Case 2: api key without scope",
+			headers: map[string]string{apiKeyHeader: "lsk_read"},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetAPIKeyByHash(gomock.Any(), gomock.Any()).Return(models.APIKey{
+					ID: "kid", OwnerUID: "uid", Scopes: []string{models.ScopeBooksRead},
+				}, nil)
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid"}, nil)
+			},
+			statusCode: http.StatusForbidden,
+			contains:   "missing scope",
+		},
+		{
+			name:    "Test Authenticate() func; Case 3: expired api key",
+			headers: map[string]string{apiKeyHeader: "lsk_expired"},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetAPIKeyByHash(gomock.Any(), gomock.Any()).Return(models.APIKey{
+					ID: "kid", OwnerUID: "uid", Scopes: []string{models.ScopeBooksWrite}, ExpiresAt: &past,
+				}, nil)
+			},
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "Test Authenticate() func; Case 4: unknown api key",
+			headers: map[string]string{apiKeyHeader: "lsk_unknown"},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetAPIKeyByHash(gomock.Any(), gomock.Any()).Return(models.APIKey{}, storage.ErrAPIKeyNotFound)
+			},
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "Test Authenticate() func; Case 5: bearer jwt",
+			headers: map[string]string{"Authorization": "Bearer " + testJWT(t, "uid")},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleMember}, nil)
+			},
+			statusCode: http.StatusOK,
+			contains:   `"uid":"uid"`,
+		},
+		{
+			name:       "Test Authenticate() func; Case 6: no credentials",
+			statusCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mockStorage)
+			}
+			srv.storage = mockStorage
+			resp, err := resty.New().R().SetHeaders(tc.headers).Get(httpSrv.URL + "/books")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.contains)
+		})
+	}
+}
+
+func TestCreateAPIKeyHandler(t *testing.T) {
+	srv := &Server{
+		validator: validator.New(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api-keys", srv.Authenticate(), srv.CreateAPIKeyHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "uid")
+	testCases := []struct {
+		name       string
+		headers    map[string]string
+		body       string
+		mockSetup  func(*mocks.MockStorage)
+		statusCode int
+		contains   string
+	}{
+		{
+			name:    "Test CreateAPIKeyHandler() func; Case 1: OK",
+			headers: map[string]string{"Authorization": token},
+			body:    `{"name":"ingest","scopes":["books:read","books:write"]}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleLibrarian}, nil)
+				m.EXPECT().SaveAPIKey(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key models.APIKey) error {
+					assert.Equal(t, "uid", key.OwnerUID)
+					assert.NotEmpty(t, key.Hash)
+					return nil
+				})
+			},
+			statusCode: http.StatusCreated,
+			contains:   `"key":"lsk_`,
+		},
+		{
+			name:    "Test CreateAPIKeyHandler() func; Case 2: scope above role",
+			headers: map[string]string{"Authorization": token},
+			body:    `{"name":"ingest","scopes":["users:admin"]}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleMember}, nil)
+			},
+			statusCode: http.StatusForbidden,
+			contains:   "not allowed",
+		},
+		{
+			name:    "Test CreateAPIKeyHandler() func; Case 3: unknown scope",
+			headers: map[string]string{"Authorization": token},
+			body:    `{"name":"ingest","scopes":["books:burn"]}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleAdmin}, nil)
+			},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:    "Test CreateAPIKeyHandler() func; Case 4: api key cannot create keys",
+			headers: map[string]string{apiKeyHeader: "lsk_valid"},
+			body:    `{"name":"ingest","scopes":["books:read"]}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetAPIKeyByHash(gomock.Any(), gomock.Any()).Return(models.APIKey{
+					ID: "kid", OwnerUID: "uid", Scopes: []string{models.ScopeBooksRead},
+				}, nil)
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid"}, nil)
+			},
+			statusCode: http.StatusForbidden,
+			contains:   "user token",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mockStorage)
+			}
+			srv.storage = mockStorage
+			resp, err := resty.New().R().SetHeaders(tc.headers).SetBody(tc.body).Post(httpSrv.URL + "/api-keys")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.contains)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// mergePatchContentType - тип тела JSON Merge Patch (RFC 7396).
+const mergePatchContentType = "application/merge-patch+json"
+
+var errUnsupportedPatch = errMess.New(errMess.CodeUnsupportedMedia, "PATCH body must be "+mergePatchContentType)
+
+// updateBookRequest - изменяемые поля книги. Владелец, id и дата создания не меняются.
+type updateBookRequest struct {
+	Label  string `json:"label" validate:"required"`
+	Author string `json:"author" validate:"required"`
+}
+
+// UpdateBookHandler заменяет изменяемые поля книги целиком. If-Match обязателен.
+func (s *Server) UpdateBookHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errInvalidToken)
+		return
+	}
+	version, err := ifMatch(ctx)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	var req updateBookRequest
+	if err := ctx.ShouldBindBodyWithJSON(&req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.validator.Struct(req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	s.updateBook(ctx, principal.UserID, models.Book{
+		BID:     ctx.Param("id"),
+		Label:   req.Label,
+		Author:  req.Author,
+		Version: version,
+	})
+}
+
+// PatchBookHandler частично обновляет книгу по JSON Merge Patch: переданные поля заменяются,
+// null очищает поле, остальные остаются как были. If-Match обязателен.
+func (s *Server) PatchBookHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errInvalidToken)
+		return
+	}
+	if ct := ctx.ContentType(); ct != mergePatchContentType && ct != gin.MIMEJSON {
+		writeProblem(ctx, errUnsupportedPatch)
+		return
+	}
+	version, err := ifMatch(ctx)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := ctx.ShouldBindBodyWithJSON(&patch); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if patch == nil {
+		writeProblem(ctx, errMess.New(errMess.CodeBadRequest, "merge patch must be a JSON object"))
+		return
+	}
+	// основа патча читается из primary мимо кеша: по устаревшей копии If-Match: * затёр бы чужую правку
+	book, err := s.storage.GetBookByID(storage.WithPrimary(ctx.Request.Context()), ctx.Param("id"))
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	// хранилище ещё раз сверит версию при записи: прочитанная здесь запись могла устареть
+	if version != 0 && version != book.Version {
+		writeProblem(ctx, storage.ErrVersionMismatch)
+		return
+	}
+	req := updateBookRequest{Label: book.Label, Author: book.Author}
+	for field, value := range patch {
+		var target *string
+		switch field {
+		case "label":
+			target = &req.Label
+		case "author":
+			target = &req.Author
+		default:
+			writeProblem(ctx, errMess.New(errMess.CodeBadRequest, "field "+field+" can not be changed"))
+			return
+		}
+		if bytes.Equal(value, []byte("null")) {
+			*target = ""
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			writeProblem(ctx, err)
+			return
+		}
+	}
+	if err := s.validator.Struct(req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	book.Label, book.Author = req.Label, req.Author
+	s.updateBook(ctx, principal.UserID, book)
+}
+
+// updateBook сохраняет книгу от имени editor и отвечает новой версией с её ETag.
+func (s *Server) updateBook(ctx *gin.Context, editor string, book models.Book) {
+	zLog := logger.FromContext(ctx.Request.Context())
+	updated, err := s.storage.UpdateBook(ctx.Request.Context(), book, editor)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	zLog.Debug().Str("bid", updated.BID).Int64("version", updated.Version).Str("editor", editor).Msg("book updated")
+	ctx.Header("ETag", versionETag(updated.Version))
+	ctx.JSON(http.StatusOK, updated)
+}
+
+// BookHistoryHandler возвращает прежние версии книги от старой к новой.
+func (s *Server) BookHistoryHandler(ctx *gin.Context) {
+	history, err := s.storage.GetBookHistory(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	writeHashed(ctx, history)
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestUpdateBookHandlers(t *testing.T) {
+	srv := &Server{
+		validator: newValidator(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/book/:id/history", srv.Authenticate(), RequireScope(models.ScopeBooksRead), srv.BookHistoryHandler)
+	r.PUT("/book/:id", srv.Authenticate(), RequireScope(models.ScopeBooksWrite), srv.UpdateBookHandler)
+	r.PATCH("/book/:id", srv.Authenticate(), RequireScope(models.ScopeBooksWrite), srv.PatchBookHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "editor")
+	editor := models.User{UID: "editor", Role: models.RoleMember}
+	stored := models.Book{BID: "bid", Label: "Dune", Author: "Herbert", UserUID: "owner", Version: 3}
+	// updated возвращает книгу после изменения так, как это сделало бы хранилище
+	updated := func(_ context.Context, book models.Book, _ string) (models.Book, error) {
+		book.UserUID = "owner"
+		book.Version = 4
+		return book, nil
+	}
+	mergePatch := map[string]string{"Content-Type": mergePatchContentType, "If-Match": `"3"`}
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		header     map[string]string
+		mockSetup  func(*mocks.MockStorage)
+		statusCode int
+		contains   string
+		etag       string
+	}{
+		{
+			name:   "Test UpdateBookHandler() func; Case 1: replace book",
+			method: http.MethodPut,
+			path:   "/book/bid",
+			header: map[string]string{"If-Match": `"3"`},
+			body:   `{"label":"Dune Messiah","author":"Frank Herbert","user_uid":"other"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().UpdateBook(gomock.Any(), models.Book{BID: "bid", Label: "Dune Messiah", Author: "Frank Herbert", Version: 3}, "editor").DoAndReturn(updated)
+			},
+			statusCode: http.StatusOK,
+			contains:   `"user_uid":"owner"`,
+			etag:       `"4"`,
+		},
+		{
+			name:       "Test UpdateBookHandler() func; Case 2: missing field",
+			method:     http.MethodPut,
+			path:       "/book/bid",
+			header:     map[string]string{"If-Match": `"3"`},
+			body:       `{"label":"Dune Messiah"}`,
+			statusCode: http.StatusBadRequest,
+			contains:   `"field":"author"`,
+		},
+		{
+			name:       "Test UpdateBookHandler() func; Case 3: no If-Match",
+			method:     http.MethodPut,
+			path:       "/book/bid",
+			body:       `{"label":"Dune Messiah","author":"Herbert"}`,
+			statusCode: http.StatusPreconditionRequired,
+		},
+		{
+			name:   "Test UpdateBookHandler() func; Case 4: storage detects a concurrent change",
+			method: http.MethodPut,
+			path:   "/book/bid",
+			header: map[string]string{"If-Match": `"3"`},
+			body:   `{"label":"Dune Messiah","author":"Herbert"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().UpdateBook(gomock.Any(), gomock.Any(), "editor").Return(models.Book{}, storage.ErrVersionMismatch)
+			},
+			statusCode: http.StatusPreconditionFailed,
+			contains:   `"code":"version_mismatch"`,
+		},
+		{
+			name:   "Test PatchBookHandler() func; Case 5: change one field",
+			method: http.MethodPatch,
+			path:   "/book/bid",
+			header: mergePatch,
+			body:   `{"label":"Dune Messiah"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(stored, nil)
+				m.EXPECT().UpdateBook(gomock.Any(), gomock.Any(), "editor").DoAndReturn(func(ctx context.Context, book models.Book, editor string) (models.Book, error) {
+					assert.Equal(t, "Dune Messiah", book.Label)
+					assert.Equal(t, "Herbert", book.Author)
+					assert.Equal(t, int64(3), book.Version)
+					return updated(ctx, book, editor)
+				})
+			},
+			statusCode: http.StatusOK,
+			contains:   `"author":"Herbert"`,
+			etag:       `"4"`,
+		},
+		{
+			name:   "Test PatchBookHandler() func; Case 6: any version uses the one read",
+			method: http.MethodPatch,
+			path:   "/book/bid",
+			header: map[string]string{"Content-Type": "application/json", "If-Match": "*"},
+			body:   `{"author":"Frank Herbert"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").DoAndReturn(func(ctx context.Context, _ string) (models.Book, error) {
+					// основа патча не должна браться из кеша или отстающей реплики
+					assert.True(t, storage.PrimaryRequested(ctx))
+					return stored, nil
+				})
+				m.EXPECT().UpdateBook(gomock.Any(), models.Book{BID: "bid", Label: "Dune", Author: "Frank Herbert", UserUID: "owner", Version: 3}, "editor").DoAndReturn(updated)
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:   "Test PatchBookHandler() func; Case 7: null clears a required field",
+			method: http.MethodPatch,
+			path:   "/book/bid",
+			header: mergePatch,
+			body:   `{"author":null}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(stored, nil)
+			},
+			statusCode: http.StatusBadRequest,
+			contains:   `"field":"author"`,
+		},
+		{
+			name:   "Test PatchBookHandler() func; Case 8: unknown field",
+			method: http.MethodPatch,
+			path:   "/book/bid",
+			header: mergePatch,
+			body:   `{"user_uid":"editor"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(stored, nil)
+			},
+			statusCode: http.StatusBadRequest,
+			contains:   "user_uid",
+		},
+		{
+			name:       "Test PatchBookHandler() func; Case 9: not an object",
+			method:     http.MethodPatch,
+			path:       "/book/bid",
+			header:     mergePatch,
+			body:       `["label"]`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Test PatchBookHandler() func; Case 10: unsupported content type",
+			method:     http.MethodPatch,
+			path:       "/book/bid",
+			header:     map[string]string{"Content-Type": "application/json-patch+json", "If-Match": `"3"`},
+			body:       `[{"op":"replace","path":"/label","value":"Dune"}]`,
+			statusCode: http.StatusUnsupportedMediaType,
+			contains:   `"code":"unsupported_media_type"`,
+		},
+		{
+			name:   "Test PatchBookHandler() func; Case 11: stale If-Match",
+			method: http.MethodPatch,
+			path:   "/book/bid",
+			header: map[string]string{"Content-Type": mergePatchContentType, "If-Match": `"2"`},
+			body:   `{"label":"Dune Messiah"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(stored, nil)
+			},
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:   "Test PatchBookHandler() func; Case 12: deleted book",
+			method: http.MethodPatch,
+			path:   "/book/bid",
+			header: mergePatch,
+			body:   `{"label":"Dune Messiah"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(models.Book{}, storage.ErrBookWasDeleted)
+			},
+			statusCode: http.StatusGone,
+		},
+		{
+			name:   "Test BookHistoryHandler() func; Case 13: list revisions",
+			method: http.MethodGet,
+			path:   "/book/bid/history",
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookHistory(gomock.Any(), "bid").Return([]models.BookRevision{
+					{BID: "bid", Version: 1, Label: "Dune", Author: "Herbert", EditedBy: "editor", EditedAt: time.Now()},
+				}, nil)
+			},
+			statusCode: http.StatusOK,
+			contains:   `"edited_by":"editor"`,
+		},
+		{
+			name:   "Test BookHistoryHandler() func; Case 14: missing book",
+			method: http.MethodGet,
+			path:   "/book/bid/history",
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookHistory(gomock.Any(), "bid").Return(nil, storage.ErrBookNotFound)
+			},
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			mockStorage.EXPECT().GetUserByID(gomock.Any(), "editor").Return(editor, nil)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mockStorage)
+			}
+			srv.storage = mockStorage
+			req := resty.New().R().SetHeader("Authorization", token).SetHeaders(tc.header)
+			if tc.body != "" {
+				req.SetBody(tc.body)
+			}
+			resp, err := req.Execute(tc.method, httpSrv.URL+tc.path)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.contains)
+			if tc.etag != "" {
+				assert.Equal(t, tc.etag, resp.Header().Get("ETag"))
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	catalogFormatCSV     = "csv"
+	catalogFormatNDJSON  = "ndjson"
+	catalogFormatMARCXML = "marcxml"
+
+	// marcNamespace - пространство имён MARC 21 XML (MARCXML).
+	marcNamespace = "http://www.loc.gov/MARC21/slim"
+	// marcLeader - маркер записи: новая (n) печатная (a) монография (m) в UTF-8 (a).
+	// Длина записи и базовый адрес в MARCXML не вычисляются и остаются нулями.
+	marcLeader = "00000nam a2200000 u 4500"
+
+	// maxCatalogExports - сколько выгрузок каталога идёт одновременно на весь процесс. Каждая держит
+	// соединение пула, пока клиент читает ответ, и без ограничения медленные клиенты заняли бы весь пул.
+	maxCatalogExports = 4
+)
+
+var errCatalogFormat = errMess.New(errMess.CodeBadRequest, "format must be csv, ndjson or marcxml")
+
+// catalogEncoder пишет выгрузку каталога в одном из форматов.
+type catalogEncoder interface {
+	// begin пишет начало файла: заголовок CSV или корневой элемент XML
+	begin() error
+	book(models.Book) error
+	// end дописывает конец файла и сбрасывает буферы
+	end() error
+}
+
+// catalogFormats - форматы выгрузки: Content-Type и кодировщик.
+var catalogFormats = map[string]struct {
+	contentType string
+	encoder     func(io.Writer) catalogEncoder
+}{
+	catalogFormatCSV:     {"text/csv; charset=utf-8", func(w io.Writer) catalogEncoder { return &csvCatalog{w: csv.NewWriter(w)} }},
+	catalogFormatNDJSON:  {"application/x-ndjson", func(w io.Writer) catalogEncoder { return &ndjsonCatalog{enc: json.NewEncoder(w)} }},
+	catalogFormatMARCXML: {"application/marcxml+xml", func(w io.Writer) catalogEncoder { return &marcCatalog{w: w, enc: xml.NewEncoder(w)} }},
+}
+
+// ExportCatalogHandler потоково выгружает каталог: те же книги и в том же порядке, что и
+// /book/all_books, но без загрузки всего списка в память. Ответ начинается с первой книги,
+// поэтому ошибка хранилища до неё отдаётся обычной проблемой, а после - только обрывает выгрузку.
+// Сверх maxCatalogExports одновременных выгрузок отвечает 503.
+func (s *Server) ExportCatalogHandler(ctx *gin.Context) {
+	zLog := logger.FromContext(ctx.Request.Context())
+	format := ctx.DefaultQuery("format", catalogFormatCSV)
+	f, ok := catalogFormats[format]
+	if !ok {
+		writeProblem(ctx, errCatalogFormat)
+		return
+	}
+	if s.catalogExports.Add(1) > maxCatalogExports {
+		s.catalogExports.Add(-1)
+		writeProblem(ctx, errExportBusy)
+		return
+	}
+	defer s.catalogExports.Add(-1)
+	enc := f.encoder(ctx.Writer)
+	started := false
+	start := func() error {
+		started = true
+		ctx.Header("Content-Type", f.contentType)
+		ctx.Header("Content-Disposition", `attachment; filename="catalog.`+format+`"`)
+		ctx.Status(http.StatusOK)
+		return enc.begin()
+	}
+	count := 0
+	err := s.storage.ExportBooks(ctx.Request.Context(), func(book models.Book) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		count++
+		return enc.book(book)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = enc.end()
+	}
+	if err != nil {
+		if !started {
+			writeProblem(ctx, err)
+			return
+		}
+		zLog.Warn().Err(err).Str("format", format).Int("books", count).Msg("catalog export interrupted")
+		return
+	}
+	zLog.Debug().Str("format", format).Int("books", count).Msg("catalog exported")
+}
+
+// csvCatalog пишет книги строками CSV. Колонки label и author читает импорт, так что выгрузку
+// можно загрузить обратно через /book/import.
+type csvCatalog struct {
+	w *csv.Writer
+}
+
+func (c *csvCatalog) begin() error {
+	return c.w.Write([]string{"bid", "label", "author", "user_uid", "created_at", "version"})
+}
+
+func (c *csvCatalog) book(book models.Book) error {
+	return c.w.Write([]string{
+		book.BID, book.Label, book.Author, book.UserUID,
+		book.CreatedAt.UTC().Format(time.RFC3339Nano), strconv.FormatInt(book.Version, 10),
+	})
+}
+
+func (c *csvCatalog) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonCatalog пишет по книге в JSON на строку.
+type ndjsonCatalog struct {
+	enc *json.Encoder
+}
+
+func (c *ndjsonCatalog) begin() error { return nil }
+
+func (c *ndjsonCatalog) book(book models.Book) error { return c.enc.Encode(book) }
+
+func (c *ndjsonCatalog) end() error { return nil }
+
+// marcCatalog пишет книги записями MARC 21 в коллекции MARCXML:
+// 001 - id книги, 100$a - автор, 245$a - название.
+type marcCatalog struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+var marcCollection = xml.StartElement{
+	Name: xml.Name{Local: "collection"},
+	Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: marcNamespace}},
+}
+
+type marcRecord struct {
+	XMLName      xml.Name        `xml:"record"`
+	Leader       string          `xml:"leader"`
+	ControlField []marcControl   `xml:"controlfield"`
+	DataField    []marcDataField `xml:"datafield"`
+}
+
+type marcControl struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcDataField struct {
+	Tag      string         `xml:"tag,attr"`
+	Ind1     string         `xml:"ind1,attr"`
+	Ind2     string         `xml:"ind2,attr"`
+	Subfield []marcSubfield `xml:"subfield"`
+}
+
+type marcSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (c *marcCatalog) begin() error {
+	if _, err := io.WriteString(c.w, xml.Header); err != nil {
+		return err
+	}
+	return c.enc.EncodeToken(marcCollection)
+}
+
+func (c *marcCatalog) book(book models.Book) error {
+	return c.enc.Encode(marcRecord{
+		Leader:       marcLeader,
+		ControlField: []marcControl{{Tag: "001", Value: book.BID}},
+		DataField: []marcDataField{
+			// 100 1_ - автор, фамилия первой; 245 10 - основное заглавие при наличии 100
+			{Tag: "100", Ind1: "1", Ind2: " ", Subfield: []marcSubfield{{Code: "a", Value: book.Author}}},
+			{Tag: "245", Ind1: "1", Ind2: "0", Subfield: []marcSubfield{{Code: "a", Value: book.Label}}},
+		},
+	})
+}
+
+func (c *marcCatalog) end() error {
+	if err := c.enc.EncodeToken(marcCollection.End()); err != nil {
+		return err
+	}
+	return c.enc.Flush()
+}
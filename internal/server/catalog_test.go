@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestExportCatalogHandler(t *testing.T) {
+	srv := &Server{
+		validator: newValidator(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/book/export", srv.Authenticate(), RequireScope(models.ScopeBooksRead), srv.ExportCatalogHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "uid")
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	books := []models.Book{
+		{BID: "b1", Label: "Dune", Author: "Herbert, Frank", UserUID: "uid", CreatedAt: created, Version: 2},
+		{BID: "b2", Label: "Tom & Jerry <3", Author: "Hanna", CreatedAt: created.Add(time.Second), Version: 1},
+	}
+	// export отдаёт книги по одной, как хранилище, и возвращает err после них
+	export := func(books []models.Book, err error) func(*mocks.MockStorage) {
+		return func(m *mocks.MockStorage) {
+			m.EXPECT().ExportBooks(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, fn func(models.Book) error) error {
+				for _, book := range books {
+					if err := fn(book); err != nil {
+						return err
+					}
+				}
+				return err
+			})
+		}
+	}
+	testCases := []struct {
+		name        string
+		query       string
+		mockSetup   func(*mocks.MockStorage)
+		statusCode  int
+		contentType string
+		body        string
+		contains    string
+	}{
+		{
+			name:        "Test ExportCatalogHandler() func; Case 1: CSV by default",
+			mockSetup:   export(books, nil),
+			statusCode:  http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body: "bid,label,author,user_uid,created_at,version\n" +
+				"b1,Dune,\"Herbert, Frank\",uid,2025-03-01T12:00:00Z,2\n" +
+				"b2,Tom & Jerry <3,Hanna,,2025-03-01T12:00:01Z,1\n",
+		},
+		{
+			name:        "Test ExportCatalogHandler() func; Case 2: NDJSON",
+			query:       "?format=ndjson",
+			mockSetup:   export(books[:1], nil),
+			statusCode:  http.StatusOK,
+			contentType: "application/x-ndjson",
+			body: `{"bid":"b1","label":"Dune","author":"Herbert, Frank","delete":false,"user_uid":"uid",` +
+				`"created_at":"2025-03-01T12:00:00Z","version":2}` + "\n",
+		},
+		{
+			name:        "Test ExportCatalogHandler() func; Case 3: empty catalog",
+			query:       "?format=csv",
+			mockSetup:   export(nil, nil),
+			statusCode:  http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body:        "bid,label,author,user_uid,created_at,version\n",
+		},
+		{
+			name:        "Test ExportCatalogHandler() func; Case 4: unknown format",
+			query:       "?format=xlsx",
+			statusCode:  http.StatusBadRequest,
+			contentType: problemContentType,
+			contains:    "marcxml",
+		},
+		{
+			name:        "Test ExportCatalogHandler() func; Case 5: storage fails before the first book",
+			mockSetup:   export(nil, errors.New("connection refused")),
+			statusCode:  http.StatusInternalServerError,
+			contentType: problemContentType,
+			contains:    `"code":"internal"`,
+		},
+		{
+			name:        "Test ExportCatalogHandler() func; Case 6: storage fails mid-stream",
+			query:       "?format=ndjson",
+			mockSetup:   export(books[:1], errors.New("connection reset")),
+			statusCode:  http.StatusOK,
+			contentType: "application/x-ndjson",
+			contains:    `"bid":"b1"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			mockStorage.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleMember}, nil)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mockStorage)
+			}
+			srv.storage = mockStorage
+			resp, err := resty.New().R().SetHeader("Authorization", token).Get(httpSrv.URL + "/book/export" + tc.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Equal(t, tc.contentType, resp.Header().Get("Content-Type"))
+			if tc.body != "" {
+				assert.Equal(t, tc.body, string(resp.Body()))
+			}
+			assert.Contains(t, resp.String(), tc.contains)
+		})
+	}
+}
+
+func TestExportCatalogMARCXML(t *testing.T) {
+	srv := &Server{
+		validator: newValidator(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/book/export", srv.ExportCatalogHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorage := mocks.NewMockStorage(ctrl)
+	mockStorage.EXPECT().ExportBooks(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, fn func(models.Book) error) error {
+		for _, book := range []models.Book{{BID: "b1", Label: "Dune", Author: "Herbert"}, {BID: "b2", Label: "Tom & Jerry", Author: "Hanna"}} {
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	srv.storage = mockStorage
+
+	resp, err := resty.New().R().Get(httpSrv.URL + "/book/export?format=marcxml")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "application/marcxml+xml", resp.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="catalog.marcxml"`, resp.Header().Get("Content-Disposition"))
+	var collection struct {
+		XMLName xml.Name     `xml:"http://www.loc.gov/MARC21/slim collection"`
+		Records []marcRecord `xml:"record"`
+	}
+	assert.NoError(t, xml.Unmarshal(resp.Body(), &collection))
+	if assert.Len(t, collection.Records, 2) {
+		record := collection.Records[1]
+		assert.Equal(t, marcLeader, record.Leader)
+		assert.Equal(t, []marcControl{{Tag: "001", Value: "b2"}}, record.ControlField)
+		assert.Equal(t, "Hanna", record.DataField[0].Subfield[0].Value)
+		assert.Equal(t, "245", record.DataField[1].Tag)
+		assert.Equal(t, "Tom & Jerry", record.DataField[1].Subfield[0].Value)
+	}
+}
+
+// TestExportCatalogBusy - сверх maxCatalogExports выгрузок хранилище не трогается, а счётчик освобождается.
+func TestExportCatalogBusy(t *testing.T) {
+	srv := &Server{
+		validator: newValidator(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/book/export", srv.ExportCatalogHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	srv.storage = mocks.NewMockStorage(ctrl)
+	srv.catalogExports.Store(maxCatalogExports)
+
+	resp, err := resty.New().R().Get(httpSrv.URL + "/book/export")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode())
+	assert.Contains(t, resp.String(), `"code":"unavailable"`)
+	assert.Equal(t, int32(maxCatalogExports), srv.catalogExports.Load())
+}
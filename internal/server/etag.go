@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// errIfMatchRequired - изменяющий запрос к записи с версией пришёл без If-Match.
+var errIfMatchRequired = errMess.New(errMess.CodeIfMatchRequired, "If-Match header is required, send the ETag of the record you changed")
+
+// versionETag возвращает сильный ETag записи: её версию в кавычках.
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// bodyETag возвращает ETag ответа без версии, например списка: хеш его тела.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ifMatch разбирает If-Match в ожидаемую версию записи для хранилища. "*" - любая версия (0).
+// Без заголовка - errIfMatchRequired. Слабый или чужой тег не может совпасть при строгом
+// сравнении, поэтому сразу даёт ErrVersionMismatch.
+func ifMatch(ctx *gin.Context) (int64, error) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if header == "" {
+		return 0, errIfMatchRequired
+	}
+	if header == "*" {
+		return 0, nil
+	}
+	if strings.Contains(header, ",") {
+		return 0, errMess.New(errMess.CodeBadRequest, "If-Match must contain a single entity tag")
+	}
+	tag, ok := strings.CutPrefix(header, `"`)
+	if !ok {
+		return 0, storage.ErrVersionMismatch
+	}
+	tag, ok = strings.CutSuffix(tag, `"`)
+	if !ok {
+		return 0, storage.ErrVersionMismatch
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, storage.ErrVersionMismatch
+	}
+	return version, nil
+}
+
+// noneMatch сообщает, совпадает ли tag с одним из тегов If-None-Match. Сравнение слабое:
+// W/"1" и "1" равны.
+func noneMatch(ctx *gin.Context, tag string) bool {
+	header := strings.TrimSpace(ctx.GetHeader("If-None-Match"))
+	if header == "" {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+	for candidate := range strings.SplitSeq(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTagged отвечает 200 с телом body и ETag tag, или 304 без тела, если клиент уже его видел.
+func writeTagged(ctx *gin.Context, tag string, body any) {
+	ctx.Header("ETag", tag)
+	if noneMatch(ctx, tag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.JSON(http.StatusOK, body)
+}
+
+// writeHashed отвечает списком так же, как writeTagged, вычисляя ETag по телу ответа.
+func writeHashed(ctx *gin.Context, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	tag := bodyETag(data)
+	ctx.Header("ETag", tag)
+	if noneMatch(ctx, tag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatch(t *testing.T) {
+	type want struct {
+		version int64
+		err     error
+	}
+	tests := []struct {
+		name   string
+		header string
+		want   want
+	}{
+		{name: "Test ifMatch() func; Case 1: no header", want: want{err: errIfMatchRequired}},
+		{name: "Test ifMatch() func; Case 2: any version", header: "*", want: want{version: 0}},
+		{name: "Test ifMatch() func; Case 3: strong tag", header: `"12"`, want: want{version: 12}},
+		{name: "Test ifMatch() func; Case 4: weak tag", header: `W/"12"`, want: want{err: storage.ErrVersionMismatch}},
+		{name: "Test ifMatch() func; Case 5: foreign tag", header: `"abc"`, want: want{err: storage.ErrVersionMismatch}},
+		{name: "Test ifMatch() func; Case 6: zero version", header: `"0"`, want: want{err: storage.ErrVersionMismatch}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodDelete, "/book/delete/bid", nil)
+			if tc.header != "" {
+				ctx.Request.Header.Set("If-Match", tc.header)
+			}
+			version, err := ifMatch(ctx)
+			if tc.want.err != nil {
+				assert.ErrorIs(t, err, tc.want.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.version, version)
+		})
+	}
+}
+
+func TestNoneMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "Test noneMatch() func; Case 1: no header", want: false},
+		{name: "Test noneMatch() func; Case 2: same tag", header: `"3"`, want: true},
+		{name: "Test noneMatch() func; Case 3: weak comparison", header: `W/"3"`, want: true},
+		{name: "Test noneMatch() func; Case 4: list", header: `"1", "3"`, want: true},
+		{name: "Test noneMatch() func; Case 5: other tag", header: `"2"`, want: false},
+		{name: "Test noneMatch() func; Case 6: any", header: "*", want: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/book/bid", nil)
+			if tc.header != "" {
+				ctx.Request.Header.Set("If-None-Match", tc.header)
+			}
+			assert.Equal(t, tc.want, noneMatch(ctx, versionETag(3)))
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	exportFormatJSON = "json"
+	exportFormatZIP  = "zip"
+	exportJobTTL     = 24 * time.Hour
+	// maxPendingExports - сколько выгрузок собирается одновременно на весь процесс
+	maxPendingExports = 4
+	// exportFailedMessage - текст ошибки задания для клиента, причина пишется только в лог
+	exportFailedMessage = "export failed, try again later"
+
+	exportStatusPending = "pending"
+	exportStatusReady   = "ready"
+	exportStatusFailed  = "failed"
+)
+
+// exportJob - асинхронная выгрузка всех данных пользователя.
+type exportJob struct {
+	ID         string     `json:"id"`
+	Format     string     `json:"format"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	userID     string
+	data       []byte
+}
+
+// exportStore хранит задания выгрузки и готовые архивы в памяти процесса. У пользователя
+// хранится только последнее задание, а собираются одновременно не больше maxPendingExports,
+// так что память и число горутин ограничены.
+type exportStore struct {
+	mu   sync.Mutex
+	jobs map[string]*exportJob
+}
+
+func newExportStore() *exportStore {
+	return &exportStore{jobs: make(map[string]*exportJob)}
+}
+
+// create заводит задание. Пока предыдущее задание пользователя собирается - errExportPending,
+// при maxPendingExports собираемых заданий - errExportBusy. Прежние готовые задания пользователя
+// удаляются вместе с архивами.
+func (es *exportStore) create(userID, format string) (exportJob, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	now := time.Now()
+	pending := 0
+	for id, job := range es.jobs {
+		if now.Sub(job.CreatedAt) > exportJobTTL {
+			delete(es.jobs, id)
+			continue
+		}
+		if job.Status == exportStatusPending {
+			if job.userID == userID {
+				return exportJob{}, errExportPending
+			}
+			pending++
+		}
+	}
+	if pending >= maxPendingExports {
+		return exportJob{}, errExportBusy
+	}
+	for id, job := range es.jobs {
+		if job.userID == userID {
+			delete(es.jobs, id)
+		}
+	}
+	job := &exportJob{
+		ID:        uuid.NewString(),
+		Format:    format,
+		Status:    exportStatusPending,
+		CreatedAt: now,
+		userID:    userID,
+	}
+	es.jobs[job.ID] = job
+	return *job, nil
+}
+
+func (es *exportStore) get(id, userID string) (exportJob, bool) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	job, ok := es.jobs[id]
+	if !ok || job.userID != userID {
+		return exportJob{}, false
+	}
+	return *job, true
+}
+
+func (es *exportStore) finish(id string, data []byte, err error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	job, ok := es.jobs[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = exportStatusFailed
+		job.Error = exportFailedMessage
+		return
+	}
+	job.Status = exportStatusReady
+	job.data = data
+}
+
+// userExport - всё, что сервис хранит о пользователе.
+type userExport struct {
+	ExportedAt time.Time       `json:"exported_at"`
+	Profile    userResponse    `json:"profile"`
+	Books      []models.Book   `json:"books"`
+	APIKeys    []models.APIKey `json:"api_keys"`
+}
+
+type eraseRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+}
+
+// CreateExportHandler ставит в очередь выгрузку данных текущего пользователя в формате json или zip.
+func (s *Server) CreateExportHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	format := ctx.DefaultQuery("format", exportFormatJSON)
+	if format != exportFormatJSON && format != exportFormatZIP {
+		writeProblem(ctx, errMess.New(errMess.CodeBadRequest, "format must be json or zip"))
+		return
+	}
+	job, err := s.exports.create(principal.UserID, format)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	go s.runExport(context.WithoutCancel(ctx.Request.Context()), job)
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// ExportStatusHandler возвращает состояние задания выгрузки.
+func (s *Server) ExportStatusHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	job, ok := s.exports.get(ctx.Param("id"), principal.UserID)
+	if !ok {
+		writeProblem(ctx, errExportNotFound)
+		return
+	}
+	ctx.JSON(http.StatusOK, job)
+}
+
+// ExportDownloadHandler отдаёт готовый архив выгрузки.
+func (s *Server) ExportDownloadHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	job, ok := s.exports.get(ctx.Param("id"), principal.UserID)
+	if !ok {
+		writeProblem(ctx, errExportNotFound)
+		return
+	}
+	if job.Status != exportStatusReady {
+		writeProblem(ctx, errMess.New(errMess.CodeExportNotReady, "export is "+job.Status))
+		return
+	}
+	contentType := "application/json"
+	if job.Format == exportFormatZIP {
+		contentType = "application/zip"
+	}
+	ctx.Header("Content-Disposition", "attachment; filename=export-"+job.ID+"."+job.Format)
+	ctx.Data(http.StatusOK, contentType, job.data)
+}
+
+// EraseAccountHandler обезличивает аккаунт текущего пользователя. Добавленные им книги остаются в каталоге.
+func (s *Server) EraseAccountHandler(ctx *gin.Context) {
+	zLog := logger.FromContext(ctx.Request.Context())
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	var req eraseRequest
+	if err := ctx.ShouldBindBodyWithJSON(&req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.validator.Struct(req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	user, err := s.storage.GetUserByID(ctx.Request.Context(), principal.UserID)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	user.Pass = req.CurrentPassword
+	if _, err = s.storage.ValidateUser(ctx.Request.Context(), user); err != nil {
+		if errors.Is(err, storage.ErrInvalidAuthData) {
+			writeProblem(ctx, errWrongPassword)
+			return
+		}
+		writeProblem(ctx, err)
+		return
+	}
+	if err = s.storage.EraseUser(ctx.Request.Context(), principal.UserID); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	zLog.Info().Str("uid", principal.UserID).Msg("user data erased")
+	ctx.JSON(http.StatusOK, gin.H{"message": "Account successfully erased"})
+}
+
+func (s *Server) runExport(ctx context.Context, job exportJob) {
+	zLog := logger.FromContext(ctx)
+	data, err := s.buildExport(ctx, job.userID, job.Format)
+	if err != nil {
+		zLog.Error().Err(err).Str("export", job.ID).Msg("export failed")
+	}
+	s.exports.finish(job.ID, data, err)
+}
+
+func (s *Server) buildExport(ctx context.Context, uid, format string) ([]byte, error) {
+	// выгрузка должна содержать всё, что пользователь успел записать, поэтому читаем из primary
+	ctx = storage.WithPrimary(ctx)
+	user, err := s.storage.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	books, err := s.storage.GetBookByUID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.storage.GetAPIKeysByOwner(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	export := userExport{
+		ExportedAt: time.Now(),
+		Profile:    newUserResponse(user),
+		Books:      books,
+		APIKeys:    keys,
+	}
+	if export.Books == nil {
+		export.Books = []models.Book{}
+	}
+	if format == exportFormatJSON {
+		return json.MarshalIndent(export, "", "  ")
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"books.json", export.Books},
+		{"api_keys.json", export.APIKeys},
+	}
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
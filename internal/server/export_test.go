@@ -0,0 +1,210 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestExportHandlers(t *testing.T) {
+	srv := &Server{
+		validator: validator.New(),
+		exports:   newExportStore(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	me := r.Group("/me", srv.Authenticate(), RequireUserToken())
+	me.POST("/export", srv.CreateExportHandler)
+	me.GET("/export/:id", srv.ExportStatusHandler)
+	me.GET("/export/:id/download", srv.ExportDownloadHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "uid")
+	user := models.User{UID: "uid", Name: "Sergei", Email: "testemail@ya.ru", Pass: "hash"}
+	books := []models.Book{{BID: "bid", Label: "Label", Author: "Author", UserUID: "uid"}}
+
+	for _, format := range []string{exportFormatJSON, exportFormatZIP} {
+		t.Run("Test export func; format "+format, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockStorage(ctrl)
+			m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(user, nil).AnyTimes()
+			m.EXPECT().GetBookByUID(gomock.Any(), "uid").Return(books, nil)
+			m.EXPECT().GetAPIKeysByOwner(gomock.Any(), "uid").Return([]models.APIKey{}, nil)
+			srv.storage = m
+
+			resp, err := resty.New().R().SetHeader("Authorization", token).Post(httpSrv.URL + "/me/export?format=" + format)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+			var job exportJob
+			assert.NoError(t, json.Unmarshal(resp.Body(), &job))
+
+			assert.Eventually(t, func() bool {
+				resp, err := resty.New().R().SetHeader("Authorization", token).Get(httpSrv.URL + "/me/export/" + job.ID)
+				return err == nil && bytes.Contains(resp.Body(), []byte(exportStatusReady))
+			}, 2*time.Second, 10*time.Millisecond)
+
+			resp, err = resty.New().R().SetHeader("Authorization", token).Get(httpSrv.URL + "/me/export/" + job.ID + "/download")
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode())
+			body := resp.Body()
+			if format == exportFormatZIP {
+				zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+				assert.NoError(t, err)
+				assert.Len(t, zr.File, 3)
+				f, err := zr.Open("books.json")
+				assert.NoError(t, err)
+				var buf bytes.Buffer
+				_, err = buf.ReadFrom(f)
+				assert.NoError(t, err)
+				body = buf.Bytes()
+			}
+			assert.Contains(t, string(body), `"label": "Label"`)
+			assert.NotContains(t, string(body), `"pass"`)
+		})
+	}
+
+	t.Run("Test export func; foreign job", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := mocks.NewMockStorage(ctrl)
+		m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(user, nil)
+		srv.storage = m
+		job, err := srv.exports.create("other", exportFormatJSON)
+		assert.NoError(t, err)
+		resp, err := resty.New().R().SetHeader("Authorization", token).Get(httpSrv.URL + "/me/export/" + job.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	})
+
+	t.Run("Test export func; previous export pending", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := mocks.NewMockStorage(ctrl)
+		m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(user, nil)
+		srv.storage = m
+		_, err := srv.exports.create("uid", exportFormatJSON)
+		assert.NoError(t, err)
+		resp, err := resty.New().R().SetHeader("Authorization", token).Post(httpSrv.URL + "/me/export")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode())
+	})
+}
+
+func TestExportStore(t *testing.T) {
+	t.Run("Test exportStore func; Case 1: one pending job per user", func(t *testing.T) {
+		es := newExportStore()
+		first, err := es.create("uid", exportFormatJSON)
+		assert.NoError(t, err)
+		_, err = es.create("uid", exportFormatZIP)
+		assert.ErrorIs(t, err, errExportPending)
+		es.finish(first.ID, []byte("{}"), nil)
+		second, err := es.create("uid", exportFormatZIP)
+		assert.NoError(t, err)
+		// новое задание вытесняет готовый архив
+		_, ok := es.get(first.ID, "uid")
+		assert.False(t, ok)
+		_, ok = es.get(second.ID, "uid")
+		assert.True(t, ok)
+	})
+
+	t.Run("Test exportStore func; Case 2: global limit", func(t *testing.T) {
+		es := newExportStore()
+		var last exportJob
+		for i := range maxPendingExports {
+			job, err := es.create(fmt.Sprintf("uid%d", i), exportFormatJSON)
+			assert.NoError(t, err)
+			last = job
+		}
+		_, err := es.create("late", exportFormatJSON)
+		assert.ErrorIs(t, err, errExportBusy)
+		es.finish(last.ID, nil, errors.New("dial tcp 10.0.0.1:5432: connection refused"))
+		_, err = es.create("late", exportFormatJSON)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Test exportStore func; Case 3: failure text is not exposed", func(t *testing.T) {
+		es := newExportStore()
+		job, err := es.create("uid", exportFormatJSON)
+		assert.NoError(t, err)
+		es.finish(job.ID, nil, errors.New(`pq: relation "users" does not exist`))
+		job, ok := es.get(job.ID, "uid")
+		assert.True(t, ok)
+		assert.Equal(t, exportStatusFailed, job.Status)
+		assert.Equal(t, exportFailedMessage, job.Error)
+	})
+}
+
+func TestEraseAccountHandler(t *testing.T) {
+	srv := &Server{
+		validator: validator.New(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/me/erase", srv.Authenticate(), RequireUserToken(), srv.EraseAccountHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "uid")
+	user := models.User{UID: "uid", Name: "Sergei", Email: "testemail@ya.ru"}
+	testCases := []struct {
+		name       string
+		body       string
+		mockSetup  func(*mocks.MockStorage)
+		statusCode int
+	}{
+		{
+			name: "Test EraseAccountHandler() func; Case 1: OK",
+			body: `{"current_password":"qwerty1234"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(user, nil).Times(2)
+				m.EXPECT().ValidateUser(gomock.Any(), gomock.Any()).Return("uid", nil)
+				m.EXPECT().EraseUser(gomock.Any(), "uid").Return(nil)
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name: "Test EraseAccountHandler() func; Case 2: wrong password",
+			body: `{"current_password":"wrong"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(user, nil).Times(2)
+				m.EXPECT().ValidateUser(gomock.Any(), gomock.Any()).Return("", storage.ErrInvalidAuthData)
+			},
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name: "Test EraseAccountHandler() func; Case 3: no password",
+			body: `{}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(user, nil)
+			},
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockStorage(ctrl)
+			tc.mockSetup(m)
+			srv.storage = m
+			resp, err := resty.New().R().SetHeader("Authorization", token).SetBody(tc.body).Post(httpSrv.URL + "/me/erase")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	importContentTypeCSV    = "text/csv"
+	importContentTypeNDJSON = "application/x-ndjson"
+	// maxImportRows ограничивает размер одного импорта: вся пачка держится в памяти и пишется одной транзакцией
+	maxImportRows = 10000
+	// defaultImportBytes - предел тела импорта, если он не задан в New
+	defaultImportBytes = 10 << 20
+)
+
+var (
+	errUnsupportedImport = errMess.New(errMess.CodeUnsupportedMedia, "import body must be "+importContentTypeCSV+" or "+importContentTypeNDJSON)
+	errImportTooLarge    = errMess.New(errMess.CodeBadRequest, fmt.Sprintf("import is limited to %d rows, split the file", maxImportRows))
+	errImportBodyLarge   = errMess.New(errMess.CodePayloadTooLarge, "import body is too large, split the file")
+)
+
+// importRow - строка файла импорта. Line - номер строки в файле, с 1, заголовок CSV тоже считается.
+type importRow struct {
+	Line   int    `json:"-"`
+	Label  string `json:"label"`
+	Author string `json:"author"`
+	// Err - строку не удалось разобрать
+	Err error `json:"-"`
+}
+
+// rowError - ошибка одной строки импорта в отчёте.
+type rowError struct {
+	Line    int          `json:"line"`
+	Message string       `json:"message"`
+	Errors  []fieldError `json:"errors,omitempty"`
+}
+
+// importReport - итог импорта. Ошибочные строки не сохраняются: их можно исправить и прислать отдельно.
+type importReport struct {
+	DryRun   bool       `json:"dry_run"`
+	Total    int        `json:"total"`
+	Imported int        `json:"imported"`
+	Failed   int        `json:"failed"`
+	Errors   []rowError `json:"errors"`
+}
+
+// ImportBooksHandler загружает книги текущего пользователя из CSV (с заголовком label,author)
+// или NDJSON. Корректные строки сохраняются одной транзакцией, по остальным возвращается отчёт.
+// С ?dry_run=true строки только проверяются. Тело больше importMaxBytes отклоняется с 413.
+func (s *Server) ImportBooksHandler(ctx *gin.Context) {
+	zLog := logger.FromContext(ctx.Request.Context())
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errInvalidToken)
+		return
+	}
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		writeProblem(ctx, errMess.New(errMess.CodeBadRequest, "dry_run must be true or false"))
+		return
+	}
+	limit := s.importMaxBytes
+	if limit <= 0 {
+		limit = defaultImportBytes
+	}
+	body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+	var rows []importRow
+	switch ctx.ContentType() {
+	case importContentTypeCSV:
+		rows, err = readCSVRows(body)
+	case importContentTypeNDJSON:
+		rows, err = readNDJSONRows(body)
+	default:
+		err = errUnsupportedImport
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		err = errImportBodyLarge
+	}
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+
+	report := importReport{DryRun: dryRun, Total: len(rows), Errors: make([]rowError, 0)}
+	books := make([]models.Book, 0, len(rows))
+	for _, row := range rows {
+		err := row.Err
+		book := models.Book{Label: strings.TrimSpace(row.Label), Author: strings.TrimSpace(row.Author), UserUID: principal.UserID}
+		if err == nil {
+			err = s.validator.Struct(book)
+		}
+		if err != nil {
+			p := newProblem(err)
+			report.Errors = append(report.Errors, rowError{Line: row.Line, Message: p.Detail, Errors: p.Errors})
+			continue
+		}
+		books = append(books, book)
+	}
+	report.Failed = len(report.Errors)
+	if dryRun || len(books) == 0 {
+		ctx.JSON(http.StatusOK, report)
+		return
+	}
+	if err := s.storage.SaveBooks(ctx.Request.Context(), books); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	report.Imported = len(books)
+	zLog.Info().Int("imported", report.Imported).Int("failed", report.Failed).Msg("books imported")
+	ctx.JSON(http.StatusCreated, report)
+}
+
+// readCSVRows читает CSV с заголовком. Порядок колонок любой, лишние колонки пропускаются.
+func readCSVRows(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errMess.New(errMess.CodeBadRequest, "CSV header is required")
+		}
+		return nil, errMess.Wrap(errMess.CodeBadRequest, "malformed CSV: "+err.Error(), err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	labelCol, hasLabel := columns["label"]
+	authorCol, hasAuthor := columns["author"]
+	if !hasLabel || !hasAuthor {
+		return nil, errMess.New(errMess.CodeBadRequest, "CSV header must contain label and author columns")
+	}
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, errMess.Wrap(errMess.CodeBadRequest, "malformed CSV: "+err.Error(), err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, errImportTooLarge
+		}
+		line, _ := reader.FieldPos(0)
+		row := importRow{Line: line}
+		if len(record) != len(header) {
+			row.Err = errMess.New(errMess.CodeBadRequest, fmt.Sprintf("expected %d fields, got %d", len(header), len(record)))
+		} else {
+			row.Label, row.Author = record[labelCol], record[authorCol]
+		}
+		rows = append(rows, row)
+	}
+}
+
+// readNDJSONRows читает по объекту книги на строку. Пустые строки пропускаются.
+func readNDJSONRows(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, errImportTooLarge
+		}
+		row := importRow{Line: line}
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			row.Err = err
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errMess.Wrap(errMess.CodeBadRequest, "malformed NDJSON: "+err.Error(), err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestImportBooksHandler(t *testing.T) {
+	srv := &Server{
+		validator: newValidator(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/book/import", srv.Authenticate(), RequireScope(models.ScopeBooksWrite), srv.ImportBooksHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "uid")
+	// saved проверяет, что в хранилище ушли только корректные строки в порядке файла
+	saved := func(labels ...string) func(*mocks.MockStorage) {
+		return func(m *mocks.MockStorage) {
+			m.EXPECT().SaveBooks(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, books []models.Book) error {
+				got := make([]string, 0, len(books))
+				for _, book := range books {
+					got = append(got, book.Label)
+					assert.Equal(t, "uid", book.UserUID)
+				}
+				assert.Equal(t, labels, got)
+				return nil
+			})
+		}
+	}
+	testCases := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		mockSetup   func(*mocks.MockStorage)
+		statusCode  int
+		report      importReport
+		contains    string
+	}{
+		{
+			name:        "Test ImportBooksHandler() func; Case 1: CSV with a bad row",
+			contentType: "text/csv; charset=utf-8",
+			body:        "\ufeffAuthor,Label,Year\nHerbert,Dune,1965\n\"Asimov\",,1951\n  Lem , Solaris ,1961\n",
+			mockSetup:   saved("Dune", "Solaris"),
+			statusCode:  http.StatusCreated,
+			report: importReport{Total: 3, Imported: 2, Failed: 1, Errors: []rowError{{
+				Line:    3,
+				Message: "request validation failed",
+				Errors:  []fieldError{{Field: "label", Rule: "required", Message: "Key: 'Book.label' Error:Field validation for 'label' failed on the 'required' tag"}},
+			}}},
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 2: dry run saves nothing",
+			query:       "?dry_run=true",
+			contentType: "text/csv",
+			body:        "label,author\nDune,Herbert\nSolaris\n",
+			statusCode:  http.StatusOK,
+			report: importReport{DryRun: true, Total: 2, Failed: 1, Errors: []rowError{{
+				Line:    3,
+				Message: "expected 2 fields, got 1",
+			}}},
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 3: NDJSON keeps line numbers",
+			contentType: "application/x-ndjson",
+			body:        "{\"label\":\"Dune\",\"author\":\"Herbert\"}\n\n{\"label\":\"Solaris\",\n{\"label\":\"Foundation\",\"author\":\"Asimov\",\"line\":1}\n",
+			mockSetup:   saved("Dune", "Foundation"),
+			statusCode:  http.StatusCreated,
+			report: importReport{Total: 3, Imported: 2, Failed: 1, Errors: []rowError{{
+				Line:    3,
+				Message: "malformed JSON body: unexpected end of JSON input",
+			}}},
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 4: nothing valid",
+			contentType: "application/x-ndjson",
+			body:        "{\"label\":\"Dune\"}\n",
+			statusCode:  http.StatusOK,
+			contains:    `"failed":1`,
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 5: CSV without author column",
+			contentType: "text/csv",
+			body:        "label\nDune\n",
+			statusCode:  http.StatusBadRequest,
+			contains:    "label and author",
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 6: unsupported content type",
+			contentType: "application/json",
+			body:        `[{"label":"Dune","author":"Herbert"}]`,
+			statusCode:  http.StatusUnsupportedMediaType,
+			contains:    `"code":"unsupported_media_type"`,
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 7: bad dry_run",
+			query:       "?dry_run=maybe",
+			contentType: "text/csv",
+			body:        "label,author\nDune,Herbert\n",
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 8: storage error",
+			contentType: "text/csv",
+			body:        "label,author\nDune,Herbert\n",
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().SaveBooks(gomock.Any(), gomock.Any()).Return(errors.New("copy failed"))
+			},
+			statusCode: http.StatusInternalServerError,
+			contains:   `"code":"internal"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			mockStorage.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleMember}, nil)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mockStorage)
+			}
+			srv.storage = mockStorage
+			resp, err := resty.New().R().
+				SetHeader("Authorization", token).
+				SetHeader("Content-Type", tc.contentType).
+				SetBody(tc.body).
+				Post(httpSrv.URL + "/book/import" + tc.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.contains)
+			if tc.report.Total != 0 {
+				var report importReport
+				assert.NoError(t, json.Unmarshal(resp.Body(), &report))
+				assert.Equal(t, tc.report, report)
+			}
+		})
+	}
+}
+
+func TestImportBodyLimit(t *testing.T) {
+	srv := &Server{
+		validator:      newValidator(),
+		importMaxBytes: 64,
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/book/import", srv.Authenticate(), RequireScope(models.ScopeBooksWrite), srv.ImportBooksHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "uid")
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		statusCode  int
+		contains    string
+	}{
+		{
+			name:        "Test ImportBooksHandler() func; Case 1: CSV over the limit",
+			contentType: "text/csv",
+			body:        "label,author\n" + strings.Repeat("Dune,Herbert\n", 10),
+			statusCode:  http.StatusRequestEntityTooLarge,
+			contains:    `"code":"payload_too_large"`,
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 2: NDJSON over the limit",
+			contentType: "application/x-ndjson",
+			body:        strings.Repeat(`{"label":"Dune","author":"Herbert"}`+"\n", 3),
+			statusCode:  http.StatusRequestEntityTooLarge,
+			contains:    `"code":"payload_too_large"`,
+		},
+		{
+			name:        "Test ImportBooksHandler() func; Case 3: within the limit",
+			contentType: "text/csv",
+			body:        "label,author\nDune,Herbert\n",
+			statusCode:  http.StatusOK,
+			contains:    `"dry_run":true`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			mockStorage.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleMember}, nil)
+			srv.storage = mockStorage
+			resp, err := resty.New().R().
+				SetHeader("Authorization", token).
+				SetHeader("Content-Type", tc.contentType).
+				SetBody(tc.body).
+				Post(httpSrv.URL + "/book/import?dry_run=true")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.contains)
+		})
+	}
+}
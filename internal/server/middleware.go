@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	principalKey = "principal"
+)
+
+// Authenticate определяет клиента запроса по заголовку X-API-Key или по JWT
+// из заголовка Authorization и кладёт models.Principal в контекст gin.
+func (s *Server) Authenticate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var (
+			principal models.Principal
+			err       error
+		)
+		if key := ctx.GetHeader(apiKeyHeader); key != "" {
+			principal, err = s.principalFromAPIKey(ctx.Request.Context(), key)
+		} else {
+			principal, err = s.principalFromJWT(ctx.Request.Context(), ctx.GetHeader("Authorization"))
+		}
+		if err != nil {
+			writeProblem(ctx, err)
+			return
+		}
+		ctx.Set(principalKey, principal)
+		ctx.Next()
+	}
+}
+
+// RequireScope пропускает запрос дальше, только если у клиента есть указанная область доступа.
+// Должен стоять после Authenticate.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, ok := principalFrom(ctx)
+		if !ok {
+			writeProblem(ctx, errUnauthenticated)
+			return
+		}
+		if !principal.HasScope(scope) {
+			writeProblem(ctx, errMess.New(errMess.CodeForbidden, "missing scope "+scope))
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (s *Server) principalFromAPIKey(ctx context.Context, raw string) (models.Principal, error) {
+	key, err := s.storage.GetAPIKeyByHash(ctx, hashToken(raw))
+	if err != nil {
+		if errors.Is(err, storage.ErrAPIKeyNotFound) {
+			return models.Principal{}, errUnauthenticated
+		}
+		return models.Principal{}, err
+	}
+	if key.Revoked || key.Expired(time.Now()) {
+		return models.Principal{}, errUnauthenticated
+	}
+	user, err := s.storage.GetUserByID(ctx, key.OwnerUID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Principal{}, errUnauthenticated
+		}
+		return models.Principal{}, err
+	}
+	return models.Principal{
+		UserID:   key.OwnerUID,
+		Role:     user.Role,
+		Scopes:   key.Scopes,
+		APIKeyID: key.ID,
+	}, nil
+}
+
+func (s *Server) principalFromJWT(ctx context.Context, header string) (models.Principal, error) {
+	uid, err := validJWT(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return models.Principal{}, errUnauthenticated
+	}
+	user, err := s.storage.GetUserByID(ctx, uid)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Principal{}, errUnauthenticated
+		}
+		return models.Principal{}, err
+	}
+	return models.Principal{
+		UserID: uid,
+		Role:   user.Role,
+		Scopes: models.ScopesForRole(user.Role),
+	}, nil
+}
+
+func principalFrom(ctx *gin.Context) (models.Principal, bool) {
+	value, ok := ctx.Get(principalKey)
+	if !ok {
+		return models.Principal{}, false
+	}
+	principal, ok := value.(models.Principal)
+	return principal, ok
+}
+
+func hashToken(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequireUserToken пропускает только клиентов, вошедших по JWT: действия с самим аккаунтом
+// по API-ключу недоступны, какие бы области у ключа ни были. Должен стоять после Authenticate.
+func RequireUserToken() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, ok := principalFrom(ctx)
+		if !ok {
+			writeProblem(ctx, errUnauthenticated)
+			return
+		}
+		if principal.APIKeyID != "" {
+			writeProblem(ctx, errUserTokenOnly)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireRole пропускает запрос дальше, только если роль клиента входит в список roles.
+// Должен стоять после Authenticate.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, ok := principalFrom(ctx)
+		if !ok {
+			writeProblem(ctx, errUnauthenticated)
+			return
+		}
+		if !slices.Contains(roles, principal.Role) {
+			writeProblem(ctx, errMess.New(errMess.CodeForbidden, "insufficient role"))
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const problemContentType = "application/problem+json"
+
+// Ошибки, которые отдают сами обработчики, а не хранилище.
+var (
+	errUnauthenticated  = errMess.New(errMess.CodeUnauthenticated, "invalid credentials")
+	errInvalidToken     = errMess.New(errMess.CodeUnauthenticated, "Invalid token")
+	errUserTokenOnly    = errMess.New(errMess.CodeForbidden, "this action requires a user token, api keys are not accepted")
+	errWrongPassword    = errMess.New(errMess.CodeWrongPassword, "current password is incorrect")
+	errInvalidTOTP      = errMess.New(errMess.CodeTOTPInvalid, "invalid two-factor code")
+	errChallengeExpired = errMess.New(errMess.CodeChallengeExpired, "challenge expired or not found")
+	errExportNotFound   = errMess.New(errMess.CodeExportNotFound, "export not found")
+	errExportPending    = errMess.New(errMess.CodeConflict, "previous export is still in progress")
+	errExportBusy       = errMess.New(errMess.CodeUnavailable, "too many exports in progress, try again later")
+	errNoMailer         = errMess.New(errMess.CodeNotImplemented, "email changes are disabled: mail delivery is not configured")
+)
+
+// problem - тело ответа с ошибкой по RFC 7807. Code - стабильный машинный код из domain/errors.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      errMess.Code `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError описывает не прошедшее валидацию поле запроса.
+type fieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// codeStatus - HTTP-статус для каждого кода доменной ошибки. Коды без записи отдаются как 500.
+var codeStatus = map[errMess.Code]int{
+	errMess.CodeBadRequest:        http.StatusBadRequest,
+	errMess.CodeValidation:        http.StatusBadRequest,
+	errMess.CodeUnauthenticated:   http.StatusUnauthorized,
+	errMess.CodeForbidden:         http.StatusForbidden,
+	errMess.CodeNotFound:          http.StatusNotFound,
+	errMess.CodeConflict:          http.StatusConflict,
+	errMess.CodeUnavailable:       http.StatusServiceUnavailable,
+	errMess.CodeInvalidAuthData:   http.StatusUnauthorized,
+	errMess.CodeUserNotFound:      http.StatusNotFound,
+	errMess.CodeBookNotFound:      http.StatusNotFound,
+	errMess.CodeBookDeleted:       http.StatusGone,
+	errMess.CodeRecoveryCode:      http.StatusUnauthorized,
+	errMess.CodeTOTPNotEnrolled:   http.StatusConflict,
+	errMess.CodeTOTPInvalid:       http.StatusUnauthorized,
+	errMess.CodeAPIKeyNotFound:    http.StatusNotFound,
+	errMess.CodeEmailTaken:        http.StatusConflict,
+	errMess.CodeEmailTokenInvalid: http.StatusBadRequest,
+	errMess.CodeUserExists:        http.StatusConflict,
+	errMess.CodeWrongPassword:     http.StatusForbidden,
+	errMess.CodeChallengeExpired:  http.StatusUnauthorized,
+	errMess.CodeExportNotFound:    http.StatusNotFound,
+	errMess.CodeExportNotReady:    http.StatusConflict,
+	errMess.CodeVersionMismatch:   http.StatusPreconditionFailed,
+	errMess.CodeIfMatchRequired:   http.StatusPreconditionRequired,
+	errMess.CodeUnsupportedMedia:  http.StatusUnsupportedMediaType,
+	errMess.CodePayloadTooLarge:   http.StatusRequestEntityTooLarge,
+	errMess.CodeNotImplemented:    http.StatusNotImplemented,
+}
+
+// grpcCodes переводит ответы auth и books сервисов в доменные коды.
+var grpcCodes = map[codes.Code]errMess.Code{
+	codes.InvalidArgument:  errMess.CodeBadRequest,
+	codes.NotFound:         errMess.CodeNotFound,
+	codes.AlreadyExists:    errMess.CodeConflict,
+	codes.PermissionDenied: errMess.CodeForbidden,
+	codes.Unauthenticated:  errMess.CodeUnauthenticated,
+	codes.Unavailable:      errMess.CodeUnavailable,
+}
+
+// newProblem переводит ошибку хранилища, gRPC-статус, ошибку валидации или разбора JSON
+// в problem. Текст неизвестных ошибок клиенту не отдаётся.
+func newProblem(err error) problem {
+	var (
+		domainErr  *errMess.Error
+		validation validator.ValidationErrors
+		syntaxErr  *json.SyntaxError
+		typeErr    *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &domainErr):
+		return problemFor(domainErr.Code, domainErr.Message)
+	case errors.As(err, &validation):
+		p := problemFor(errMess.CodeValidation, "request validation failed")
+		for _, fe := range validation {
+			p.Errors = append(p.Errors, fieldError{
+				Field:   fe.Field(),
+				Rule:    fe.Tag(),
+				Message: fe.Error(),
+			})
+		}
+		return p
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return problemFor(errMess.CodeBadRequest, "malformed JSON body: "+err.Error())
+	}
+	if st, ok := status.FromError(err); ok && st.Code() != codes.OK {
+		if code, known := grpcCodes[st.Code()]; known {
+			return problemFor(code, st.Message())
+		}
+	}
+	return problemFor(errMess.CodeInternal, "internal server error")
+}
+
+func problemFor(code errMess.Code, detail string) problem {
+	httpStatus, ok := codeStatus[code]
+	if !ok {
+		httpStatus = http.StatusInternalServerError
+	}
+	return problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(httpStatus),
+		Status: httpStatus,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// writeProblem отвечает клиенту ошибкой в формате application/problem+json.
+// Внутренние ошибки пишутся в лог запроса целиком.
+func writeProblem(ctx *gin.Context, err error) {
+	p := newProblem(err)
+	p.Instance = ctx.Request.URL.Path
+	if id, ok := requestid.FromContext(ctx.Request.Context()); ok {
+		p.RequestID = id
+	}
+	if p.Status >= http.StatusInternalServerError {
+		zLog := logger.FromContext(ctx.Request.Context())
+		zLog.Error().Err(err).Str("code", string(p.Code)).Msg("request failed")
+	}
+	_ = ctx.Error(err)
+	ctx.Header("Content-Type", problemContentType)
+	ctx.AbortWithStatusJSON(p.Status, p)
+}
+
+// newValidator создаёт валидатор, который называет поля по их JSON-именам.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+	return v
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/requestid"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteProblem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	validationErr := newValidator().Struct(models.User{Name: "", Email: "not-an-email", Pass: "pass"})
+	require.Error(t, validationErr)
+	testCases := []struct {
+		name   string
+		err    error
+		status int
+		code   errMess.Code
+		detail string
+		fields []string
+	}{
+		{
+			name:   "Test writeProblem() func; Case 1: storage error",
+			err:    storage.ErrBookNotFound,
+			status: http.StatusNotFound,
+			code:   errMess.CodeBookNotFound,
+			detail: errMess.BookNotFoundError,
+		},
+		{
+			name:   "Test writeProblem() func; Case 2: wrapped storage error",
+			err:    errors.Join(errors.New("scan"), storage.ErrUserNotFound),
+			status: http.StatusNotFound,
+			code:   errMess.CodeUserNotFound,
+			detail: errMess.UserNotFoundError,
+		},
+		{
+			name:   "Test writeProblem() func; Case 3: validation",
+			err:    validationErr,
+			status: http.StatusBadRequest,
+			code:   errMess.CodeValidation,
+			detail: "request validation failed",
+			fields: []string{"name", "email"},
+		},
+		{
+			name:   "Test writeProblem() func; Case 4: malformed json",
+			err:    json.Unmarshal([]byte(`{"name":`), &models.User{}),
+			status: http.StatusBadRequest,
+			code:   errMess.CodeBadRequest,
+		},
+		{
+			name:   "Test writeProblem() func; Case 5: grpc status",
+			err:    status.Error(codes.AlreadyExists, "user exists"),
+			status: http.StatusConflict,
+			code:   errMess.CodeConflict,
+			detail: "user exists",
+		},
+		{
+			name:   "Test writeProblem() func; Case 6: unknown error is hidden",
+			err:    errors.New("pq: connection refused"),
+			status: http.StatusInternalServerError,
+			code:   errMess.CodeInternal,
+			detail: "internal server error",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			req := httptest.NewRequest(http.MethodGet, "/book/1", nil)
+			ctx.Request = req.WithContext(requestid.NewContext(req.Context(), "req-1"))
+
+			writeProblem(ctx, tc.err)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+			var p problem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, "about:blank", p.Type)
+			assert.Equal(t, http.StatusText(tc.status), p.Title)
+			assert.Equal(t, tc.status, p.Status)
+			assert.Equal(t, tc.code, p.Code)
+			assert.Equal(t, "/book/1", p.Instance)
+			assert.Equal(t, "req-1", p.RequestID)
+			if tc.detail != "" {
+				assert.Equal(t, tc.detail, p.Detail)
+			}
+			var fields []string
+			for _, fe := range p.Errors {
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, tc.fields, fields)
+		})
+	}
+}
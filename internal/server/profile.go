@@ -0,0 +1,170 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const emailTokenTTL = 24 * time.Hour
+
+// Mailer отправляет пользователю письма с кодами подтверждения. Без него смена почты отключена:
+// код подтверждения иначе никуда не попадёт.
+type Mailer interface {
+	SendEmailVerification(email, token string) error
+}
+
+type updateProfileRequest struct {
+	Name            *string `json:"name" validate:"omitempty,min=1"`
+	Email           *string `json:"email" validate:"omitempty,email"`
+	CurrentPassword string  `json:"current_password"`
+	NewPassword     *string `json:"new_password" validate:"omitempty,min=8"`
+}
+
+type confirmEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ProfileHandler возвращает профиль текущего пользователя.
+func (s *Server) ProfileHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	user, err := s.storage.GetUserByID(ctx.Request.Context(), principal.UserID)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	writeTagged(ctx, versionETag(user.Version), newUserResponse(user))
+}
+
+// UpdateProfileHandler частично обновляет профиль текущего пользователя.
+// Смена пароля требует текущий пароль, новая почта вступает в силу после подтверждения.
+// If-Match обязателен: профиль могли изменить из другой сессии или администратор.
+func (s *Server) UpdateProfileHandler(ctx *gin.Context) {
+	zLog := logger.FromContext(ctx.Request.Context())
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	version, err := ifMatch(ctx)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	var req updateProfileRequest
+	if err := ctx.ShouldBindBodyWithJSON(&req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.validator.Struct(req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	user, err := s.storage.GetUserByID(ctx.Request.Context(), principal.UserID)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	// хранилище ещё раз сверит версию при записи: прочитанная здесь запись могла устареть
+	if version != 0 && version != user.Version {
+		writeProblem(ctx, storage.ErrVersionMismatch)
+		return
+	}
+	// проверяется до остальных изменений, чтобы запрос не применился наполовину
+	if req.Email != nil && *req.Email != user.Email && s.mailer == nil {
+		writeProblem(ctx, errNoMailer)
+		return
+	}
+	if req.NewPassword != nil {
+		if req.CurrentPassword == "" {
+			writeProblem(ctx, errMess.New(errMess.CodeBadRequest, "current_password is required to change password"))
+			return
+		}
+		user.Pass = req.CurrentPassword
+		if _, err = s.storage.ValidateUser(ctx.Request.Context(), user); err != nil {
+			if errors.Is(err, storage.ErrInvalidAuthData) {
+				writeProblem(ctx, errWrongPassword)
+				return
+			}
+			writeProblem(ctx, err)
+			return
+		}
+	}
+	if req.Name != nil || req.NewPassword != nil {
+		user.Pass = ""
+		if req.Name != nil {
+			user.Name = *req.Name
+		}
+		if req.NewPassword != nil {
+			user.Pass = *req.NewPassword
+		}
+		if err = s.storage.UpdateUser(ctx.Request.Context(), principal.UserID, user); err != nil {
+			writeProblem(ctx, err)
+			return
+		}
+		user.Version++
+	}
+	ctx.Header("ETag", versionETag(user.Version))
+	if req.Email != nil && *req.Email != user.Email {
+		if _, err = s.storage.GetUserByEmail(ctx.Request.Context(), *req.Email); err == nil {
+			writeProblem(ctx, storage.ErrEmailTaken)
+			return
+		} else if !errors.Is(err, storage.ErrUserNotFound) {
+			writeProblem(ctx, err)
+			return
+		}
+		token, err := randomHex(16)
+		if err != nil {
+			writeProblem(ctx, err)
+			return
+		}
+		if err = s.storage.SetPendingEmail(ctx.Request.Context(), principal.UserID, *req.Email, hashToken(token), time.Now().Add(emailTokenTTL)); err != nil {
+			writeProblem(ctx, err)
+			return
+		}
+		if err = s.mailer.SendEmailVerification(*req.Email, token); err != nil {
+			zLog.Error().Err(err).Msg("failed to send email verification")
+			writeProblem(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"message": "email change pending verification",
+			"user":    newUserResponse(user),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, newUserResponse(user))
+}
+
+// ConfirmEmailHandler применяет смену почты по коду из письма.
+func (s *Server) ConfirmEmailHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	var req confirmEmailRequest
+	if err := ctx.ShouldBindBodyWithJSON(&req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.validator.Struct(req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	email, err := s.storage.ConfirmEmail(ctx.Request.Context(), principal.UserID, hashToken(req.Token))
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "email successfully changed", "email": email})
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+type testMailer struct {
+	email string
+	token string
+}
+
+func (m *testMailer) SendEmailVerification(email, token string) error {
+	m.email = email
+	m.token = token
+	return nil
+}
+
+func TestUpdateProfileHandler(t *testing.T) {
+	mailer := &testMailer{}
+	srv := &Server{
+		validator: validator.New(),
+		mailer:    mailer,
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	me := r.Group("/me", srv.Authenticate(), RequireUserToken())
+	me.GET("", srv.ProfileHandler)
+	me.PATCH("", srv.UpdateProfileHandler)
+	me.POST("/email/confirm", srv.ConfirmEmailHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "uid")
+	stored := models.User{UID: "uid", Name: "Sergei", Email: "old@ya.ru", Pass: "hash", Role: models.RoleMember, Version: 4}
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		header     map[string]string
+		mockSetup  func(*mocks.MockStorage)
+		statusCode int
+		contains   string
+		etag       string
+	}{
+		{
+			name:   "Test ProfileHandler() func; Case 1: get profile",
+			method: http.MethodGet,
+			path:   "/me",
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+			},
+			statusCode: http.StatusOK,
+			contains:   `"email":"old@ya.ru"`,
+			etag:       `"4"`,
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 2: change name",
+			method: http.MethodPatch,
+			path:   "/me",
+			header: map[string]string{"If-Match": `"4"`},
+			body:   `{"name":"Ivan"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+				m.EXPECT().UpdateUser(gomock.Any(), "uid", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, user models.User) error {
+					assert.Equal(t, "Ivan", user.Name)
+					assert.Empty(t, user.Pass)
+					assert.Equal(t, int64(4), user.Version)
+					return nil
+				})
+			},
+			statusCode: http.StatusOK,
+			contains:   `"name":"Ivan"`,
+			etag:       `"5"`,
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 3: password without current",
+			method: http.MethodPatch,
+			path:   "/me",
+			header: map[string]string{"If-Match": `"4"`},
+			body:   `{"new_password":"newpassword123"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+			},
+			statusCode: http.StatusBadRequest,
+			contains:   "current_password",
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 4: wrong current password",
+			method: http.MethodPatch,
+			path:   "/me",
+			header: map[string]string{"If-Match": `"4"`},
+			body:   `{"current_password":"wrong","new_password":"newpassword123"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+				m.EXPECT().ValidateUser(gomock.Any(), gomock.Any()).Return("", storage.ErrInvalidAuthData)
+			},
+			statusCode: http.StatusForbidden,
+			contains:   "incorrect",
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 5: change password",
+			method: http.MethodPatch,
+			path:   "/me",
+			header: map[string]string{"If-Match": `"4"`},
+			body:   `{"current_password":"qwerty1234","new_password":"newpassword123"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+				m.EXPECT().ValidateUser(gomock.Any(), gomock.Any()).Return("uid", nil)
+				m.EXPECT().UpdateUser(gomock.Any(), "uid", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, user models.User) error {
+					assert.Equal(t, "newpassword123", user.Pass)
+					return nil
+				})
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 6: change email",
+			method: http.MethodPatch,
+			path:   "/me",
+			header: map[string]string{"If-Match": `"4"`},
+			body:   `{"email":"new@ya.ru"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+				m.EXPECT().GetUserByEmail(gomock.Any(), "new@ya.ru").Return(models.User{}, storage.ErrUserNotFound)
+				m.EXPECT().SetPendingEmail(gomock.Any(), "uid", "new@ya.ru", gomock.Any(), gomock.Any()).Return(nil)
+			},
+			statusCode: http.StatusAccepted,
+			contains:   `"email":"old@ya.ru"`,
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 7: email taken",
+			method: http.MethodPatch,
+			path:   "/me",
+			header: map[string]string{"If-Match": `"4"`},
+			body:   `{"email":"taken@ya.ru"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+				m.EXPECT().GetUserByEmail(gomock.Any(), "taken@ya.ru").Return(models.User{UID: "other"}, nil)
+			},
+			statusCode: http.StatusConflict,
+		},
+		{
+			name:   "Test ConfirmEmailHandler() func; Case 8: invalid token",
+			method: http.MethodPost,
+			path:   "/me/email/confirm",
+			body:   `{"token":"bad"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil)
+				m.EXPECT().ConfirmEmail(gomock.Any(), "uid", hashToken("bad")).Return("", storage.ErrEmailTokenInvalid)
+			},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 9: no If-Match",
+			method: http.MethodPatch,
+			path:   "/me",
+			body:   `{"name":"Ivan"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil)
+			},
+			statusCode: http.StatusPreconditionRequired,
+			contains:   `"code":"precondition_required"`,
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 10: stale If-Match",
+			method: http.MethodPatch,
+			path:   "/me",
+			body:   `{"name":"Ivan"}`,
+			header: map[string]string{"If-Match": `"3"`},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+			},
+			statusCode: http.StatusPreconditionFailed,
+			contains:   `"code":"version_mismatch"`,
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 11: storage detects a concurrent change",
+			method: http.MethodPatch,
+			path:   "/me",
+			body:   `{"name":"Ivan"}`,
+			header: map[string]string{"If-Match": "*"},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+				m.EXPECT().UpdateUser(gomock.Any(), "uid", gomock.Any()).Return(storage.ErrVersionMismatch)
+			},
+			statusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:   "Test ProfileHandler() func; Case 12: not modified",
+			method: http.MethodGet,
+			path:   "/me",
+			header: map[string]string{"If-None-Match": `W/"3", "4"`},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+			},
+			statusCode: http.StatusNotModified,
+			etag:       `"4"`,
+		},
+		{
+			name:   "Test UpdateProfileHandler() func; Case 13: api key",
+			method: http.MethodPatch,
+			path:   "/me",
+			header: map[string]string{apiKeyHeader: "lsk_valid", "If-Match": "*"},
+			body:   `{"name":"Ivan"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetAPIKeyByHash(gomock.Any(), hashToken("lsk_valid")).Return(models.APIKey{
+					ID: "key", OwnerUID: "uid", Scopes: []string{models.ScopeBooksRead},
+				}, nil)
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil)
+			},
+			statusCode: http.StatusForbidden,
+			contains:   "api keys are not accepted",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mockStorage)
+			}
+			srv.storage = mockStorage
+			req := resty.New().R().SetHeader("Authorization", token).SetHeaders(tc.header)
+			if tc.body != "" {
+				req.SetBody(tc.body)
+			}
+			resp, err := req.Execute(tc.method, httpSrv.URL+tc.path)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.contains)
+			assert.NotContains(t, resp.String(), `"pass":`)
+			if tc.etag != "" {
+				assert.Equal(t, tc.etag, resp.Header().Get("ETag"))
+			}
+		})
+	}
+	assert.Equal(t, "new@ya.ru", mailer.email)
+	assert.NotEmpty(t, mailer.token)
+}
+
+func TestRequireRole(t *testing.T) {
+	srv := &Server{
+		validator: validator.New(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/update_user/:id", srv.Authenticate(), RequireRole(models.RoleAdmin), RequireScope(models.ScopeUsersAdmin), srv.UpdateUserHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	// apiKey отдаёт ключ администратора admin с областями scopes
+	apiKey := func(scopes ...string) func(*mocks.MockStorage) {
+		return func(m *mocks.MockStorage) {
+			m.EXPECT().GetAPIKeyByHash(gomock.Any(), hashToken("lsk_admin")).Return(models.APIKey{ID: "key", OwnerUID: "admin", Scopes: scopes}, nil)
+			m.EXPECT().GetUserByID(gomock.Any(), "admin").Return(models.User{UID: "admin", Role: models.RoleAdmin}, nil)
+		}
+	}
+	testCases := []struct {
+		name       string
+		header     map[string]string
+		mockSetup  func(*mocks.MockStorage)
+		statusCode int
+		contains   string
+	}{
+		{
+			name:   "Test RequireRole() func; Case 1: member token",
+			header: map[string]string{"Authorization": testJWT(t, "uid")},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleMember}, nil)
+			},
+			statusCode: http.StatusForbidden,
+			contains:   "insufficient role",
+		},
+		{
+			name:       "Test RequireRole() func; Case 2: admin key without users:admin",
+			header:     map[string]string{apiKeyHeader: "lsk_admin"},
+			mockSetup:  apiKey(models.ScopeBooksRead),
+			statusCode: http.StatusForbidden,
+			contains:   "missing scope " + models.ScopeUsersAdmin,
+		},
+		{
+			name:   "Test RequireRole() func; Case 3: admin key with users:admin",
+			header: map[string]string{apiKeyHeader: "lsk_admin"},
+			mockSetup: func(m *mocks.MockStorage) {
+				apiKey(models.ScopeUsersAdmin)(m)
+				m.EXPECT().UpdateUser(gomock.Any(), "other", gomock.Any()).Return(nil)
+			},
+			statusCode: http.StatusOK,
+		},
+		{
+			name:   "Test RequireRole() func; Case 4: admin token",
+			header: map[string]string{"Authorization": testJWT(t, "admin")},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "admin").Return(models.User{UID: "admin", Role: models.RoleAdmin}, nil)
+				m.EXPECT().UpdateUser(gomock.Any(), "other", gomock.Any()).Return(nil)
+			},
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			tc.mockSetup(mockStorage)
+			srv.storage = mockStorage
+			resp, err := resty.New().R().
+				SetHeaders(tc.header).
+				SetHeader("If-Match", "*").
+				SetBody(`{"name":"Updated Name","email":"updated@example.com"}`).
+				Put(httpSrv.URL + "/update_user/other")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.contains)
+		})
+	}
+}
+
+func TestUpdateProfileWithoutMailer(t *testing.T) {
+	srv := &Server{
+		validator: validator.New(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PATCH("/me", srv.Authenticate(), RequireUserToken(), srv.UpdateProfileHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStorage := mocks.NewMockStorage(ctrl)
+	stored := models.User{UID: "uid", Name: "Sergei", Email: "old@ya.ru", Role: models.RoleMember, Version: 4}
+	// ни имя, ни ожидающая почта не сохраняются: без почты код подтверждения некуда отправить
+	mockStorage.EXPECT().GetUserByID(gomock.Any(), "uid").Return(stored, nil).Times(2)
+	srv.storage = mockStorage
+
+	resp, err := resty.New().R().
+		SetHeader("Authorization", "Bearer "+testJWT(t, "uid")).
+		SetHeader("If-Match", `"4"`).
+		SetBody(`{"name":"Ivan","email":"new@ya.ru"}`).
+		Patch(httpSrv.URL + "/me")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode())
+	assert.Contains(t, resp.String(), `"code":"not_implemented"`)
+}
@@ -0,0 +1,35 @@
+package server
+
+import "github.com/Rustam2595/library_service/internal/domain/models"
+
+// userResponse - представление пользователя в ответах API. Пароль и секреты 2FA наружу не попадают.
+type userResponse struct {
+	UID         string `json:"uid"`
+	Name        string `json:"name"`
+	Email       string `json:"email" redact:"email"`
+	DeletedUser bool   `json:"deleted_user"`
+	Role        string `json:"role,omitempty"`
+	TOTPEnabled bool   `json:"totp_enabled,omitempty"`
+	// Version - версия записи: администратор передаёт её в If-Match при изменении и удалении пользователя
+	Version int64 `json:"version"`
+}
+
+func newUserResponse(user models.User) userResponse {
+	return userResponse{
+		UID:         user.UID,
+		Name:        user.Name,
+		Email:       user.Email,
+		DeletedUser: user.DeletedUser,
+		Role:        user.Role,
+		TOTPEnabled: user.TOTPEnabled,
+		Version:     user.Version,
+	}
+}
+
+func newUserResponses(users []models.User) []userResponse {
+	resp := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		resp = append(resp, newUserResponse(user))
+	}
+	return resp
+}
@@ -4,16 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
 	"github.com/Rustam2595/library_service/internal/domain/models"
 	authservicev1 "github.com/Rustam2595/library_service/internal/gen/go"
 	books_servicev1 "github.com/Rustam2595/library_service/internal/genBooks/go"
 	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/metrics"
 	"github.com/Rustam2595/library_service/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -23,6 +27,9 @@ import (
 
 var secretKey = []byte("VerySecretKey2000")
 
+// ServiceName - имя сервиса в трассах OpenTelemetry.
+const ServiceName = "library_service"
+
 type Claims struct {
 	UserID string //`json:"user_id"`
 	//Username string `json:"username"`
@@ -31,18 +38,35 @@ type Claims struct {
 }
 
 type Storage interface {
-	SaveUser(models.User) (string, error)
-	ValidateUser(models.User) (string, string, error)
-	GetUsers() ([]models.User, error)
-	UpdateUser(string, models.User) error
-	DeleteUser(string) error
-	DeleteUsers() error
-	GetBooks() ([]models.Book, error)
-	GetBookByID(string) (models.Book, error)
-	GetBookByUID(string) ([]models.Book, error)
-	SaveBook(models.Book) error
-	DeleteBook(string) error
-	DeleteBooks() error
+	SaveUser(context.Context, models.User) (string, error)
+	ValidateUser(context.Context, models.User) (string, error)
+	GetUsers(context.Context) ([]models.User, error)
+	GetUserByID(context.Context, string) (models.User, error)
+	GetUserByEmail(context.Context, string) (models.User, error)
+	SetTOTPSecret(context.Context, string, string) error
+	EnableTOTP(context.Context, string, []string) error
+	UseRecoveryCode(context.Context, string, string) error
+	SaveAPIKey(context.Context, models.APIKey) error
+	GetAPIKeyByID(context.Context, string) (models.APIKey, error)
+	GetAPIKeyByHash(context.Context, string) (models.APIKey, error)
+	GetAPIKeysByOwner(context.Context, string) ([]models.APIKey, error)
+	RevokeAPIKey(context.Context, string) error
+	SetPendingEmail(context.Context, string, string, string, time.Time) error
+	ConfirmEmail(context.Context, string, string) (string, error)
+	EraseUser(context.Context, string) error
+	UpdateUser(context.Context, string, models.User) error
+	DeleteUser(context.Context, string, int64) error
+	DeleteUsers(context.Context) error
+	GetBooks(context.Context) ([]models.Book, error)
+	ExportBooks(context.Context, func(models.Book) error) error
+	GetBookByID(context.Context, string) (models.Book, error)
+	GetBookByUID(context.Context, string) ([]models.Book, error)
+	SaveBook(context.Context, models.Book) error
+	SaveBooks(context.Context, []models.Book) error
+	UpdateBook(context.Context, models.Book, string) (models.Book, error)
+	GetBookHistory(context.Context, string) ([]models.BookRevision, error)
+	DeleteBook(context.Context, string, int64) error
+	DeleteBooks(context.Context) error
 }
 type Server struct {
 	serve          *http.Server
@@ -53,12 +77,22 @@ type Server struct {
 	ErrChan        chan error
 	AuthClient     authservicev1.AuthServiceClient
 	BooksClient    books_servicev1.BooksServiceClient
+	totpSkew       uint
+	importMaxBytes int64
+	challenges     *challengeStore
+	mailer         Mailer
+	exports        *exportStore
+	// catalogExports - число идущих выгрузок каталога, см. maxCatalogExports
+	catalogExports atomic.Int32
 }
 
 func New(host string,
 	storage Storage,
 	authClient authservicev1.AuthServiceClient,
-	booksClient books_servicev1.BooksServiceClient) *Server {
+	booksClient books_servicev1.BooksServiceClient,
+	totpSkew uint,
+	importMaxBytes int64,
+	mailer Mailer) *Server {
 	serv := http.Server{
 		Addr:              host,
 		ReadHeaderTimeout: 5 * time.Second,  // время на чтение заголовков
@@ -72,34 +106,67 @@ func New(host string,
 	return &Server{
 		serve:          &serv,
 		storage:        storage,
-		validator:      validator.New(),
+		validator:      newValidator(),
 		deleteChan:     dChan,
 		deleteUserChan: dUserChan,
 		ErrChan:        errChan,
 		AuthClient:     authClient,
 		BooksClient:    booksClient,
+		totpSkew:       totpSkew,
+		importMaxBytes: importMaxBytes,
+		challenges:     newChallengeStore(),
+		mailer:         mailer,
+		exports:        newExportStore(),
 	}
 }
 func (s *Server) Run(ctx context.Context) error {
 	go s.Deleter(ctx)
-	r := gin.Default()
-	//r.Use(gin.Recovery())
-	//r.Use(gin.Logger())
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(otelgin.Middleware(ServiceName))
+	r.Use(AccessLog())
+	r.Use(metrics.GinMiddleware())
 	userGroup := r.Group("/user")
 	{
 		userGroup.POST("/register", s.RegisterHandler)
 		userGroup.POST("/auth", s.AuthHandler)
-		userGroup.GET("/get_all_users", s.AllUsersHandler)
-		userGroup.PUT("/update_user/:id", s.UpdateUserHandler)
-		userGroup.DELETE("/delete/:id", s.DeleteUserHandler)
+		userGroup.POST("/auth/2fa", s.TwoFactorAuthHandler)
+		userGroup.POST("/2fa/enroll", s.Authenticate(), RequireUserToken(), s.EnrollTOTPHandler)
+		userGroup.POST("/2fa/verify", s.Authenticate(), RequireUserToken(), s.VerifyTOTPHandler)
+		// ключ администратора даёт управление пользователями, только если выпущен с областью users:admin
+		userGroup.GET("/get_all_users", s.Authenticate(), RequireRole(models.RoleAdmin), RequireScope(models.ScopeUsersAdmin), s.AllUsersHandler)
+		userGroup.PUT("/update_user/:id", s.Authenticate(), RequireRole(models.RoleAdmin), RequireScope(models.ScopeUsersAdmin), s.UpdateUserHandler)
+		userGroup.DELETE("/delete/:id", s.Authenticate(), RequireRole(models.RoleAdmin), RequireScope(models.ScopeUsersAdmin), s.DeleteUserHandler)
+		// профиль, выгрузка данных и удаление аккаунта доступны только по токену пользователя, не по API-ключу
+		meGroup := userGroup.Group("/me", s.Authenticate(), RequireUserToken())
+		{
+			meGroup.GET("", s.ProfileHandler)
+			meGroup.PATCH("", s.UpdateProfileHandler)
+			meGroup.POST("/email/confirm", s.ConfirmEmailHandler)
+			meGroup.POST("/export", s.CreateExportHandler)
+			meGroup.GET("/export/:id", s.ExportStatusHandler)
+			meGroup.GET("/export/:id/download", s.ExportDownloadHandler)
+			meGroup.POST("/erase", s.EraseAccountHandler)
+		}
+		keyGroup := userGroup.Group("/api-keys", s.Authenticate())
+		{
+			keyGroup.POST("", s.CreateAPIKeyHandler)
+			keyGroup.GET("", s.APIKeysHandler)
+			keyGroup.DELETE("/:id", s.RevokeAPIKeyHandler)
+		}
 	}
 	bookGroup := r.Group("/book")
 	{
-		bookGroup.GET("/my-books", s.BooksByUser)
+		bookGroup.GET("/my-books", s.Authenticate(), RequireScope(models.ScopeBooksRead), s.BooksByUser)
 		bookGroup.GET("/all_books", s.AllBooksHandler)
+		bookGroup.GET("/export", s.Authenticate(), RequireScope(models.ScopeBooksRead), s.ExportCatalogHandler)
 		bookGroup.GET("/:id", s.GetBookByIdHandler)
-		bookGroup.POST("/add_book", s.SaveBookHandler)
-		bookGroup.DELETE("/delete/:id", s.DeleteBookHandler)
+		bookGroup.GET("/:id/history", s.Authenticate(), RequireScope(models.ScopeBooksRead), s.BookHistoryHandler)
+		bookGroup.PUT("/:id", s.Authenticate(), RequireScope(models.ScopeBooksWrite), s.UpdateBookHandler)
+		bookGroup.PATCH("/:id", s.Authenticate(), RequireScope(models.ScopeBooksWrite), s.PatchBookHandler)
+		bookGroup.POST("/add_book", s.Authenticate(), RequireScope(models.ScopeBooksWrite), s.SaveBookHandler)
+		bookGroup.POST("/import", s.Authenticate(), RequireScope(models.ScopeBooksWrite), s.ImportBooksHandler)
+		bookGroup.DELETE("/delete/:id", s.Authenticate(), RequireScope(models.ScopeBooksWrite), s.DeleteBookHandler)
 	}
 	s.serve.Handler = r
 	if err := s.serve.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -109,14 +176,14 @@ func (s *Server) Run(ctx context.Context) error {
 }
 
 func (s *Server) RegisterHandler(ctx *gin.Context) {
-	zLog := logger.Get()
+	zLog := logger.FromContext(ctx.Request.Context())
 	var user models.User
 	if err := ctx.ShouldBindBodyWithJSON(&user); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
 	if err := s.validator.Struct(user); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
 	zLog.Debug().Msg("User successfully registered with JSON")
@@ -137,7 +204,7 @@ func (s *Server) RegisterHandler(ctx *gin.Context) {
 	//	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	//	return
 	//}
-	authResp, err := s.AuthClient.Register(context.TODO(), &authservicev1.User{
+	authResp, err := s.AuthClient.Register(ctx.Request.Context(), &authservicev1.User{
 		Name:  user.Name,
 		Email: user.Email,
 		Pass:  user.Pass,
@@ -145,27 +212,27 @@ func (s *Server) RegisterHandler(ctx *gin.Context) {
 	if err != nil {
 		if status.Code(err) == codes.AlreadyExists {
 			zLog.Error().Err(err).Msg("user already exists")
-			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			writeProblem(ctx, errMess.Wrap(errMess.CodeUserExists, "user already exists", err))
 			return
 		}
 		zLog.Error().Err(err).Msg("Failed to register user")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
-	zLog.Debug().Str("token:", authResp.Token).Str("msg:", authResp.Message).Msg("grpc login request successful")
+	zLog.Debug().Str("msg", authResp.Message).Msg("grpc login request successful")
 	ctx.Header("authorization", authResp.Token)
 	ctx.JSON(http.StatusOK, gin.H{"message": "User successfully registered"})
 }
 
 func (s *Server) AuthHandler(ctx *gin.Context) {
-	zLog := logger.Get()
+	zLog := logger.FromContext(ctx.Request.Context())
 	var user models.User
 	if err := ctx.ShouldBindBodyWithJSON(&user); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
 	if err := s.validator.Struct(user); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
 	zLog.Debug().Msg("User successfully auth with JSON")
@@ -189,55 +256,77 @@ func (s *Server) AuthHandler(ctx *gin.Context) {
 	//	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	//	return
 	//}
-	authResp, err := s.AuthClient.Login(context.TODO(), &authservicev1.UserCreds{
+	authResp, err := s.AuthClient.Login(ctx.Request.Context(), &authservicev1.UserCreds{
 		Email: user.Email,
 		Pass:  user.Pass,
 	})
 	if err != nil {
 		if status.Code(err) == codes.NotFound {
 			zLog.Error().Err(err).Msg("user not found in DB")
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			writeProblem(ctx, errMess.Wrap(errMess.CodeInvalidAuthData, errMess.InvalidAuthDataError, err))
 			return
 		} else if status.Code(err) == codes.Unauthenticated {
 			zLog.Error().Err(err).Msg("invalid password")
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			writeProblem(ctx, errMess.Wrap(errMess.CodeInvalidAuthData, errMess.InvalidAuthDataError, err))
 			return
 		}
 		zLog.Error().Err(err).Msg("Failed to login, err auth service")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
+		return
+	}
+	zLog.Debug().Str("msg", authResp.Message).Msg("grpc login request successful")
+	stored, err := s.storage.GetUserByEmail(ctx.Request.Context(), user.Email)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		zLog.Error().Err(err).Msg("failed to get user")
+		writeProblem(ctx, err)
+		return
+	}
+	if err == nil {
+		// пароль уже проверен сервисом авторизации; ValidateUser лишь перехеширует устаревшую запись,
+		// поэтому его ошибка не мешает входу
+		if _, err = s.storage.ValidateUser(ctx.Request.Context(), user); err != nil {
+			zLog.Warn().Err(err).Str("uid", stored.UID).Msg("failed to upgrade password hash")
+		}
+	}
+	if stored.TOTPEnabled {
+		challengeID, err := s.challenges.create(stored.UID, authResp.Token)
+		if err != nil {
+			writeProblem(ctx, err)
+			return
+		}
+		zLog.Debug().Str("uid", stored.UID).Msg("two-factor code required")
+		ctx.JSON(http.StatusAccepted, gin.H{"message": "two-factor code required", "challenge_id": challengeID})
 		return
 	}
-	zLog.Debug().Str("token:", authResp.Token).Str("msg:", authResp.Message).Msg("grpc login request successful")
 	ctx.Header("authorization", authResp.Token)
-	ctx.JSON(http.StatusOK, gin.H{"message": "email = " + user.Email + " , PASS = " + user.Pass + authResp.Message})
+	ctx.JSON(http.StatusOK, gin.H{"message": "User successfully authenticated"})
 }
 
 func (s *Server) AllUsersHandler(ctx *gin.Context) {
-	users, err := s.storage.GetUsers()
+	users, err := s.storage.GetUsers(ctx.Request.Context())
 	if err != nil {
-		if errors.Is(err, storage.ErrUserListEmpty) {
-			ctx.String(http.StatusNoContent, "There are no users here!")
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, users)
+	writeHashed(ctx, newUserResponses(users))
 }
 
 func (s *Server) UpdateUserHandler(ctx *gin.Context) {
 	var user models.User
 	uid := ctx.Param("id")
+	version, err := ifMatch(ctx)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
 	if err := ctx.ShouldBindBodyWithJSON(&user); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
-	if err := s.storage.UpdateUser(uid, user); err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// версию задаёт только If-Match, поле version из тела игнорируется
+	user.Version = version
+	if err := s.storage.UpdateUser(ctx.Request.Context(), uid, user); err != nil {
+		writeProblem(ctx, err)
 		return
 	}
 	ctx.JSON(http.StatusOK, gin.H{"message": "User successfully updated"})
@@ -245,12 +334,13 @@ func (s *Server) UpdateUserHandler(ctx *gin.Context) {
 
 func (s *Server) DeleteUserHandler(ctx *gin.Context) {
 	uid := ctx.Param("id")
-	if err := s.storage.DeleteUser(uid); err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			ctx.JSON(http.StatusNoContent, err.Error())
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	version, err := ifMatch(ctx)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.storage.DeleteUser(ctx.Request.Context(), uid, version); err != nil {
+		writeProblem(ctx, err)
 		return
 	}
 	s.deleteUserChan <- 1
@@ -258,67 +348,57 @@ func (s *Server) DeleteUserHandler(ctx *gin.Context) {
 }
 
 func (s *Server) BooksByUser(ctx *gin.Context) {
-	log := logger.Get()
-	token := ctx.GetHeader("Authorization")
-	uid, err := validJWT(token)
-	log.Debug().Msgf("token=%v, uid=%v", token, uid)
-	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	log := logger.FromContext(ctx.Request.Context())
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errInvalidToken)
 		return
 	}
-	books, err := s.storage.GetBookByUID(uid)
+	log.Debug().Msgf("uid=%v, api_key=%v", principal.UserID, principal.APIKeyID)
+	books, err := s.storage.GetBookByUID(ctx.Request.Context(), principal.UserID)
 	if err != nil {
-		if errors.Is(err, storage.ErrBooksListEmpty) {
-			ctx.JSON(http.StatusNoContent, gin.H{"error": err.Error()})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, books)
+	writeHashed(ctx, books)
 }
 
 func (s *Server) AllBooksHandler(ctx *gin.Context) {
-	books, err := s.storage.GetBooks()
+	books, err := s.storage.GetBooks(ctx.Request.Context())
 	if err != nil {
-		if errors.Is(err, storage.ErrBooksListEmpty) {
-			ctx.JSON(http.StatusNoContent, gin.H{"error": err.Error()})
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, books)
+	writeHashed(ctx, books)
 }
 
 func (s *Server) GetBookByIdHandler(ctx *gin.Context) {
 	bid := ctx.Param("id")
-	book, err := s.storage.GetBookByID(bid)
+	book, err := s.storage.GetBookByID(ctx.Request.Context(), bid)
 	if err != nil {
-		if errors.Is(err, storage.ErrBookNotFound) {
-			ctx.JSON(http.StatusNoContent, err.Error())
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, book)
+	writeTagged(ctx, versionETag(book.Version), book)
 }
 
 func (s *Server) SaveBookHandler(ctx *gin.Context) {
-	zlog := logger.Get()
+	zlog := logger.FromContext(ctx.Request.Context())
 	var book models.Book
 	if err := ctx.ShouldBindBodyWithJSON(&book); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
+	if principal, ok := principalFrom(ctx); ok {
+		book.UserUID = principal.UserID
+	}
 	if err := s.validator.Struct(book); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
-	zlog.Debug().Msgf("book=%v ready to save", book)
+	zlog.Debug().Str("label", book.Label).Str("user_uid", book.UserUID).Msg("book ready to save")
 
-	booksResp, err := s.BooksClient.CreateBook(context.TODO(), &books_servicev1.AuthRequest{
+	booksResp, err := s.BooksClient.CreateBook(ctx.Request.Context(), &books_servicev1.AuthRequest{
 		Label:   book.Label,
 		Author:  book.Author,
 		UserUid: book.UserUID,
@@ -326,19 +406,19 @@ func (s *Server) SaveBookHandler(ctx *gin.Context) {
 	if err != nil {
 		if status.Code(err) == codes.Internal {
 			zlog.Error().Err(err).Msg("token error")
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			writeProblem(ctx, errMess.Wrap(errMess.CodeUnauthenticated, "token error", err))
 			return
 		} else if status.Code(err) == codes.Unauthenticated {
 			zlog.Error().Err(err).Msg("invalid token")
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			writeProblem(ctx, err)
 			return
 		}
 		zlog.Error().Err(err).Msg("Failed to create book")
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(ctx, err)
 		return
 	}
 
-	zlog.Debug().Str("token:", booksResp.Token).Str("msg:", booksResp.Message).Msg("grpc createBooks request successful")
+	zlog.Debug().Str("msg", booksResp.Message).Msg("grpc createBooks request successful")
 
 	//token := ctx.GetHeader("Authorization")
 	//uid, err := validJWT(token)
@@ -358,12 +438,13 @@ func (s *Server) SaveBookHandler(ctx *gin.Context) {
 
 func (s *Server) DeleteBookHandler(ctx *gin.Context) {
 	bid := ctx.Param("id")
-	if err := s.storage.DeleteBook(bid); err != nil {
-		if errors.Is(err, storage.ErrBookNotFound) {
-			ctx.JSON(http.StatusNoContent, err.Error())
-			return
-		}
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	version, err := ifMatch(ctx)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.storage.DeleteBook(ctx.Request.Context(), bid, version); err != nil {
+		writeProblem(ctx, err)
 		return
 	}
 	s.deleteChan <- 1
@@ -390,7 +471,7 @@ func (s *Server) Deleter(ctx context.Context) {
 				for i := 0; i < 2; i++ {
 					<-s.deleteChan
 				}
-				if err := s.storage.DeleteBooks(); err != nil {
+				if err := s.storage.DeleteBooks(ctx); err != nil {
 					s.ErrChan <- err
 					return
 				}
@@ -399,7 +480,7 @@ func (s *Server) Deleter(ctx context.Context) {
 				for i := 0; i < 2; i++ {
 					<-s.deleteUserChan
 				}
-				if err := s.storage.DeleteUsers(); err != nil {
+				if err := s.storage.DeleteUsers(ctx); err != nil {
 					//log.Error().Err(err).Msg("error deleting users!")
 					return
 				}
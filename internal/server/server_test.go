@@ -7,20 +7,36 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/Rustam2595/library_service/internal/domain/models"
+	authservicev1 "github.com/Rustam2595/library_service/internal/gen/go"
+	"github.com/Rustam2595/library_service/internal/logger"
 	"github.com/Rustam2595/library_service/internal/storage"
 	"github.com/Rustam2595/library_service/mocks"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/mock/gomock"
-	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+func TestMain(m *testing.M) {
+	if err := logger.Init(false, ""); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
 func TestRegisterHandler(t *testing.T) {
 	srv := Server{
 		validator: validator.New(),
@@ -38,7 +54,7 @@ func TestRegisterHandler(t *testing.T) {
 		method  string
 		request string
 		user    string
-		uid     string
+		token   string
 		err     error
 		want    want
 	}{
@@ -47,7 +63,7 @@ func TestRegisterHandler(t *testing.T) {
 			method:  http.MethodPost,
 			request: "/register",
 			user:    `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
-			uid:     "testUID",
+			token:   "testToken",
 			err:     nil,
 			want: want{
 				errFlag:    false,
@@ -60,8 +76,6 @@ func TestRegisterHandler(t *testing.T) {
 			method:  http.MethodPost,
 			request: "/register",
 			user:    `{"uid":"uid","name":"Sergei","email":"testemailya.ru","pass":"qwerty1234","deleted_user":false}`,
-			//uid:     "2testUID",
-			//err:     errors.New("validator error"),
 			want: want{
 				errFlag:    true,
 				mockFlag:   false,
@@ -73,7 +87,6 @@ func TestRegisterHandler(t *testing.T) {
 			method:  http.MethodPost,
 			request: "/register",
 			user:    `{"uid":"uid","email":"testemailya.ru","pass":"qwerty1234","deleted_user":false}`,
-			uid:     "",
 			err:     errors.New("name required error"),
 			want: want{
 				errFlag:    true,
@@ -86,7 +99,6 @@ func TestRegisterHandler(t *testing.T) {
 			method:  http.MethodPost,
 			request: "/register",
 			user:    `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
-			uid:     "",
 			err:     errors.New("save user error"),
 			want: want{
 				errFlag:    true,
@@ -94,16 +106,32 @@ func TestRegisterHandler(t *testing.T) {
 				statusCode: http.StatusInternalServerError,
 			},
 		},
+		{
+			name:    "Test RegisterHandler() func; Case 5: already exists",
+			method:  http.MethodPost,
+			request: "/register",
+			user:    `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
+			err:     status.Error(codes.AlreadyExists, "user already exists"),
+			want: want{
+				errFlag:    true,
+				mockFlag:   true,
+				statusCode: http.StatusConflict,
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
-			mockRepo := mocks.NewMockStorage(ctrl)
+			mockAuth := mocks.NewMockAuthServiceClient(ctrl)
 			if tc.want.mockFlag {
-				mockRepo.EXPECT().SaveUser(gomock.Any()).Return(tc.uid, tc.err)
-				srv.storage = mockRepo
+				var resp *authservicev1.AuthResponse
+				if tc.err == nil {
+					resp = &authservicev1.AuthResponse{Token: tc.token}
+				}
+				mockAuth.EXPECT().Register(gomock.Any(), gomock.Any(), gomock.Any()).Return(resp, tc.err)
 			}
+			srv.AuthClient = mockAuth
 			req := resty.New().R()
 			req.Method = tc.method
 			req.URL = httpSrv.URL + tc.request
@@ -120,37 +148,42 @@ func TestRegisterHandler(t *testing.T) {
 
 func TestAuthHandler(t *testing.T) {
 	srv := Server{
-		validator: validator.New(),
+		validator:  validator.New(),
+		challenges: newChallengeStore(),
 	}
 	r := gin.Default()
 	r.POST("/auth", srv.AuthHandler)
 	httpSrv := httptest.NewServer(r)
 	type want struct {
 		errFlag    bool
-		mockFlag   bool
+		challenge  bool
 		statusCode int
 	}
 	testCases := []struct {
-		name    string
-		method  string
-		request string
-		user    string
-		uid     string
-		pass    string
-		err     error
-		want    want
+		name      string
+		method    string
+		request   string
+		user      string
+		authFlag  bool
+		token     string
+		authErr   error
+		storeFlag bool
+		stored    models.User
+		storeErr  error
+		rehashErr error
+		want      want
 	}{
 		{
-			name:    "Test AuthHandler() func; Case 1: OK",
-			method:  http.MethodPost,
-			request: "/auth",
-			user:    `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
-			uid:     "uid",
-			pass:    "qwerty1234",
-			err:     nil,
+			name:      "Test AuthHandler() func; Case 1: OK",
+			method:    http.MethodPost,
+			request:   "/auth",
+			user:      `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
+			authFlag:  true,
+			token:     "testToken",
+			storeFlag: true,
+			stored:    models.User{UID: "uid", Email: "testemail@ya.ru", Role: models.RoleMember},
 			want: want{
 				errFlag:    false,
-				mockFlag:   true,
 				statusCode: http.StatusOK,
 			},
 		},
@@ -159,56 +192,107 @@ func TestAuthHandler(t *testing.T) {
 			method:  http.MethodPost,
 			request: "/auth",
 			user:    `{"uid":"uid","name":"Sergei","email":"testemailya.ru","pass":"qwerty1234","deleted_user":false}`,
-			//uid:     "2testUID",
-			//pass:  "pass"
-			//err:     errors.New("validator error"),
 			want: want{
 				errFlag:    true,
-				mockFlag:   false,
 				statusCode: http.StatusBadRequest,
 			},
 		},
 		{
-			name:    "Test AuthHandler() func; Case 3: comparePass",
-			method:  http.MethodPost,
-			request: "/auth",
-			user:    `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
-			uid:     "uid",
-			pass:    "pass",
-			err:     nil,
+			name:     "Test AuthHandler() func; Case 3: comparePass",
+			method:   http.MethodPost,
+			request:  "/auth",
+			user:     `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
+			authFlag: true,
+			authErr:  status.Error(codes.Unauthenticated, "invalid password"),
 			want: want{
 				errFlag:    true,
-				mockFlag:   true,
 				statusCode: http.StatusUnauthorized,
 			},
 		},
 		{
-			name:    "Test AuthHandler() func; Case 4: validateUser",
-			method:  http.MethodPost,
-			request: "/auth",
-			user:    `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
-			uid:     "",
-			pass:    "qwerty1234",
-			err:     errors.New("validate user error"),
+			name:     "Test AuthHandler() func; Case 4: validateUser",
+			method:   http.MethodPost,
+			request:  "/auth",
+			user:     `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
+			authFlag: true,
+			authErr:  errors.New("validate user error"),
 			want: want{
 				errFlag:    true,
-				mockFlag:   true,
 				statusCode: http.StatusInternalServerError,
 			},
 		},
+		{
+			name:      "Test AuthHandler() func; Case 5: two-factor challenge",
+			method:    http.MethodPost,
+			request:   "/auth",
+			user:      `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
+			authFlag:  true,
+			token:     "testToken",
+			storeFlag: true,
+			stored:    models.User{UID: "uid", Email: "testemail@ya.ru", Role: models.RoleAdmin, TOTPEnabled: true},
+			want: want{
+				errFlag:    true,
+				challenge:  true,
+				statusCode: http.StatusAccepted,
+			},
+		},
+		{
+			name:      "Test AuthHandler() func; Case 6: storage error",
+			method:    http.MethodPost,
+			request:   "/auth",
+			user:      `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
+			authFlag:  true,
+			token:     "testToken",
+			storeFlag: true,
+			storeErr:  errors.New("get user error"),
+			want: want{
+				errFlag:    true,
+				statusCode: http.StatusInternalServerError,
+			},
+		},
+		{
+			name:      "Test AuthHandler() func; Case 7: rehash error does not fail login",
+			method:    http.MethodPost,
+			request:   "/auth",
+			user:      `{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}`,
+			authFlag:  true,
+			token:     "testToken",
+			storeFlag: true,
+			stored:    models.User{UID: "uid", Email: "testemail@ya.ru", Role: models.RoleMember},
+			rehashErr: errors.New("rehash error"),
+			want: want{
+				errFlag:    false,
+				statusCode: http.StatusOK,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
-		passHash, err := bcrypt.GenerateFromPassword([]byte(tc.pass), bcrypt.DefaultCost)
-		assert.NoError(t, err)
 		t.Run(tc.name, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
+			mockAuth := mocks.NewMockAuthServiceClient(ctrl)
 			mockRepo := mocks.NewMockStorage(ctrl)
-			if tc.want.mockFlag {
-				mockRepo.EXPECT().ValidateUser(gomock.Any()).Return(tc.uid, string(passHash), tc.err)
-				srv.storage = mockRepo
+			if tc.authFlag {
+				var resp *authservicev1.AuthResponse
+				if tc.authErr == nil {
+					resp = &authservicev1.AuthResponse{Token: tc.token}
+				}
+				mockAuth.EXPECT().Login(gomock.Any(), gomock.Any(), gomock.Any()).Return(resp, tc.authErr)
 			}
+			if tc.storeFlag {
+				mockRepo.EXPECT().GetUserByEmail(gomock.Any(), "testemail@ya.ru").Return(tc.stored, tc.storeErr)
+			}
+			if tc.storeFlag && tc.storeErr == nil {
+				mockRepo.EXPECT().ValidateUser(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, u models.User) (string, error) {
+						assert.Equal(t, "testemail@ya.ru", u.Email)
+						assert.Equal(t, "qwerty1234", u.Pass)
+						return tc.stored.UID, tc.rehashErr
+					})
+			}
+			srv.AuthClient = mockAuth
+			srv.storage = mockRepo
 			req := resty.New().R()
 			req.Method = tc.method
 			req.URL = httpSrv.URL + tc.request
@@ -218,6 +302,10 @@ func TestAuthHandler(t *testing.T) {
 				assert.NoError(t, err)
 				assert.NotEmpty(t, response.Header().Get("Authorization"))
 			}
+			if tc.want.challenge {
+				assert.Empty(t, response.Header().Get("Authorization"))
+				assert.Contains(t, response.String(), "challenge_id")
+			}
 			assert.Equal(t, tc.want.statusCode, response.StatusCode())
 		})
 	}
@@ -255,11 +343,12 @@ func TestAllUserHandler(t *testing.T) {
 					Email:       "testemail@ya.ru",
 					Pass:        "qwerty1234",
 					DeletedUser: false,
+					Version:     2,
 				},
 			},
 			want: want{
 				errFlag:    false,
-				users:      `[{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234","deleted_user":false}]`,
+				users:      `[{"uid":"uid","name":"Sergei","email":"testemail@ya.ru","deleted_user":false,"version":2}]`,
 				statusCode: http.StatusOK,
 			},
 		},
@@ -267,12 +356,12 @@ func TestAllUserHandler(t *testing.T) {
 			name:    "Test AuthHandler() func; Case 2: userListEmpty",
 			method:  http.MethodGet,
 			request: "/get_all_users",
-			err:     storage.ErrUserListEmpty,
-			users:   nil,
+			err:     nil,
+			users:   []models.User{},
 			want: want{
-				errFlag:    true,
-				users:      "",
-				statusCode: http.StatusNoContent,
+				errFlag:    false,
+				users:      "[]",
+				statusCode: http.StatusOK,
 			},
 		},
 		{
@@ -283,7 +372,7 @@ func TestAllUserHandler(t *testing.T) {
 			users:   nil,
 			want: want{
 				errFlag:    true,
-				users:      `{"error":"get users error"}`,
+				users:      `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error","instance":"/get_all_users","code":"internal"}`,
 				statusCode: http.StatusInternalServerError,
 			},
 		},
@@ -294,7 +383,7 @@ func TestAllUserHandler(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			mockRepo := mocks.NewMockStorage(ctrl)
-			mockRepo.EXPECT().GetUsers().Return(tc.users, tc.err)
+			mockRepo.EXPECT().GetUsers(gomock.Any()).Return(tc.users, tc.err)
 			srv.storage = mockRepo
 			req := resty.New().R()
 			req.Method = tc.method
@@ -309,6 +398,54 @@ func TestAllUserHandler(t *testing.T) {
 	}
 }
 
+func TestUpdateUserWithListedVersion(t *testing.T) {
+	srv := &Server{
+		validator: validator.New(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/get_all_users", srv.AllUsersHandler)
+	r.PUT("/update_user/:id", srv.UpdateUserHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := mocks.NewMockStorage(ctrl)
+	// хранилище, как настоящее, принимает только текущую версию и увеличивает её
+	version := int64(2)
+	mockRepo.EXPECT().GetUsers(gomock.Any()).Return([]models.User{{UID: "uid", Name: "Sergei", Version: version}}, nil)
+	mockRepo.EXPECT().UpdateUser(gomock.Any(), "uid", gomock.Any()).Times(2).DoAndReturn(func(_ context.Context, _ string, user models.User) error {
+		if user.Version != version {
+			return storage.ErrVersionMismatch
+		}
+		version++
+		return nil
+	})
+	srv.storage = mockRepo
+
+	var users []userResponse
+	resp, err := resty.New().R().SetResult(&users).Get(httpSrv.URL + "/get_all_users")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	if !assert.Len(t, users, 1) {
+		return
+	}
+	etag := versionETag(users[0].Version)
+	update := func() *resty.Response {
+		resp, err := resty.New().R().
+			SetHeader("If-Match", etag).
+			SetBody(`{"name":"Sergei P.","email":"testemail@ya.ru"}`).
+			Put(httpSrv.URL + "/update_user/uid")
+		assert.NoError(t, err)
+		return resp
+	}
+	assert.Equal(t, http.StatusOK, update().StatusCode())
+	// второй запрос с тем же ETag уже устарел
+	resp = update()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode())
+	assert.Contains(t, resp.String(), `"code":"version_mismatch"`)
+}
+
 func TestDeleter(t *testing.T) {
 	type want struct {
 		err error
@@ -338,8 +475,8 @@ func TestDeleter(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			m := mocks.NewMockStorage(ctrl)
 			defer ctrl.Finish()
-			m.EXPECT().DeleteBooks().Return(tc.want.err)
-			srv := New("0.0.0.0:8080", m, nil, nil)
+			m.EXPECT().DeleteBooks(gomock.Any()).Return(tc.want.err)
+			srv := New("0.0.0.0:8080", m, nil, nil, 1, 0, nil)
 			for i := 0; i < 2; i++ {
 				srv.deleteChan <- i
 			}
@@ -378,15 +515,20 @@ func TestUpdateUserHandler(t *testing.T) {
 		name        string
 		uid         string
 		requestBody string
+		ifMatch     string
 		mockSetup   func(*mocks.MockStorage)
 		want        want
 	}{
 		{
 			name:        "Test UpdateUserHandler() func; Case 1: успешное обновление",
 			uid:         "uid",
-			requestBody: `{"name":"Updated Name","email":"updated@example.com","pass":"newpassword123"}`,
+			requestBody: `{"name":"Updated Name","email":"updated@example.com","pass":"newpassword123","version":9}`,
+			ifMatch:     `"2"`,
 			mockSetup: func(m *mocks.MockStorage) {
-				m.EXPECT().UpdateUser("uid", gomock.Any()).Return(nil).Times(1)
+				m.EXPECT().UpdateUser(gomock.Any(), "uid", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, user models.User) error {
+					assert.Equal(t, int64(2), user.Version, "version comes from If-Match, not from the body")
+					return nil
+				}).Times(1)
 			},
 			want: want{
 				statusCode:   http.StatusOK,
@@ -397,26 +539,60 @@ func TestUpdateUserHandler(t *testing.T) {
 			name:        "Test UpdateUserHandler() func; Case 2: невалидный json",
 			uid:         "uid",
 			requestBody: `{"name""Updated Name","email":"updated@example.com","pass":"newpassword123"}`,
+			ifMatch:     `"2"`,
 			mockSetup: func(m *mocks.MockStorage) {
-				m.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(nil).Times(0)
+				m.EXPECT().UpdateUser(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(0)
 			},
 			want: want{
 				statusCode:   http.StatusBadRequest,
-				expectedBody: "error",
+				expectedBody: `"code":"bad_request"`,
 			},
 		},
 		{
 			name:        "Test UpdateUserHandler() func; Case 3: err UserNotFound",
 			uid:         "Updated Name",
 			requestBody: `{"name":"Updated Name","email":"updated@example.com","pass":"newpassword123"}`,
+			ifMatch:     "*",
 			mockSetup: func(m *mocks.MockStorage) {
-				m.EXPECT().UpdateUser("Updated Name", gomock.Any()).Return(storage.ErrUserNotFound).Times(1)
+				m.EXPECT().UpdateUser(gomock.Any(), "Updated Name", gomock.Any()).Return(storage.ErrUserNotFound).Times(1)
 			},
 			want: want{
 				statusCode:   http.StatusNotFound,
 				expectedBody: "not found",
 			},
 		},
+		{
+			name:        "Test UpdateUserHandler() func; Case 4: no If-Match",
+			uid:         "uid",
+			requestBody: `{"name":"Updated Name","email":"updated@example.com"}`,
+			want: want{
+				statusCode:   http.StatusPreconditionRequired,
+				expectedBody: `"code":"precondition_required"`,
+			},
+		},
+		{
+			name:        "Test UpdateUserHandler() func; Case 5: stale version",
+			uid:         "uid",
+			requestBody: `{"name":"Updated Name","email":"updated@example.com"}`,
+			ifMatch:     `"1"`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().UpdateUser(gomock.Any(), "uid", gomock.Any()).Return(storage.ErrVersionMismatch)
+			},
+			want: want{
+				statusCode:   http.StatusPreconditionFailed,
+				expectedBody: `"code":"version_mismatch"`,
+			},
+		},
+		{
+			name:        "Test UpdateUserHandler() func; Case 6: weak tag never matches",
+			uid:         "uid",
+			requestBody: `{"name":"Updated Name","email":"updated@example.com"}`,
+			ifMatch:     `W/"2"`,
+			want: want{
+				statusCode:   http.StatusPreconditionFailed,
+				expectedBody: `"code":"version_mismatch"`,
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -431,6 +607,9 @@ func TestUpdateUserHandler(t *testing.T) {
 			req.Method = http.MethodPut
 			req.URL = fmt.Sprintf("%s/update_user/%s", httpSrv.URL, tc.uid) //httpSrv.URL + tc.request
 			req.Body = tc.requestBody
+			if tc.ifMatch != "" {
+				req.SetHeader("If-Match", tc.ifMatch)
+			}
 			resp, err := req.Send()
 			//// 6. Создаём resty клиент
 			//client := resty.New()
@@ -448,3 +627,167 @@ func TestUpdateUserHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestBookHandlers(t *testing.T) {
+	srv := &Server{
+		validator: validator.New(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/book/all_books", srv.AllBooksHandler)
+	r.GET("/book/:id", srv.GetBookByIdHandler)
+	r.DELETE("/book/delete/:id", srv.DeleteBookHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	type want struct {
+		statusCode   int
+		expectedBody string
+		etag         string
+	}
+	testCases := []struct {
+		name      string
+		method    string
+		request   string
+		header    map[string]string
+		mockSetup func(*mocks.MockStorage)
+		want      want
+	}{
+		{
+			name:    "Test AllBooksHandler() func; Case 1: empty list",
+			method:  http.MethodGet,
+			request: "/book/all_books",
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBooks(gomock.Any()).Return([]models.Book{}, nil)
+			},
+			want: want{statusCode: http.StatusOK, expectedBody: "[]"},
+		},
+		{
+			name:    "Test GetBookByIdHandler() func; Case 2: not found",
+			method:  http.MethodGet,
+			request: "/book/bid",
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(models.Book{}, storage.ErrBookNotFound)
+			},
+			want: want{statusCode: http.StatusNotFound, expectedBody: `"code":"book_not_found"`},
+		},
+		{
+			name:    "Test GetBookByIdHandler() func; Case 3: deleted",
+			method:  http.MethodGet,
+			request: "/book/bid",
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(models.Book{}, storage.ErrBookWasDeleted)
+			},
+			want: want{statusCode: http.StatusGone, expectedBody: `"code":"book_deleted"`},
+		},
+		{
+			name:    "Test DeleteBookHandler() func; Case 4: not found",
+			method:  http.MethodDelete,
+			request: "/book/delete/bid",
+			header:  map[string]string{"If-Match": `"3"`},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().DeleteBook(gomock.Any(), "bid", int64(3)).Return(storage.ErrBookNotFound)
+			},
+			want: want{statusCode: http.StatusNotFound, expectedBody: `"code":"book_not_found"`},
+		},
+		{
+			name:      "Test DeleteBookHandler() func; Case 5: no If-Match",
+			method:    http.MethodDelete,
+			request:   "/book/delete/bid",
+			mockSetup: func(m *mocks.MockStorage) {},
+			want:      want{statusCode: http.StatusPreconditionRequired, expectedBody: `"code":"precondition_required"`},
+		},
+		{
+			name:    "Test DeleteBookHandler() func; Case 6: stale version",
+			method:  http.MethodDelete,
+			request: "/book/delete/bid",
+			header:  map[string]string{"If-Match": `"3"`},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().DeleteBook(gomock.Any(), "bid", int64(3)).Return(storage.ErrVersionMismatch)
+			},
+			want: want{statusCode: http.StatusPreconditionFailed, expectedBody: `"code":"version_mismatch"`},
+		},
+		{
+			name:    "Test GetBookByIdHandler() func; Case 7: ETag is the version",
+			method:  http.MethodGet,
+			request: "/book/bid",
+			header:  map[string]string{"If-None-Match": `"2"`},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(models.Book{BID: "bid", Version: 3}, nil)
+			},
+			want: want{statusCode: http.StatusOK, expectedBody: `"version":3`, etag: `"3"`},
+		},
+		{
+			name:    "Test GetBookByIdHandler() func; Case 8: not modified",
+			method:  http.MethodGet,
+			request: "/book/bid",
+			header:  map[string]string{"If-None-Match": `W/"3"`},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBookByID(gomock.Any(), "bid").Return(models.Book{BID: "bid", Version: 3}, nil)
+			},
+			want: want{statusCode: http.StatusNotModified, etag: `"3"`},
+		},
+		{
+			name:    "Test AllBooksHandler() func; Case 9: list not modified",
+			method:  http.MethodGet,
+			request: "/book/all_books",
+			header:  map[string]string{"If-None-Match": bodyETag([]byte("[]"))},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetBooks(gomock.Any()).Return([]models.Book{}, nil)
+			},
+			want: want{statusCode: http.StatusNotModified, etag: bodyETag([]byte("[]"))},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			tc.mockSetup(mockStorage)
+			srv.storage = mockStorage
+			req := resty.New().R().SetHeaders(tc.header)
+			req.Method = tc.method
+			req.URL = httpSrv.URL + tc.request
+			resp, err := req.Send()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.want.expectedBody)
+			if tc.want.etag != "" {
+				assert.Equal(t, tc.want.etag, resp.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestAuthHandlerTracePropagation(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	srv := Server{
+		validator: validator.New(),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(otelgin.Middleware(ServiceName))
+	r.POST("/auth", srv.AuthHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockAuth := mocks.NewMockAuthServiceClient(ctrl)
+	mockAuth.EXPECT().Login(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ *authservicev1.UserCreds, _ ...grpc.CallOption) (*authservicev1.AuthResponse, error) {
+			assert.Equal(t, traceID, trace.SpanContextFromContext(ctx).TraceID().String())
+			return &authservicev1.AuthResponse{Token: "token"}, nil
+		})
+	mockRepo := mocks.NewMockStorage(ctrl)
+	mockRepo.EXPECT().GetUserByEmail(gomock.Any(), "testemail@ya.ru").Return(models.User{}, storage.ErrUserNotFound)
+	srv.AuthClient = mockAuth
+	srv.storage = mockRepo
+
+	resp, err := resty.New().R().
+		SetHeader("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01").
+		SetBody(`{"name":"Sergei","email":"testemail@ya.ru","pass":"qwerty1234"}`).
+		Post(httpSrv.URL + "/auth")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer          = "library_service"
+	totpPeriod          = 30
+	challengeTTL        = 5 * time.Minute
+	challengeMaxAttempt = 5
+	recoveryCodesCount  = 10
+	recoveryCodeBytes   = 5
+)
+
+// challenge хранит токен, выданный сервисом авторизации, до подтверждения второго фактора.
+type challenge struct {
+	uid      string
+	token    string
+	attempts int
+	expires  time.Time
+}
+
+// challengeStore держит незавершённые двухшаговые входы в памяти процесса.
+type challengeStore struct {
+	mu    sync.Mutex
+	items map[string]*challenge
+}
+
+func newChallengeStore() *challengeStore {
+	return &challengeStore{items: make(map[string]*challenge)}
+}
+
+func (cs *challengeStore) create(uid, token string) (string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	now := time.Now()
+	for key, item := range cs.items {
+		if now.After(item.expires) {
+			delete(cs.items, key)
+		}
+	}
+	cs.items[id] = &challenge{uid: uid, token: token, expires: now.Add(challengeTTL)}
+	return id, nil
+}
+
+// attempt возвращает копию challenge и учитывает попытку ввода кода.
+// Просроченные и исчерпавшие попытки challenge удаляются.
+func (cs *challengeStore) attempt(id string) (challenge, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	item, ok := cs.items[id]
+	if !ok {
+		return challenge{}, false
+	}
+	if time.Now().After(item.expires) || item.attempts >= challengeMaxAttempt {
+		delete(cs.items, id)
+		return challenge{}, false
+	}
+	item.attempts++
+	return *item, true
+}
+
+func (cs *challengeStore) delete(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.items, id)
+}
+
+type twoFactorAuthRequest struct {
+	ChallengeID  string `json:"challenge_id" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// TwoFactorAuthHandler завершает вход пользователя с включённой 2FA: проверяет TOTP-код
+// или одноразовый код восстановления и выдаёт токен.
+func (s *Server) TwoFactorAuthHandler(ctx *gin.Context) {
+	zLog := logger.FromContext(ctx.Request.Context())
+	var req twoFactorAuthRequest
+	if err := ctx.ShouldBindBodyWithJSON(&req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.validator.Struct(req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	ch, ok := s.challenges.attempt(req.ChallengeID)
+	if !ok {
+		writeProblem(ctx, errChallengeExpired)
+		return
+	}
+	if req.Code != "" {
+		user, err := s.storage.GetUserByID(ctx.Request.Context(), ch.uid)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				writeProblem(ctx, errUnauthenticated)
+				return
+			}
+			writeProblem(ctx, err)
+			return
+		}
+		valid, err := s.validateTOTP(req.Code, user.TOTPSecret)
+		if err != nil || !valid {
+			zLog.Debug().Str("uid", ch.uid).Msg("invalid two-factor code")
+			writeProblem(ctx, errInvalidTOTP)
+			return
+		}
+	} else {
+		if err := s.storage.UseRecoveryCode(ctx.Request.Context(), ch.uid, hashRecoveryCode(req.RecoveryCode)); err != nil {
+			writeProblem(ctx, err)
+			return
+		}
+		zLog.Info().Str("uid", ch.uid).Msg("recovery code used")
+	}
+	s.challenges.delete(req.ChallengeID)
+	ctx.Header("authorization", ch.token)
+	ctx.JSON(http.StatusOK, gin.H{"message": "two-factor authentication passed"})
+}
+
+// EnrollTOTPHandler создаёт новый TOTP-секрет для библиотекаря или администратора.
+// 2FA включается только после подтверждения кода в VerifyTOTPHandler.
+func (s *Server) EnrollTOTPHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	uid := principal.UserID
+	user, err := s.storage.GetUserByID(ctx.Request.Context(), uid)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if !user.Privileged() {
+		writeProblem(ctx, errMess.New(errMess.CodeForbidden, "two-factor authentication is available for librarians and admins only"))
+		return
+	}
+	if user.TOTPEnabled {
+		writeProblem(ctx, errMess.New(errMess.CodeConflict, "two-factor authentication is already enabled"))
+		return
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Email,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err = s.storage.SetTOTPSecret(ctx.Request.Context(), uid, key.Secret()); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"secret": key.Secret(), "otpauth_uri": key.URL()})
+}
+
+// VerifyTOTPHandler подтверждает подключение 2FA первым кодом из приложения
+// и единожды возвращает коды восстановления.
+func (s *Server) VerifyTOTPHandler(ctx *gin.Context) {
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		writeProblem(ctx, errUnauthenticated)
+		return
+	}
+	uid := principal.UserID
+	var req totpCodeRequest
+	if err := ctx.ShouldBindBodyWithJSON(&req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err := s.validator.Struct(req); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	user, err := s.storage.GetUserByID(ctx.Request.Context(), uid)
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if user.TOTPSecret == "" {
+		writeProblem(ctx, storage.ErrTOTPNotEnrolled)
+		return
+	}
+	valid, err := s.validateTOTP(req.Code, user.TOTPSecret)
+	if err != nil || !valid {
+		writeProblem(ctx, errInvalidTOTP)
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	if err = s.storage.EnableTOTP(ctx.Request.Context(), uid, hashes); err != nil {
+		writeProblem(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+func (s *Server) validateTOTP(code, secret string) (bool, error) {
+	return totp.ValidateCustom(code, secret, time.Now(), totp.ValidateOpts{
+		Period:    totpPeriod,
+		Skew:      s.totpSkew,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := randomHex(recoveryCodeBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
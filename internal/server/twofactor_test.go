@@ -0,0 +1,287 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func testJWT(t *testing.T, uid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		UserID: uid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Subject:   uid,
+		},
+	})
+	signed, err := token.SignedString(secretKey)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestTwoFactorAuthHandler(t *testing.T) {
+	srv := &Server{
+		validator:  validator.New(),
+		challenges: newChallengeStore(),
+		totpSkew:   1,
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/auth/2fa", srv.TwoFactorAuthHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	validCode, err := totp.GenerateCode(testTOTPSecret, time.Now())
+	assert.NoError(t, err)
+	type want struct {
+		statusCode int
+		token      bool
+	}
+	testCases := []struct {
+		name      string
+		challenge bool
+		body      func(id string) string
+		mockSetup func(*mocks.MockStorage)
+		want      want
+	}{
+		{
+			name:      "Test TwoFactorAuthHandler() func; Case 1: valid TOTP",
+			challenge: true,
+			body: func(id string) string {
+				return `{"challenge_id":"` + id + `","code":"` + validCode + `"}`
+			},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil)
+			},
+			want: want{statusCode: http.StatusOK, token: true},
+		},
+		{
+			name:      "Test TwoFactorAuthHandler() func; Case 2: invalid TOTP",
+			challenge: true,
+			body: func(id string) string {
+				return `{"challenge_id":"` + id + `","code":"000000"}`
+			},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", TOTPSecret: "GEZDGNBVGY3TQOJQ", TOTPEnabled: true}, nil)
+			},
+			want: want{statusCode: http.StatusUnauthorized},
+		},
+		{
+			name:      "Test TwoFactorAuthHandler() func; Case 3: recovery code",
+			challenge: true,
+			body: func(id string) string {
+				return `{"challenge_id":"` + id + `","recovery_code":"ABCDEF0123"}`
+			},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().UseRecoveryCode(gomock.Any(), "uid", hashRecoveryCode("abcdef0123")).Return(nil)
+			},
+			want: want{statusCode: http.StatusOK, token: true},
+		},
+		{
+			name:      "Test TwoFactorAuthHandler() func; Case 4: used recovery code",
+			challenge: true,
+			body: func(id string) string {
+				return `{"challenge_id":"` + id + `","recovery_code":"abcdef0123"}`
+			},
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().UseRecoveryCode(gomock.Any(), "uid", gomock.Any()).Return(storage.ErrRecoveryCodeInvalid)
+			},
+			want: want{statusCode: http.StatusUnauthorized},
+		},
+		{
+			name: "Test TwoFactorAuthHandler() func; Case 5: unknown challenge",
+			body: func(_ string) string {
+				return `{"challenge_id":"unknown","code":"123456"}`
+			},
+			want: want{statusCode: http.StatusUnauthorized},
+		},
+		{
+			name:      "Test TwoFactorAuthHandler() func; Case 6: no code",
+			challenge: true,
+			body: func(id string) string {
+				return `{"challenge_id":"` + id + `"}`
+			},
+			want: want{statusCode: http.StatusBadRequest},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mockStorage)
+			}
+			srv.storage = mockStorage
+			var id string
+			if tc.challenge {
+				id, err = srv.challenges.create("uid", "testToken")
+				assert.NoError(t, err)
+			}
+			resp, err := resty.New().R().SetBody(tc.body(id)).Post(httpSrv.URL + "/auth/2fa")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.statusCode, resp.StatusCode())
+			if tc.want.token {
+				assert.Equal(t, "testToken", resp.Header().Get("Authorization"))
+			} else {
+				assert.Empty(t, resp.Header().Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestChallengeStoreAttempts(t *testing.T) {
+	cs := newChallengeStore()
+	id, err := cs.create("uid", "token")
+	assert.NoError(t, err)
+	for i := 0; i < challengeMaxAttempt; i++ {
+		_, ok := cs.attempt(id)
+		assert.True(t, ok)
+	}
+	_, ok := cs.attempt(id)
+	assert.False(t, ok)
+}
+
+func TestEnrollTOTPHandler(t *testing.T) {
+	srv := &Server{
+		validator:  validator.New(),
+		challenges: newChallengeStore(),
+		totpSkew:   1,
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.Default()
+	r.POST("/2fa/enroll", srv.Authenticate(), RequireUserToken(), srv.EnrollTOTPHandler)
+	r.POST("/2fa/verify", srv.Authenticate(), RequireUserToken(), srv.VerifyTOTPHandler)
+	httpSrv := httptest.NewServer(r)
+	defer httpSrv.Close()
+	token := "Bearer " + testJWT(t, "uid")
+	testCases := []struct {
+		name       string
+		path       string
+		token      string
+		apiKey     string
+		body       string
+		mockSetup  func(*mocks.MockStorage)
+		statusCode int
+		contains   string
+	}{
+		{
+			name:  "Test EnrollTOTPHandler() func; Case 1: librarian",
+			path:  "/2fa/enroll",
+			token: token,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Email: "lib@ya.ru", Role: models.RoleLibrarian}, nil).Times(2)
+				m.EXPECT().SetTOTPSecret(gomock.Any(), "uid", gomock.Any()).Return(nil)
+			},
+			statusCode: http.StatusOK,
+			contains:   "otpauth://totp/",
+		},
+		{
+			name:  "Test EnrollTOTPHandler() func; Case 2: member",
+			path:  "/2fa/enroll",
+			token: token,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Email: "m@ya.ru", Role: models.RoleMember}, nil).Times(2)
+			},
+			statusCode: http.StatusForbidden,
+			contains:   `"code":"forbidden"`,
+		},
+		{
+			name:       "Test EnrollTOTPHandler() func; Case 3: invalid token",
+			path:       "/2fa/enroll",
+			token:      "Bearer invalid",
+			statusCode: http.StatusUnauthorized,
+			contains:   `"code":"unauthenticated"`,
+		},
+		{
+			name:  "Test VerifyTOTPHandler() func; Case 4: not enrolled",
+			path:  "/2fa/verify",
+			token: token,
+			body:  `{"code":"123456"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleAdmin}, nil).Times(2)
+			},
+			statusCode: http.StatusConflict,
+			contains:   "not enrolled",
+		},
+		{
+			name:  "Test VerifyTOTPHandler() func; Case 5: storage error",
+			path:  "/2fa/verify",
+			token: token,
+			body:  `{"code":"123456"}`,
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{}, errors.New("db error"))
+			},
+			statusCode: http.StatusInternalServerError,
+			contains:   `"code":"internal"`,
+		},
+		{
+			name:   "Test EnrollTOTPHandler() func; Case 6: api key",
+			path:   "/2fa/enroll",
+			apiKey: "lsk_valid",
+			mockSetup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetAPIKeyByHash(gomock.Any(), hashToken("lsk_valid")).Return(models.APIKey{
+					ID: "key", OwnerUID: "uid", Scopes: []string{models.ScopeBooksRead, models.ScopeUsersAdmin},
+				}, nil)
+				m.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleAdmin}, nil)
+			},
+			statusCode: http.StatusForbidden,
+			contains:   "api keys are not accepted",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockStorage := mocks.NewMockStorage(ctrl)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mockStorage)
+			}
+			srv.storage = mockStorage
+			req := resty.New().R().SetBody(tc.body)
+			if tc.token != "" {
+				req.SetHeader("Authorization", tc.token)
+			}
+			if tc.apiKey != "" {
+				req.SetHeader(apiKeyHeader, tc.apiKey)
+			}
+			resp, err := req.Post(httpSrv.URL + tc.path)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.statusCode, resp.StatusCode())
+			assert.Contains(t, resp.String(), tc.contains)
+		})
+	}
+
+	t.Run("Test VerifyTOTPHandler() func; Case 7: enable", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockStorage := mocks.NewMockStorage(ctrl)
+		code, err := totp.GenerateCode(testTOTPSecret, time.Now())
+		assert.NoError(t, err)
+		mockStorage.EXPECT().GetUserByID(gomock.Any(), "uid").Return(models.User{UID: "uid", Role: models.RoleAdmin, TOTPSecret: testTOTPSecret}, nil).Times(2)
+		mockStorage.EXPECT().EnableTOTP(gomock.Any(), "uid", gomock.Len(recoveryCodesCount)).Return(nil)
+		srv.storage = mockStorage
+		resp, err := resty.New().R().
+			SetHeader("Authorization", token).
+			SetBody(`{"code":"` + code + `"}`).
+			Post(httpSrv.URL + "/2fa/verify")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Contains(t, resp.String(), "recovery_codes")
+	})
+}
@@ -0,0 +1,182 @@
+// Package cache - кеширующая обёртка над server.Storage. Чтения книг (по id и списками)
+// отдаются из LRU с ограниченным временем жизни, изменения книг сбрасывают затронутые записи.
+package cache
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/server"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DefaultSize = 1024
+	DefaultTTL  = 30 * time.Second
+)
+
+// ключи списков; книги по id хранятся в отдельном кеше
+const (
+	keyAllBooks = "books"
+	keyOwner    = "owner:"
+)
+
+// Options - размер кешей (число записей) и время жизни записи. Нулевые значения заменяются умолчаниями.
+type Options struct {
+	Size int
+	TTL  time.Duration
+}
+
+// Storage кеширует GetBookByID, GetBooks и GetBookByUID, остальные методы идут напрямую в обёрнутое хранилище.
+// Кешируются только успешные ответы.
+type Storage struct {
+	server.Storage
+	books *expirable.LRU[string, models.Book]
+	lists *expirable.LRU[string, []models.Book]
+	group singleflight.Group
+	// gen растёт при каждой инвалидации: загрузка, начатая до неё, не попадает в кеш
+	// и не объединяется с загрузками, начатыми после
+	gen    atomic.Uint64
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// New оборачивает next кешем.
+func New(next server.Storage, opts Options) *Storage {
+	if opts.Size <= 0 {
+		opts.Size = DefaultSize
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	return &Storage{
+		Storage: next,
+		books:   expirable.NewLRU[string, models.Book](opts.Size, nil, opts.TTL),
+		lists:   expirable.NewLRU[string, []models.Book](opts.Size, nil, opts.TTL),
+	}
+}
+
+// Hits - число ответов из кеша.
+func (s *Storage) Hits() uint64 { return s.hits.Load() }
+
+// Misses - число обращений к обёрнутому хранилищу.
+func (s *Storage) Misses() uint64 { return s.misses.Load() }
+
+// Len - число записей в кеше.
+func (s *Storage) Len() int { return s.books.Len() + s.lists.Len() }
+
+// load возвращает значение из кеша или загружает его через singleflight, чтобы одновременные
+// промахи по одному ключу делали один запрос к хранилищу.
+func load[V any](ctx context.Context, s *Storage, lru *expirable.LRU[string, V], key string,
+	fetch func(context.Context) (V, error)) (V, error) {
+	// чтение из primary нужно там, где кеш мог устареть, поэтому оно идёт мимо кеша
+	if storage.PrimaryRequested(ctx) {
+		s.misses.Add(1)
+		return fetch(ctx)
+	}
+	if v, ok := lru.Get(key); ok {
+		s.hits.Add(1)
+		return v, nil
+	}
+	gen := s.gen.Load()
+	fetched := false
+	v, err, _ := s.group.Do(key+"@"+strconv.FormatUint(gen, 10), func() (any, error) {
+		fetched = true
+		s.misses.Add(1)
+		// загрузку разделяют несколько запросов, поэтому отмена первого из них не должна её прерывать
+		v, err := fetch(context.WithoutCancel(ctx))
+		if err != nil {
+			return v, err
+		}
+		if s.gen.Load() == gen {
+			lru.Add(key, v)
+		}
+		return v, nil
+	})
+	// присоединившиеся к чужой загрузке в хранилище не ходили и считаются попаданиями
+	if !fetched {
+		s.hits.Add(1)
+	}
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return v.(V), nil
+}
+
+func (s *Storage) GetBookByID(ctx context.Context, bid string) (models.Book, error) {
+	return load(ctx, s, s.books, bid, func(ctx context.Context) (models.Book, error) {
+		return s.Storage.GetBookByID(ctx, bid)
+	})
+}
+
+func (s *Storage) GetBooks(ctx context.Context) ([]models.Book, error) {
+	books, err := load(ctx, s, s.lists, keyAllBooks, s.Storage.GetBooks)
+	// срез общий для всех читателей кеша, наружу отдаётся копия
+	return slices.Clone(books), err
+}
+
+func (s *Storage) GetBookByUID(ctx context.Context, uid string) ([]models.Book, error) {
+	books, err := load(ctx, s, s.lists, keyOwner+uid, func(ctx context.Context) ([]models.Book, error) {
+		return s.Storage.GetBookByUID(ctx, uid)
+	})
+	return slices.Clone(books), err
+}
+
+func (s *Storage) SaveBook(ctx context.Context, book models.Book) error {
+	defer s.invalidate()
+	return s.Storage.SaveBook(ctx, book)
+}
+
+func (s *Storage) SaveBooks(ctx context.Context, books []models.Book) error {
+	defer s.invalidate()
+	return s.Storage.SaveBooks(ctx, books)
+}
+
+func (s *Storage) UpdateBook(ctx context.Context, book models.Book, editor string) (models.Book, error) {
+	defer s.invalidate(book.BID)
+	return s.Storage.UpdateBook(ctx, book, editor)
+}
+
+func (s *Storage) DeleteBook(ctx context.Context, bid string, version int64) error {
+	defer s.invalidate(bid)
+	return s.Storage.DeleteBook(ctx, bid, version)
+}
+
+func (s *Storage) DeleteBooks(ctx context.Context) error {
+	defer s.invalidateAll()
+	return s.Storage.DeleteBooks(ctx)
+}
+
+func (s *Storage) UpdateUser(ctx context.Context, uid string, user models.User) error {
+	defer s.invalidate()
+	return s.Storage.UpdateUser(ctx, uid, user)
+}
+
+// DeleteUsers отвязывает книги вычищенных пользователей, поэтому сбрасывает весь кеш.
+func (s *Storage) DeleteUsers(ctx context.Context) error {
+	defer s.invalidateAll()
+	return s.Storage.DeleteUsers(ctx)
+}
+
+// invalidate сбрасывает все списки и перечисленные книги. Вызывается и при ошибке изменения:
+// хранилище могло успеть применить его.
+func (s *Storage) invalidate(bids ...string) {
+	s.gen.Add(1)
+	for _, bid := range bids {
+		s.books.Remove(bid)
+	}
+	s.lists.Purge()
+}
+
+func (s *Storage) invalidateAll() {
+	s.gen.Add(1)
+	s.books.Purge()
+	s.lists.Purge()
+}
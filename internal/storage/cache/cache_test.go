@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+var testBook = models.Book{BID: "b1", Label: "Dune", Author: "Herbert", UserUID: "u1"}
+
+func TestGetBookByID(t *testing.T) {
+	ctx := context.Background()
+	type test struct {
+		name string
+		// between выполняется между двумя чтениями книги
+		between    func(s *Storage, m *mocks.MockStorage)
+		ttl        time.Duration
+		wantCalls  int
+		wantHits   uint64
+		wantMisses uint64
+	}
+	tests := []test{
+		{
+			name:       "Test GetBookByID() func; Case 1: second read is a hit",
+			between:    func(*Storage, *mocks.MockStorage) {},
+			wantCalls:  1,
+			wantHits:   1,
+			wantMisses: 1,
+		},
+		{
+			name: "Test GetBookByID() func; Case 2: DeleteBook invalidates",
+			between: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().DeleteBook(gomock.Any(), "b1", int64(1)).Return(nil)
+				require.NoError(t, s.DeleteBook(ctx, "b1", 1))
+			},
+			wantCalls:  2,
+			wantMisses: 2,
+		},
+		{
+			name: "Test GetBookByID() func; Case 3: failed DeleteBook still invalidates",
+			between: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().DeleteBook(gomock.Any(), "b1", int64(1)).Return(errors.New("timeout"))
+				require.Error(t, s.DeleteBook(ctx, "b1", 1))
+			},
+			wantCalls:  2,
+			wantMisses: 2,
+		},
+		{
+			name: "Test GetBookByID() func; Case 4: SaveBook keeps books by id",
+			between: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().SaveBook(gomock.Any(), gomock.Any()).Return(nil)
+				require.NoError(t, s.SaveBook(ctx, models.Book{Label: "new"}))
+			},
+			wantCalls:  1,
+			wantHits:   1,
+			wantMisses: 1,
+		},
+		{
+			name: "Test GetBookByID() func; Case 5: UpdateBook invalidates",
+			between: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().UpdateBook(gomock.Any(), gomock.Any(), "u2").Return(testBook, nil)
+				_, err := s.UpdateBook(ctx, models.Book{BID: "b1", Label: "Dune Messiah"}, "u2")
+				require.NoError(t, err)
+			},
+			wantCalls:  2,
+			wantMisses: 2,
+		},
+		{
+			name:       "Test GetBookByID() func; Case 6: entry expires",
+			between:    func(*Storage, *mocks.MockStorage) { time.Sleep(50 * time.Millisecond) },
+			ttl:        10 * time.Millisecond,
+			wantCalls:  2,
+			wantMisses: 2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockStorage(ctrl)
+			m.EXPECT().GetBookByID(gomock.Any(), "b1").Return(testBook, nil).Times(tc.wantCalls)
+			s := New(m, Options{TTL: tc.ttl})
+
+			book, err := s.GetBookByID(ctx, "b1")
+			require.NoError(t, err)
+			assert.Equal(t, testBook, book)
+			tc.between(s, m)
+			book, err = s.GetBookByID(ctx, "b1")
+			require.NoError(t, err)
+			assert.Equal(t, testBook, book)
+			assert.Equal(t, tc.wantHits, s.Hits())
+			assert.Equal(t, tc.wantMisses, s.Misses())
+		})
+	}
+}
+
+func TestPrimaryBypassesCache(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockStorage(ctrl)
+	changed := testBook
+	changed.Label = "Dune Messiah"
+	// второе изменение прошло мимо этого экземпляра кеша, например на другой реплике сервиса
+	gomock.InOrder(
+		m.EXPECT().GetBookByID(gomock.Any(), "b1").Return(testBook, nil),
+		m.EXPECT().GetBookByID(gomock.Any(), "b1").Return(changed, nil),
+	)
+	s := New(m, Options{})
+
+	book, err := s.GetBookByID(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, testBook, book)
+	book, err = s.GetBookByID(storage.WithPrimary(ctx), "b1")
+	require.NoError(t, err)
+	assert.Equal(t, changed, book)
+	assert.Equal(t, uint64(2), s.Misses())
+	// обычное чтение по-прежнему отдаётся из кеша
+	book, err = s.GetBookByID(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, testBook, book)
+	assert.Equal(t, uint64(1), s.Hits())
+}
+
+func TestGetBookByIDErrorNotCached(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockStorage(ctrl)
+	errNotFound := errors.New("not found")
+	gomock.InOrder(
+		m.EXPECT().GetBookByID(gomock.Any(), "b1").Return(models.Book{}, errNotFound),
+		m.EXPECT().GetBookByID(gomock.Any(), "b1").Return(testBook, nil),
+	)
+	s := New(m, Options{})
+	_, err := s.GetBookByID(ctx, "b1")
+	assert.ErrorIs(t, err, errNotFound)
+	book, err := s.GetBookByID(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, testBook, book)
+}
+
+func TestLists(t *testing.T) {
+	ctx := context.Background()
+	type test struct {
+		name      string
+		mutate    func(s *Storage, m *mocks.MockStorage)
+		wantCalls int
+	}
+	tests := []test{
+		{
+			name:      "Test GetBooks() func; Case 1: cached",
+			mutate:    func(*Storage, *mocks.MockStorage) {},
+			wantCalls: 1,
+		},
+		{
+			name: "Test GetBooks() func; Case 2: SaveBook invalidates",
+			mutate: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().SaveBook(gomock.Any(), gomock.Any()).Return(nil)
+				require.NoError(t, s.SaveBook(ctx, models.Book{Label: "new"}))
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "Test GetBooks() func; Case 3: UpdateUser invalidates",
+			mutate: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().UpdateUser(gomock.Any(), "u1", gomock.Any()).Return(nil)
+				require.NoError(t, s.UpdateUser(ctx, "u1", models.User{Name: "Ann"}))
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "Test GetBooks() func; Case 4: DeleteUsers invalidates",
+			mutate: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().DeleteUsers(gomock.Any()).Return(nil)
+				require.NoError(t, s.DeleteUsers(ctx))
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "Test GetBooks() func; Case 5: SaveBooks invalidates",
+			mutate: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().SaveBooks(gomock.Any(), gomock.Any()).Return(nil)
+				require.NoError(t, s.SaveBooks(ctx, []models.Book{{Label: "new"}}))
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "Test GetBooks() func; Case 6: other methods pass through",
+			mutate: func(s *Storage, m *mocks.MockStorage) {
+				m.EXPECT().GetUserByID(gomock.Any(), "u1").Return(models.User{UID: "u1"}, nil)
+				_, err := s.GetUserByID(ctx, "u1")
+				require.NoError(t, err)
+			},
+			wantCalls: 1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			m := mocks.NewMockStorage(ctrl)
+			m.EXPECT().GetBooks(gomock.Any()).DoAndReturn(func(context.Context) ([]models.Book, error) {
+				return []models.Book{testBook}, nil
+			}).Times(tc.wantCalls)
+			m.EXPECT().GetBookByUID(gomock.Any(), "u1").DoAndReturn(func(context.Context, string) ([]models.Book, error) {
+				return []models.Book{testBook}, nil
+			}).Times(tc.wantCalls)
+			s := New(m, Options{})
+
+			for range 2 {
+				books, err := s.GetBooks(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, []models.Book{testBook}, books)
+				// вызывающий не может испортить закешированный список
+				books[0].Label = "changed"
+				owned, err := s.GetBookByUID(ctx, "u1")
+				require.NoError(t, err)
+				assert.Equal(t, []models.Book{testBook}, owned)
+				owned[0].Label = "changed"
+				tc.mutate(s, m)
+			}
+		})
+	}
+}
+
+// TestSingleflight - одновременные промахи по одному ключу делают один запрос к хранилищу.
+func TestSingleflight(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockStorage(ctrl)
+	release := make(chan struct{})
+	m.EXPECT().GetBooks(gomock.Any()).DoAndReturn(func(context.Context) ([]models.Book, error) {
+		<-release
+		return []models.Book{testBook}, nil
+	}).Times(1)
+	s := New(m, Options{})
+
+	const readers = 20
+	var wg sync.WaitGroup
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			books, err := s.GetBooks(ctx)
+			assert.NoError(t, err)
+			assert.Len(t, books, 1)
+		}()
+	}
+	// даём читателям встать в очередь за первой загрузкой
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, uint64(readers), s.Hits()+s.Misses())
+	assert.Equal(t, uint64(1), s.Misses())
+}
+
+// TestInvalidateDuringLoad - список, прочитанный до изменения, не должен попасть в кеш после него.
+func TestInvalidateDuringLoad(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := mocks.NewMockStorage(ctrl)
+	loading, release := make(chan struct{}), make(chan struct{})
+	gomock.InOrder(
+		m.EXPECT().GetBooks(gomock.Any()).DoAndReturn(func(context.Context) ([]models.Book, error) {
+			close(loading)
+			<-release
+			return []models.Book{}, nil
+		}),
+		m.EXPECT().GetBooks(gomock.Any()).Return([]models.Book{testBook}, nil),
+	)
+	m.EXPECT().SaveBook(gomock.Any(), gomock.Any()).Return(nil)
+	s := New(m, Options{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := s.GetBooks(ctx)
+		assert.NoError(t, err)
+	}()
+	<-loading
+	require.NoError(t, s.SaveBook(ctx, testBook))
+	close(release)
+	<-done
+	books, err := s.GetBooks(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Book{testBook}, books)
+}
@@ -0,0 +1,64 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Rustam2595/library_service/internal/password"
+	"github.com/Rustam2595/library_service/internal/server"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/internal/storage/storagetest"
+	"github.com/Rustam2595/library_service/migrations"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHasher - bcrypt минимальной стоимости: со стоимостью по умолчанию проверки под -race идут минутами.
+var testHasher = password.NewBcrypt(bcrypt.MinCost)
+
+func TestMemStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) server.Storage {
+		return storage.New(testHasher)
+	})
+}
+
+// TestDurableMemStorageConformance - тот же MemStorage, но каждое изменение проходит через журнал.
+func TestDurableMemStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) server.Storage {
+		ms, err := storage.NewDurable(storage.DurableOptions{Dir: t.TempDir(), Sync: storage.SyncNone, Hasher: testHasher})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = ms.Close() })
+		return ms
+	})
+}
+
+// TestSQLiteConformance - каждый случай получает новую базу во временном каталоге.
+func TestSQLiteConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) server.Storage {
+		dsn := storage.SQLiteScheme + filepath.Join(t.TempDir(), "library.db")
+		require.NoError(t, storage.Migrations(context.Background(), dsn, migrations.SQLite()))
+		lite, err := storage.NewSQLite(context.Background(), dsn, testHasher)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = lite.Close() })
+		return lite
+	})
+}
+
+// TestRepositoryConformance гоняет те же проверки на PostgreSQL из TEST_DB_DSN.
+// Таблицы очищаются перед каждым случаем, поэтому нужна отдельная тестовая база.
+func TestRepositoryConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+	require.NoError(t, storage.Migrations(context.Background(), dsn, migrations.Postgres()))
+	repo, err := storage.NewRepo(context.Background(), dsn, storage.RepoOptions{Hasher: testHasher})
+	require.NoError(t, err)
+	t.Cleanup(repo.Close)
+	storagetest.Run(t, func(t *testing.T) server.Storage {
+		require.NoError(t, storage.Truncate(context.Background(), repo))
+		return repo
+	})
+}
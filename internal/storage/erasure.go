@@ -0,0 +1,9 @@
+package storage
+
+// ErasedUserName подставляется вместо имени пользователя, стёршего свои данные.
+const ErasedUserName = "deleted user"
+
+// erasedEmail возвращает уникальный несуществующий адрес для обезличенной записи.
+func erasedEmail(uid string) string {
+	return "erased-" + uid + "@erased.invalid"
+}
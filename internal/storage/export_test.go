@@ -0,0 +1,9 @@
+package storage
+
+import "context"
+
+// Truncate очищает все таблицы перед тестом.
+func Truncate(ctx context.Context, r *Repository) error {
+	_, err := r.conn.Exec(ctx, "TRUNCATE Users, Books, RecoveryCodes, ApiKeys CASCADE")
+	return err
+}
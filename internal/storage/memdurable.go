@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/password"
+)
+
+// MemoryScheme - схема DSN хранилища в памяти. memory:// без пути - данные живут до перезапуска,
+// memory:///var/lib/books - журнал и снимки пишутся в указанный каталог.
+const MemoryScheme = "memory://"
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+
+	defaultSyncInterval     = time.Second
+	defaultSnapshotInterval = 5 * time.Minute
+)
+
+// SyncPolicy определяет, когда журнал сбрасывается на диск.
+type SyncPolicy int
+
+const (
+	// SyncAlways - fsync после каждого изменения: ничего не теряется, но каждая запись ждёт диск.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval - fsync раз в SyncInterval: при сбое ОС теряются изменения за последний интервал.
+	SyncInterval
+	// SyncNone - сброс на диск оставлен ОС, переживает падение процесса, но не ОС.
+	SyncNone
+)
+
+var syncPolicies = map[string]SyncPolicy{
+	"always":   SyncAlways,
+	"interval": SyncInterval,
+	"none":     SyncNone,
+}
+
+// DurableOptions - настройки MemStorage с журналом.
+type DurableOptions struct {
+	// Dir - каталог журнала и снимка, создаётся при необходимости
+	Dir  string
+	Sync SyncPolicy
+	// SyncInterval - период fsync для SyncInterval, по умолчанию секунда
+	SyncInterval time.Duration
+	// SnapshotInterval - период снимков, после которых журнал очищается; по умолчанию 5 минут,
+	// отрицательное значение отключает периодические снимки
+	SnapshotInterval time.Duration
+	// Hasher хеширует пароли, по умолчанию password.Default()
+	Hasher password.Hasher
+}
+
+// durable - состояние хранилища с журналом: каталог и фоновые задачи.
+type durable struct {
+	dir string
+	// snapMu не даёт двум снимкам писать один файл одновременно
+	snapMu sync.Mutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// snapshot - сериализованное состояние MemStorage. Seq - номер последней вошедшей в него записи журнала.
+type snapshot struct {
+	Seq    uint64                     `json:"seq"`
+	Users  map[string]*userRecord     `json:"users"`
+	Books  map[string]models.Book     `json:"books"`
+	Codes  map[string]map[string]bool `json:"codes"`
+	Keys   map[string]*keyRecord      `json:"keys"`
+	Emails map[string]*emailRecord    `json:"emails"`
+	// Revisions - история книг, bid -> прежние версии
+	Revisions map[string][]models.BookRevision `json:"revisions"`
+}
+
+// OpenMemory открывает хранилище в памяти по DSN memory://[путь][?sync=always|interval|none&sync_interval=1s&snapshot_interval=5m].
+// Без пути возвращается обычный MemStorage, с путём - NewDurable. hasher хеширует пароли; nil - password.Default().
+func OpenMemory(dsn string, hasher password.Hasher) (*MemStorage, error) {
+	opts, err := parseMemoryDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Dir == "" {
+		zLog := logger.Named(logger.ModuleStorage)
+		zLog.Warn().Msg("using in-memory storage, data is lost on restart")
+		return New(hasher), nil
+	}
+	opts.Hasher = hasher
+	return NewDurable(opts)
+}
+
+func parseMemoryDSN(dsn string) (DurableOptions, error) {
+	var opts DurableOptions
+	rest, ok := strings.CutPrefix(dsn, MemoryScheme)
+	if !ok {
+		return opts, fmt.Errorf("memory dsn must start with %s", MemoryScheme)
+	}
+	path, rawQuery, _ := strings.Cut(rest, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return opts, fmt.Errorf("invalid memory dsn query: %w", err)
+	}
+	opts.Dir = path
+	if v := query.Get("sync"); v != "" {
+		if opts.Sync, ok = syncPolicies[v]; !ok {
+			return opts, fmt.Errorf("unknown sync policy %q, want always, interval or none", v)
+		}
+	}
+	if v := query.Get("sync_interval"); v != "" {
+		if opts.SyncInterval, err = time.ParseDuration(v); err != nil {
+			return opts, fmt.Errorf("invalid sync_interval: %w", err)
+		}
+	}
+	if v := query.Get("snapshot_interval"); v != "" {
+		if opts.SnapshotInterval, err = time.ParseDuration(v); err != nil {
+			return opts, fmt.Errorf("invalid snapshot_interval: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// NewDurable создаёт MemStorage, который пишет каждое изменение в журнал opts.Dir/wal.log
+// до того, как применить его, и периодически сохраняет снимок состояния, очищая журнал.
+// При открытии загружается последний снимок и поверх него проигрывается журнал.
+func NewDurable(opts DurableOptions) (*MemStorage, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("durable storage dir is empty")
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	if opts.SnapshotInterval == 0 {
+		opts.SnapshotInterval = defaultSnapshotInterval
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create storage dir: %w", err)
+	}
+	ms := New(opts.Hasher)
+	seq, err := ms.loadSnapshot(filepath.Join(opts.Dir, snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	w, records, err := openWAL(filepath.Join(opts.Dir, walFile), opts.Sync == SyncAlways)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+	for _, rec := range records {
+		// записи до снимка остаются, если процесс упал между снимком и очисткой журнала
+		if rec.Seq <= seq {
+			continue
+		}
+		for _, c := range rec.Changes {
+			ms.apply(c)
+		}
+	}
+	w.seq = max(w.seq, seq)
+	ms.wal = w
+	ms.durable = &durable{dir: opts.Dir, stop: make(chan struct{})}
+	if opts.Sync == SyncInterval {
+		ms.every(opts.SyncInterval, "failed to sync wal", w.sync)
+	}
+	if opts.SnapshotInterval > 0 {
+		ms.every(opts.SnapshotInterval, "failed to take snapshot", ms.Snapshot)
+	}
+	return ms, nil
+}
+
+// every запускает fn с периодом d до вызова Close.
+func (ms *MemStorage) every(d time.Duration, errMsg string, fn func() error) {
+	ms.durable.wg.Add(1)
+	go func() {
+		defer ms.durable.wg.Done()
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ms.durable.stop:
+				return
+			case <-ticker.C:
+				if err := fn(); err != nil {
+					zLog := logger.Named(logger.ModuleStorage)
+					zLog.Error().Err(err).Msg(errMsg)
+				}
+			}
+		}
+	}()
+}
+
+func (ms *MemStorage) loadSnapshot(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	var snap snapshot
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return 0, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	for uid, u := range snap.Users {
+		ms.apply(change{Op: opPutUser, ID: uid, User: u})
+	}
+	for bid, b := range snap.Books {
+		ms.apply(putBook(bid, b))
+	}
+	for uid, codes := range snap.Codes {
+		ms.apply(putCodes(uid, codes))
+	}
+	for id, k := range snap.Keys {
+		ms.apply(change{Op: opPutKey, ID: id, Key: k})
+	}
+	for uid, e := range snap.Emails {
+		ms.apply(change{Op: opPutEmail, ID: uid, Email: e})
+	}
+	for _, history := range snap.Revisions {
+		for _, r := range history {
+			ms.apply(putRevision(r))
+		}
+	}
+	return snap.Seq, nil
+}
+
+// Snapshot сохраняет текущее состояние в снимок и очищает журнал. Снимок пишется во временный
+// файл и атомарно переименовывается, поэтому при сбое остаётся предыдущий. Для хранилища
+// без журнала ничего не делает.
+func (ms *MemStorage) Snapshot() error {
+	if ms.durable == nil {
+		return nil
+	}
+	ms.durable.snapMu.Lock()
+	defer ms.durable.snapMu.Unlock()
+	// изменения идут под Lock, поэтому под RLock снимок и журнал согласованы
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	snap := snapshot{
+		Seq:       ms.wal.lastSeq(),
+		Users:     make(map[string]*userRecord, len(ms.UsersMap)),
+		Books:     ms.BooksMap,
+		Codes:     ms.CodesMap,
+		Keys:      make(map[string]*keyRecord, len(ms.KeysMap)),
+		Emails:    make(map[string]*emailRecord, len(ms.emails)),
+		Revisions: ms.revisions,
+	}
+	for uid, u := range ms.UsersMap {
+		snap.Users[uid] = newUserRecord(u)
+	}
+	for id, k := range ms.KeysMap {
+		snap.Keys[id] = newKeyRecord(k)
+	}
+	for uid, e := range ms.emails {
+		snap.Emails[uid] = &emailRecord{Email: e.email, TokenHash: e.tokenHash, Expires: e.expires}
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err = writeFileSync(filepath.Join(ms.durable.dir, snapshotFile), data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err = ms.wal.reset(); err != nil {
+		return fmt.Errorf("failed to reset wal: %w", err)
+	}
+	return nil
+}
+
+// writeFileSync атомарно заменяет файл: пишет во временный, сбрасывает на диск и переименовывает.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// переименование надёжно только после fsync каталога
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Close останавливает фоновые задачи, сохраняет финальный снимок и закрывает журнал.
+// Для хранилища без журнала ничего не делает.
+func (ms *MemStorage) Close() error {
+	if ms.durable == nil {
+		return nil
+	}
+	var err error
+	ms.durable.once.Do(func() {
+		close(ms.durable.stop)
+		ms.durable.wg.Wait()
+		err = errors.Join(ms.Snapshot(), ms.wal.close())
+	})
+	return err
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/password"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// crash останавливает хранилище так, как это сделало бы падение процесса: без снимка и без fsync.
+func crash(t *testing.T, ms *MemStorage) {
+	t.Helper()
+	close(ms.durable.stop)
+	ms.durable.wg.Wait()
+	require.NoError(t, ms.wal.f.Close())
+}
+
+func openDurable(t *testing.T, opts DurableOptions) *MemStorage {
+	t.Helper()
+	// пароли в этих тестах не проверяются, дешёвый bcrypt только ускоряет их
+	opts.Hasher = password.NewBcrypt(bcrypt.MinCost)
+	ms, err := NewDurable(opts)
+	require.NoError(t, err)
+	return ms
+}
+
+func walSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	require.NoError(t, err)
+	return info.Size()
+}
+
+func TestDurableReplay(t *testing.T) {
+	ctx := context.Background()
+	type test struct {
+		name string
+		sync SyncPolicy
+	}
+	tests := []test{
+		{name: "Test NewDurable() func; Case 1: sync always", sync: SyncAlways},
+		{name: "Test NewDurable() func; Case 2: sync interval", sync: SyncInterval},
+		{name: "Test NewDurable() func; Case 3: sync none", sync: SyncNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DurableOptions{Dir: t.TempDir(), Sync: tt.sync, SyncInterval: 10 * time.Millisecond}
+			ms := openDurable(t, opts)
+			uid, err := ms.SaveUser(ctx, models.User{Name: "Ann", Email: "ann@example.com", Pass: "qwerty1234"})
+			require.NoError(t, err)
+			require.NoError(t, ms.SetTOTPSecret(ctx, uid, "secret"))
+			require.NoError(t, ms.EnableTOTP(ctx, uid, []string{"h1", "h2"}))
+			require.NoError(t, ms.UseRecoveryCode(ctx, uid, "h1"))
+			require.NoError(t, ms.SaveBook(ctx, models.Book{Label: "Dune", Author: "Herbert", UserUID: uid}))
+			saved, err := ms.GetBookByUID(ctx, uid)
+			require.NoError(t, err)
+			_, err = ms.UpdateBook(ctx, models.Book{BID: saved[0].BID, Label: "Dune Messiah", Author: "Herbert", Version: 1}, uid)
+			require.NoError(t, err)
+			require.NoError(t, ms.SaveAPIKey(ctx, models.APIKey{ID: "k1", Hash: "hash", OwnerUID: uid, Scopes: []string{models.ScopeBooksRead}}))
+			crash(t, ms)
+
+			ms = openDurable(t, opts)
+			t.Cleanup(func() { _ = ms.Close() })
+			user, err := ms.GetUserByEmail(ctx, "ann@example.com")
+			require.NoError(t, err)
+			assert.Equal(t, uid, user.UID)
+			assert.Equal(t, "secret", user.TOTPSecret)
+			assert.True(t, user.TOTPEnabled)
+			_, err = ms.ValidateUser(ctx, models.User{Email: "ann@example.com", Pass: "qwerty1234"})
+			assert.NoError(t, err)
+			assert.ErrorIs(t, ms.UseRecoveryCode(ctx, uid, "h1"), ErrRecoveryCodeInvalid)
+			assert.NoError(t, ms.UseRecoveryCode(ctx, uid, "h2"))
+			books, err := ms.GetBookByUID(ctx, uid)
+			require.NoError(t, err)
+			require.Len(t, books, 1)
+			assert.Equal(t, "Dune Messiah", books[0].Label)
+			assert.Equal(t, int64(2), books[0].Version)
+			history, err := ms.GetBookHistory(ctx, books[0].BID)
+			require.NoError(t, err)
+			require.Len(t, history, 1)
+			assert.Equal(t, "Dune", history[0].Label)
+			assert.Equal(t, uid, history[0].EditedBy)
+			key, err := ms.GetAPIKeyByHash(ctx, "hash")
+			require.NoError(t, err)
+			assert.Equal(t, "k1", key.ID)
+		})
+	}
+}
+
+func TestDurableSnapshot(t *testing.T) {
+	ctx := context.Background()
+	opts := DurableOptions{Dir: t.TempDir(), SnapshotInterval: -1}
+	ms := openDurable(t, opts)
+	uid, err := ms.SaveUser(ctx, models.User{Name: "Ann", Email: "ann@example.com", Pass: "qwerty1234"})
+	require.NoError(t, err)
+	require.NoError(t, ms.SaveBook(ctx, models.Book{Label: "Dune", Author: "Herbert", UserUID: uid}))
+	saved, err := ms.GetBookByUID(ctx, uid)
+	require.NoError(t, err)
+	bid := saved[0].BID
+	_, err = ms.UpdateBook(ctx, models.Book{BID: bid, Label: "Dune Messiah", Author: "Herbert"}, uid)
+	require.NoError(t, err)
+	require.NoError(t, ms.Snapshot())
+	assert.Zero(t, walSize(t, opts.Dir))
+
+	// изменения после снимка попадают только в журнал
+	require.NoError(t, ms.UpdateUser(ctx, uid, models.User{Name: "Anna", Email: "anna@example.com"}))
+	require.NoError(t, ms.DeleteUser(ctx, uid, 0))
+	require.NoError(t, ms.DeleteUsers(ctx))
+	assert.NotZero(t, walSize(t, opts.Dir))
+	crash(t, ms)
+
+	ms = openDurable(t, opts)
+	_, err = ms.GetUserByID(ctx, uid)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	uid2, err := ms.SaveUser(ctx, models.User{Name: "Anna", Email: "anna@example.com", Pass: "qwerty1234"})
+	assert.NoError(t, err, "email of purged user must be free")
+	books, err := ms.GetBooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, books, 1)
+	assert.Empty(t, books[0].UserUID)
+	require.NoError(t, ms.Close())
+
+	// Close делает финальный снимок, журнал после него пуст
+	assert.Zero(t, walSize(t, opts.Dir))
+	ms = openDurable(t, opts)
+	t.Cleanup(func() { _ = ms.Close() })
+	user, err := ms.GetUserByID(ctx, uid2)
+	require.NoError(t, err)
+	assert.Equal(t, "anna@example.com", user.Email)
+	history, err := ms.GetBookHistory(ctx, bid)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "Dune", history[0].Label)
+}
+
+// TestDurableTornWrite обрывает или портит последнюю запись журнала: при открытии она
+// отбрасывается, файл обрезается до предыдущей, а новые записи ложатся следом за ней.
+func TestDurableTornWrite(t *testing.T) {
+	ctx := context.Background()
+	type test struct {
+		name string
+		// damage портит журнал; first и last - границы последней записи
+		damage func(t *testing.T, path string, first, last int64)
+	}
+	truncateAt := func(offset func(first, last int64) int64) func(t *testing.T, path string, first, last int64) {
+		return func(t *testing.T, path string, first, last int64) {
+			require.NoError(t, os.Truncate(path, offset(first, last)))
+		}
+	}
+	tests := []test{
+		{
+			name:   "Test NewDurable() func; Case 1: cut inside header",
+			damage: truncateAt(func(first, _ int64) int64 { return first + 3 }),
+		},
+		{
+			name:   "Test NewDurable() func; Case 2: cut inside payload",
+			damage: truncateAt(func(first, _ int64) int64 { return first + walHeaderSize + 5 }),
+		},
+		{
+			name:   "Test NewDurable() func; Case 3: last byte missing",
+			damage: truncateAt(func(_, last int64) int64 { return last - 1 }),
+		},
+		{
+			name: "Test NewDurable() func; Case 4: checksum mismatch",
+			damage: func(t *testing.T, path string, _, last int64) {
+				f, err := os.OpenFile(path, os.O_WRONLY, 0)
+				require.NoError(t, err)
+				_, err = f.WriteAt([]byte{'#'}, last-2)
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DurableOptions{Dir: t.TempDir(), SnapshotInterval: -1}
+			path := filepath.Join(opts.Dir, walFile)
+			ms := openDurable(t, opts)
+			require.NoError(t, ms.SaveBook(ctx, models.Book{Label: "first", Author: "a", UserUID: "u"}))
+			first := walSize(t, opts.Dir)
+			require.NoError(t, ms.SaveBook(ctx, models.Book{Label: "torn", Author: "a", UserUID: "u"}))
+			last := walSize(t, opts.Dir)
+			crash(t, ms)
+			tt.damage(t, path, first, last)
+
+			ms = openDurable(t, opts)
+			assert.Equal(t, first, walSize(t, opts.Dir))
+			books, err := ms.GetBooks(ctx)
+			require.NoError(t, err)
+			require.Len(t, books, 1)
+			assert.Equal(t, "first", books[0].Label)
+			require.NoError(t, ms.SaveBook(ctx, models.Book{Label: "after", Author: "a", UserUID: "u"}))
+			crash(t, ms)
+
+			ms = openDurable(t, opts)
+			t.Cleanup(func() { _ = ms.Close() })
+			books, err = ms.GetBooks(ctx)
+			require.NoError(t, err)
+			require.Len(t, books, 2)
+			assert.Equal(t, "first", books[0].Label)
+			assert.Equal(t, "after", books[1].Label)
+		})
+	}
+}
+
+func TestParseMemoryDSN(t *testing.T) {
+	type test struct {
+		name    string
+		dsn     string
+		want    DurableOptions
+		wantErr bool
+	}
+	tests := []test{
+		{
+			name: "Test parseMemoryDSN() func; Case 1: in-memory only",
+			dsn:  "memory://",
+			want: DurableOptions{},
+		},
+		{
+			name: "Test parseMemoryDSN() func; Case 2: dir with defaults",
+			dsn:  "memory:///var/lib/books",
+			want: DurableOptions{Dir: "/var/lib/books"},
+		},
+		{
+			name: "Test parseMemoryDSN() func; Case 3: all options",
+			dsn:  "memory://data?sync=interval&sync_interval=200ms&snapshot_interval=1m",
+			want: DurableOptions{Dir: "data", Sync: SyncInterval, SyncInterval: 200 * time.Millisecond, SnapshotInterval: time.Minute},
+		},
+		{
+			name:    "Test parseMemoryDSN() func; Case 4: unknown sync policy",
+			dsn:     "memory://data?sync=sometimes",
+			wantErr: true,
+		},
+		{
+			name:    "Test parseMemoryDSN() func; Case 5: bad interval",
+			dsn:     "memory://data?sync_interval=fast",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMemoryDSN(tt.dsn)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+)
+
+// Операции журнала MemStorage. Каждая операция задаёт итоговое значение записи, а не приращение.
+const (
+	opPutUser     = "put_user"
+	opPurgeUser   = "purge_user"
+	opPutBook     = "put_book"
+	opDeleteBook  = "delete_book"
+	opPutCodes    = "put_codes"
+	opDeleteCodes = "delete_codes"
+	opPutKey      = "put_key"
+	opDeleteKey   = "delete_key"
+	opPutEmail    = "put_email"
+	opDeleteEmail = "delete_email"
+	opPutRevision = "put_revision"
+)
+
+// change - одно изменение состояния MemStorage. Все изменения идут через commit,
+// который сначала пишет их в журнал и только потом применяет.
+type change struct {
+	Op    string          `json:"op"`
+	ID    string          `json:"id"`
+	User  *userRecord     `json:"user,omitempty"`
+	Book  *models.Book    `json:"book,omitempty"`
+	Codes map[string]bool `json:"codes,omitempty"`
+	Key   *keyRecord      `json:"key,omitempty"`
+	Email *emailRecord    `json:"email,omitempty"`
+	// Revision - запись истории книги ID, заменяет запись с тем же номером версии
+	Revision *models.BookRevision `json:"revision,omitempty"`
+}
+
+// userRecord - пользователь в журнале и снимке. В отличие от models.User сохраняет TOTP-секрет.
+type userRecord struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Pass        string `json:"pass"`
+	DeletedUser bool   `json:"deleted_user"`
+	Role        string `json:"role"`
+	TOTPSecret  string `json:"totp_secret"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	Version     int64  `json:"version"`
+}
+
+// keyRecord - API-ключ в журнале и снимке. В отличие от models.APIKey сохраняет хеш.
+type keyRecord struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash"`
+	OwnerUID  string     `json:"owner_uid"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Revoked   bool       `json:"revoked"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type emailRecord struct {
+	Email     string    `json:"email"`
+	TokenHash string    `json:"token_hash"`
+	Expires   time.Time `json:"expires"`
+}
+
+func newUserRecord(u models.User) *userRecord {
+	return &userRecord{
+		Name:        u.Name,
+		Email:       u.Email,
+		Pass:        u.Pass,
+		DeletedUser: u.DeletedUser,
+		Role:        u.Role,
+		TOTPSecret:  u.TOTPSecret,
+		TOTPEnabled: u.TOTPEnabled,
+		Version:     u.Version,
+	}
+}
+
+func (r *userRecord) model() models.User {
+	return models.User{
+		Name:        r.Name,
+		Email:       r.Email,
+		Pass:        r.Pass,
+		DeletedUser: r.DeletedUser,
+		Role:        r.Role,
+		TOTPSecret:  r.TOTPSecret,
+		TOTPEnabled: r.TOTPEnabled,
+		Version:     r.Version,
+	}
+}
+
+func newKeyRecord(k models.APIKey) *keyRecord {
+	return &keyRecord{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Hash:      k.Hash,
+		OwnerUID:  k.OwnerUID,
+		Scopes:    k.Scopes,
+		ExpiresAt: k.ExpiresAt,
+		Revoked:   k.Revoked,
+		CreatedAt: k.CreatedAt,
+	}
+}
+
+func (r *keyRecord) model() models.APIKey {
+	return models.APIKey{
+		ID:        r.ID,
+		Name:      r.Name,
+		Prefix:    r.Prefix,
+		Hash:      r.Hash,
+		OwnerUID:  r.OwnerUID,
+		Scopes:    r.Scopes,
+		ExpiresAt: r.ExpiresAt,
+		Revoked:   r.Revoked,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+func putUser(uid string, u models.User) change {
+	return change{Op: opPutUser, ID: uid, User: newUserRecord(u)}
+}
+
+func putBook(bid string, b models.Book) change {
+	b.BID = ""
+	return change{Op: opPutBook, ID: bid, Book: &b}
+}
+
+func putRevision(r models.BookRevision) change {
+	return change{Op: opPutRevision, ID: r.BID, Revision: &r}
+}
+
+func putCodes(uid string, codes map[string]bool) change {
+	return change{Op: opPutCodes, ID: uid, Codes: codes}
+}
+
+func putKey(k models.APIKey) change {
+	return change{Op: opPutKey, ID: k.ID, Key: newKeyRecord(k)}
+}
+
+func putEmail(uid string, c emailChange) change {
+	return change{Op: opPutEmail, ID: uid, Email: &emailRecord{Email: c.email, TokenHash: c.tokenHash, Expires: c.expires}}
+}
+
+// commit записывает изменения одной записью журнала (если он включён) и применяет их.
+// Вызывается под ms.mu. Если запись в журнал не удалась, состояние не меняется.
+func (ms *MemStorage) commit(changes ...change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if ms.wal != nil {
+		if err := ms.wal.append(changes); err != nil {
+			return fmt.Errorf("failed to write wal: %w", err)
+		}
+	}
+	for _, c := range changes {
+		ms.apply(c)
+	}
+	return nil
+}
+
+// apply применяет изменение к картам и индексам. Используется и при работе, и при восстановлении из журнала.
+func (ms *MemStorage) apply(c change) {
+	switch c.Op {
+	case opPutUser:
+		if old, ok := ms.UsersMap[c.ID]; ok && ms.byEmail[old.Email] == c.ID {
+			delete(ms.byEmail, old.Email)
+		}
+		ms.UsersMap[c.ID] = c.User.model()
+		ms.byEmail[c.User.Email] = c.ID
+	case opPurgeUser:
+		ms.purgeUser(c.ID)
+	case opPutBook:
+		if old, ok := ms.BooksMap[c.ID]; ok {
+			ms.unindexBook(c.ID, old.UserUID)
+		}
+		ms.BooksMap[c.ID] = *c.Book
+		ms.indexBook(c.ID, c.Book.UserUID)
+	case opDeleteBook:
+		if old, ok := ms.BooksMap[c.ID]; ok {
+			ms.unindexBook(c.ID, old.UserUID)
+			delete(ms.BooksMap, c.ID)
+		}
+		delete(ms.revisions, c.ID)
+	case opPutRevision:
+		ms.putRevision(*c.Revision)
+	case opPutCodes:
+		ms.CodesMap[c.ID] = maps.Clone(c.Codes)
+	case opDeleteCodes:
+		delete(ms.CodesMap, c.ID)
+	case opPutKey:
+		ms.KeysMap[c.ID] = c.Key.model()
+	case opDeleteKey:
+		delete(ms.KeysMap, c.ID)
+	case opPutEmail:
+		ms.emails[c.ID] = emailChange{email: c.Email.Email, tokenHash: c.Email.TokenHash, expires: c.Email.Expires}
+	case opDeleteEmail:
+		delete(ms.emails, c.ID)
+	}
+}
+
+// purgeUser окончательно удаляет пользователя с его кодами и ключами.
+// Книги остаются в каталоге без владельца, как при ON DELETE SET NULL в БД.
+func (ms *MemStorage) purgeUser(uid string) {
+	user, ok := ms.UsersMap[uid]
+	if !ok {
+		return
+	}
+	delete(ms.UsersMap, uid)
+	if ms.byEmail[user.Email] == uid {
+		delete(ms.byEmail, user.Email)
+	}
+	delete(ms.CodesMap, uid)
+	delete(ms.emails, uid)
+	for id, key := range ms.KeysMap {
+		if key.OwnerUID == uid {
+			delete(ms.KeysMap, id)
+		}
+	}
+	for bid := range ms.byOwner[uid] {
+		book := ms.BooksMap[bid]
+		book.UserUID = ""
+		ms.BooksMap[bid] = book
+	}
+	delete(ms.byOwner, uid)
+}
+
+// putRevision добавляет версию в историю книги или заменяет версию с тем же номером,
+// поэтому повторное применение записи журнала ничего не меняет.
+func (ms *MemStorage) putRevision(r models.BookRevision) {
+	history := ms.revisions[r.BID]
+	i, found := slices.BinarySearchFunc(history, r.Version, func(e models.BookRevision, v int64) int {
+		return cmp.Compare(e.Version, v)
+	})
+	if found {
+		history[i] = r
+		return
+	}
+	ms.revisions[r.BID] = slices.Insert(history, i, r)
+}
+
+func (ms *MemStorage) indexBook(bid, owner string) {
+	if owner == "" {
+		return
+	}
+	if ms.byOwner[owner] == nil {
+		ms.byOwner[owner] = make(map[string]struct{})
+	}
+	ms.byOwner[owner][bid] = struct{}{}
+}
+
+func (ms *MemStorage) unindexBook(bid, owner string) {
+	owned := ms.byOwner[owner]
+	if owned == nil {
+		return
+	}
+	delete(owned, bid)
+	if len(owned) == 0 {
+		delete(ms.byOwner, owner)
+	}
+}
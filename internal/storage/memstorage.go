@@ -1,109 +1,569 @@
 package storage
 
 import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/password"
 	"github.com/google/uuid"
 )
 
+// MemStorage - хранилище в памяти. Безопасно для параллельного использования:
+// все карты защищены mu, поэтому обращаться к ним напрямую можно только до запуска сервера.
 type MemStorage struct {
+	mu       sync.RWMutex
 	UsersMap map[string]models.User
 	BooksMap map[string]models.Book
+	CodesMap map[string]map[string]bool
+	KeysMap  map[string]models.APIKey
+	hasher   password.Hasher
+	emails   map[string]emailChange
+	// revisions - bid -> прежние версии книги по возрастанию номера
+	revisions map[string][]models.BookRevision
+	// byEmail - email -> uid, включая удалённых, но ещё не вычищенных пользователей
+	byEmail map[string]string
+	// byOwner - uid владельца -> id его книг
+	byOwner map[string]map[string]struct{}
+	// wal и durable заданы только у хранилища, открытого через NewDurable
+	wal     *wal
+	durable *durable
+}
+
+// emailChange - ожидающая подтверждения смена почты пользователя.
+type emailChange struct {
+	email     string
+	tokenHash string
+	expires   time.Time
 }
 
 // New создаёт и инициализирует MemStorage с пустыми картами пользователей и книг.
-func New() *MemStorage {
+// hasher хеширует пароли; nil - password.Default().
+func New(hasher password.Hasher) *MemStorage {
 	uMap := make(map[string]models.User)
 	bMap := make(map[string]models.Book)
+	cMap := make(map[string]map[string]bool)
+	kMap := make(map[string]models.APIKey)
 	return &MemStorage{
-		UsersMap: uMap,
-		BooksMap: bMap,
+		UsersMap:  uMap,
+		BooksMap:  bMap,
+		CodesMap:  cMap,
+		KeysMap:   kMap,
+		hasher:    hasherOr(hasher),
+		emails:    make(map[string]emailChange),
+		revisions: make(map[string][]models.BookRevision),
+		byEmail:   make(map[string]string),
+		byOwner:   make(map[string]map[string]struct{}),
+	}
+}
+
+// hasherOr возвращает h, а без него - password.Default(). Общий для всех хранилищ пакета.
+func hasherOr(h password.Hasher) password.Hasher {
+	if h == nil {
+		return password.Default()
 	}
+	return h
 }
 
-func (ms *MemStorage) SaveUser(user models.User) (string, error) {
+func (ms *MemStorage) SaveUser(_ context.Context, user models.User) (string, error) {
+	// хеширование медленное, поэтому выполняется до захвата блокировки
+	hash, err := ms.hasher.Hash(user.Pass)
+	if err != nil {
+		return "", err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.emailTaken("", user.Email) {
+		return "", ErrEmailTaken
+	}
 	uid := uuid.NewString()
-	ms.UsersMap[uid] = user
+	// как и в БД, сохраняются только имя, почта и пароль, остальное - значения по умолчанию
+	err = ms.commit(putUser(uid, models.User{
+		Name:    user.Name,
+		Email:   user.Email,
+		Pass:    hash,
+		Role:    models.RoleMember,
+		Version: 1,
+	}))
+	if err != nil {
+		return "", err
+	}
 	return uid, nil
 }
-func (ms *MemStorage) ValidateUser(user models.User) (string, string, error) {
-	for uid, value := range ms.UsersMap {
-		if value.Email == user.Email {
-			if value.Pass != user.Pass {
-				return "", "", ErrInvalidAuthData
-			}
-			return uid, value.Pass, nil
+
+// emailTaken сообщает, занят ли email пользователем, отличным от uid. Удалённые пользователи
+// тоже занимают адрес, пока их запись не вычищена: в БД на email уникальный индекс.
+func (ms *MemStorage) emailTaken(uid, email string) bool {
+	owner, ok := ms.byEmail[email]
+	return ok && owner != uid
+}
+
+// activeUser возвращает неудалённого пользователя.
+func (ms *MemStorage) activeUser(uid string) (models.User, bool) {
+	user, ok := ms.UsersMap[uid]
+	if !ok || user.DeletedUser {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// activeUserByEmail ищет неудалённого пользователя по индексу почты.
+func (ms *MemStorage) activeUserByEmail(email string) (string, models.User, bool) {
+	uid, ok := ms.byEmail[email]
+	if !ok {
+		return "", models.User{}, false
+	}
+	user, ok := ms.activeUser(uid)
+	return uid, user, ok
+}
+
+func (ms *MemStorage) ValidateUser(_ context.Context, user models.User) (string, error) {
+	ms.mu.RLock()
+	uid, value, ok := ms.activeUserByEmail(user.Email)
+	ms.mu.RUnlock()
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	ok, needsRehash, err := ms.hasher.Verify(value.Pass, user.Pass)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrInvalidAuthData
+	}
+	if needsRehash {
+		hash, err := ms.hasher.Hash(user.Pass)
+		if err != nil {
+			return "", err
+		}
+		ms.mu.Lock()
+		// пароль могли сменить, пока шла проверка: тогда старый хеш не перезаписываем
+		if stored, ok := ms.activeUser(uid); ok && stored.Pass == value.Pass {
+			stored.Pass = hash
+			err = ms.commit(putUser(uid, stored))
 		}
+		ms.mu.Unlock()
+		if err != nil {
+			return "", err
+		}
+	}
+	return uid, nil
+}
+
+func (ms *MemStorage) GetUserByID(_ context.Context, uid string) (models.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	user, ok := ms.activeUser(uid)
+	if !ok {
+		return models.User{}, ErrUserNotFound
+	}
+	user.UID = uid
+	return user, nil
+}
+
+func (ms *MemStorage) GetUserByEmail(_ context.Context, email string) (models.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	uid, user, ok := ms.activeUserByEmail(email)
+	if !ok {
+		return models.User{}, ErrUserNotFound
 	}
-	return "", "", ErrUserNotFound
+	user.UID = uid
+	return user, nil
 }
-func (ms *MemStorage) GetUsers() ([]models.User, error) {
-	var users []models.User
+
+func (ms *MemStorage) SetTOTPSecret(_ context.Context, uid, secret string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, ok := ms.activeUser(uid)
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.TOTPSecret = secret
+	user.TOTPEnabled = false
+	user.Version++
+	return ms.commit(putUser(uid, user))
+}
+
+func (ms *MemStorage) EnableTOTP(_ context.Context, uid string, codeHashes []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, ok := ms.activeUser(uid)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.TOTPSecret == "" {
+		return ErrTOTPNotEnrolled
+	}
+	user.TOTPEnabled = true
+	user.Version++
+	codes := make(map[string]bool, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	return ms.commit(putUser(uid, user), putCodes(uid, codes))
+}
+
+func (ms *MemStorage) UseRecoveryCode(_ context.Context, uid, codeHash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	used, ok := ms.CodesMap[uid][codeHash]
+	if !ok || used {
+		return ErrRecoveryCodeInvalid
+	}
+	codes := maps.Clone(ms.CodesMap[uid])
+	codes[codeHash] = true
+	return ms.commit(putCodes(uid, codes))
+}
+
+func (ms *MemStorage) GetUsers(_ context.Context) ([]models.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	users := make([]models.User, 0, len(ms.UsersMap))
 	for uid, e := range ms.UsersMap {
+		if e.DeletedUser {
+			continue
+		}
 		e.UID = uid
 		users = append(users, e)
 	}
-	if len(users) == 0 {
-		return nil, ErrUserListEmpty
-	}
+	slices.SortFunc(users, func(a, b models.User) int {
+		return strings.Compare(a.Email, b.Email)
+	})
 	return users, nil
 }
-func (ms *MemStorage) UpdateUser(uid string, user models.User) error {
-	if _, ok := ms.UsersMap[uid]; !ok {
+func (ms *MemStorage) UpdateUser(_ context.Context, uid string, user models.User) error {
+	var hash string
+	if user.Pass != "" {
+		var err error
+		if hash, err = ms.hasher.Hash(user.Pass); err != nil {
+			return err
+		}
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, ok := ms.activeUser(uid)
+	if !ok {
 		return ErrUserNotFound
 	}
-	ms.UsersMap[uid] = user
-	return nil
+	if user.Version != 0 && user.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	if ms.emailTaken(uid, user.Email) {
+		return ErrEmailTaken
+	}
+	if hash != "" {
+		stored.Pass = hash
+	}
+	stored.Name = user.Name
+	stored.Email = user.Email
+	stored.Version++
+	return ms.commit(putUser(uid, stored))
 }
-func (ms *MemStorage) DeleteUser(uid string) error {
-	if _, ok := ms.UsersMap[uid]; !ok {
+
+func (ms *MemStorage) SetPendingEmail(_ context.Context, uid, email, tokenHash string, expires time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.activeUser(uid); !ok {
 		return ErrUserNotFound
 	}
-	delete(ms.UsersMap, uid)
-	return nil
+	return ms.commit(putEmail(uid, emailChange{email: email, tokenHash: tokenHash, expires: expires}))
 }
 
-func (ms *MemStorage) DeleteUsers() error {
-	return nil
+func (ms *MemStorage) ConfirmEmail(_ context.Context, uid, tokenHash string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	pending, ok := ms.emails[uid]
+	if !ok || pending.tokenHash != tokenHash || time.Now().After(pending.expires) {
+		return "", ErrEmailTokenInvalid
+	}
+	user, ok := ms.activeUser(uid)
+	if !ok {
+		return "", ErrEmailTokenInvalid
+	}
+	if ms.emailTaken(uid, pending.email) {
+		return "", ErrEmailTaken
+	}
+	user.Email = pending.email
+	user.Version++
+	if err := ms.commit(putUser(uid, user), change{Op: opDeleteEmail, ID: uid}); err != nil {
+		return "", err
+	}
+	return pending.email, nil
+}
+func (ms *MemStorage) DeleteUser(_ context.Context, uid string, version int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, ok := ms.activeUser(uid)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if version != 0 && version != user.Version {
+		return ErrVersionMismatch
+	}
+	user.DeletedUser = true
+	user.Version++
+	return ms.commit(putUser(uid, user))
 }
 
-func (ms *MemStorage) GetBooks() ([]models.Book, error) {
-	var books []models.Book
+func (ms *MemStorage) EraseUser(_ context.Context, uid string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	user, ok := ms.activeUser(uid)
+	if !ok {
+		return ErrUserNotFound
+	}
+	changes := []change{
+		putUser(uid, models.User{
+			Name:        ErasedUserName,
+			Email:       erasedEmail(uid),
+			Role:        models.RoleMember,
+			DeletedUser: true,
+			Version:     user.Version + 1,
+		}),
+		{Op: opDeleteCodes, ID: uid},
+		{Op: opDeleteEmail, ID: uid},
+	}
+	for id, key := range ms.KeysMap {
+		if key.OwnerUID == uid {
+			changes = append(changes, change{Op: opDeleteKey, ID: id})
+		}
+	}
+	return ms.commit(changes...)
+}
+
+// DeleteUsers вычищает помеченных удалёнными пользователей вместе с их кодами и ключами.
+func (ms *MemStorage) DeleteUsers(_ context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var changes []change
+	for uid, user := range ms.UsersMap {
+		if user.DeletedUser {
+			changes = append(changes, change{Op: opPurgeUser, ID: uid})
+		}
+	}
+	return ms.commit(changes...)
+}
+
+func (ms *MemStorage) GetBooks(_ context.Context) ([]models.Book, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	books := make([]models.Book, 0, len(ms.BooksMap))
 	for bid, e := range ms.BooksMap {
+		if e.Deleted {
+			continue
+		}
 		e.BID = bid
 		books = append(books, e)
 	}
-	if len(books) == 0 {
-		return nil, ErrBooksListEmpty
-	}
+	sortBooks(books)
 	return books, nil
 }
 
-func (ms *MemStorage) GetBookByID(bid string) (models.Book, error) {
-	if book, ok := ms.BooksMap[bid]; ok {
-		book.BID = bid
-		return book, nil
+// ExportBooks передаёт fn книги в порядке GetBooks. Каталог и так в памяти, поэтому
+// выгрузка идёт по его копии и не держит блокировку, пока fn пишет книги клиенту.
+func (ms *MemStorage) ExportBooks(ctx context.Context, fn func(models.Book) error) error {
+	books, err := ms.GetBooks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, book := range books {
+		if err := fn(book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *MemStorage) GetBookByID(_ context.Context, bid string) (models.Book, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	book, ok := ms.BooksMap[bid]
+	if !ok {
+		return models.Book{}, ErrBookNotFound
+	}
+	if book.Deleted {
+		return models.Book{}, ErrBookWasDeleted
+	}
+	book.BID = bid
+	return book, nil
+}
+
+func (ms *MemStorage) GetBookByUID(_ context.Context, uid string) ([]models.Book, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	books := make([]models.Book, 0, len(ms.byOwner[uid]))
+	for bid := range ms.byOwner[uid] {
+		e := ms.BooksMap[bid]
+		if e.Deleted {
+			continue
+		}
+		e.BID = bid
+		books = append(books, e)
 	}
-	return models.Book{}, ErrBookNotFound
+	sortBooks(books)
+	return books, nil
 }
 
-func (ms *MemStorage) GetBookByUID(_ string) ([]models.Book, error) {
-	return []models.Book{}, nil
+// sortBooks упорядочивает книги так же, как ORDER BY created_at, bid в БД.
+func sortBooks(books []models.Book) {
+	slices.SortFunc(books, func(a, b models.Book) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.BID, b.BID)
+	})
 }
 
-func (ms *MemStorage) SaveBook(book models.Book) error {
+func (ms *MemStorage) SaveBook(_ context.Context, book models.Book) error {
 	nid := uuid.NewString()
-	ms.BooksMap[nid] = book
-	return nil
+	book.BID = ""
+	book.CreatedAt = time.Now()
+	book.Version = 1
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.commit(putBook(nid, book))
+}
+
+// SaveBooks сохраняет книги одной записью журнала: после сбоя восстанавливаются либо все, либо ни одной.
+func (ms *MemStorage) SaveBooks(_ context.Context, books []models.Book) error {
+	now := time.Now()
+	changes := make([]change, len(books))
+	for i, book := range books {
+		book.BID = ""
+		book.CreatedAt = now.Add(time.Duration(i) * time.Microsecond)
+		book.Version = 1
+		changes[i] = putBook(uuid.NewString(), book)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.commit(changes...)
 }
 
-func (ms *MemStorage) DeleteBook(bid string) error {
-	if _, ok := ms.BooksMap[bid]; !ok {
+func (ms *MemStorage) UpdateBook(_ context.Context, book models.Book, editor string) (models.Book, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, ok := ms.BooksMap[book.BID]
+	if !ok || stored.Deleted {
+		return models.Book{}, ErrBookNotFound
+	}
+	if book.Version != 0 && book.Version != stored.Version {
+		return models.Book{}, ErrVersionMismatch
+	}
+	revision := models.BookRevision{
+		BID:      book.BID,
+		Version:  stored.Version,
+		Label:    stored.Label,
+		Author:   stored.Author,
+		EditedBy: editor,
+		EditedAt: time.Now(),
+	}
+	stored.Label = book.Label
+	stored.Author = book.Author
+	stored.Version++
+	if err := ms.commit(putBook(book.BID, stored), putRevision(revision)); err != nil {
+		return models.Book{}, err
+	}
+	stored.BID = book.BID
+	return stored, nil
+}
+
+func (ms *MemStorage) GetBookHistory(_ context.Context, bid string) ([]models.BookRevision, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	book, ok := ms.BooksMap[bid]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+	if book.Deleted {
+		return nil, ErrBookWasDeleted
+	}
+	revisions := make([]models.BookRevision, len(ms.revisions[bid]))
+	copy(revisions, ms.revisions[bid])
+	return revisions, nil
+}
+
+func (ms *MemStorage) DeleteBook(_ context.Context, bid string, version int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	book, ok := ms.BooksMap[bid]
+	if !ok || book.Deleted {
 		return ErrBookNotFound
 	}
-	delete(ms.BooksMap, bid)
-	return nil
+	if version != 0 && version != book.Version {
+		return ErrVersionMismatch
+	}
+	book.Deleted = true
+	book.Version++
+	return ms.commit(putBook(bid, book))
 }
 
-func (ms *MemStorage) DeleteBooks() error {
-	return nil
+// DeleteBooks вычищает помеченные удалёнными книги.
+func (ms *MemStorage) DeleteBooks(_ context.Context) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var changes []change
+	for bid, book := range ms.BooksMap {
+		if book.Deleted {
+			changes = append(changes, change{Op: opDeleteBook, ID: bid})
+		}
+	}
+	return ms.commit(changes...)
+}
+
+func (ms *MemStorage) SaveAPIKey(_ context.Context, key models.APIKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.commit(putKey(key))
+}
+
+func (ms *MemStorage) GetAPIKeyByID(_ context.Context, id string) (models.APIKey, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	key, ok := ms.KeysMap[id]
+	if !ok {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func (ms *MemStorage) GetAPIKeyByHash(_ context.Context, hash string) (models.APIKey, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, key := range ms.KeysMap {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return models.APIKey{}, ErrAPIKeyNotFound
+}
+
+func (ms *MemStorage) GetAPIKeysByOwner(_ context.Context, uid string) ([]models.APIKey, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	keys := make([]models.APIKey, 0)
+	for _, key := range ms.KeysMap {
+		if key.OwnerUID == uid {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b models.APIKey) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return keys, nil
+}
+
+func (ms *MemStorage) RevokeAPIKey(_ context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	key, ok := ms.KeysMap[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.Revoked = true
+	return ms.commit(putKey(key))
 }
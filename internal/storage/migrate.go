@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
+)
+
+// migrationLockID - ключ advisory-блокировки, которую держит процесс, меняющий схему.
+// Отличается от ключа, который берёт сам golang-migrate на каждую операцию: наша блокировка
+// охватывает всю команду целиком, включая force.
+const migrationLockID int64 = 0x626f6f6b73 // "books"
+
+// ErrSchemaOutdated - схема БД отстаёт от миграций, встроенных в бинарник, или осталась в грязном состоянии.
+var ErrSchemaOutdated = errors.New("database schema is not up to date, run `books migrate up`")
+
+// MigrationStatus - версия схемы в БД и последняя из встроенных миграций.
+type MigrationStatus struct {
+	Version uint
+	Latest  uint
+	// Dirty - миграция Version упала на середине, нужен migrate force
+	Dirty bool
+}
+
+// Migrator применяет миграции из fs.FS к базе по DSN (postgres:// или sqlite://).
+type Migrator struct {
+	m      *migrate.Migrate
+	src    source.Driver
+	dsn    string
+	latest uint
+}
+
+// NewMigrator открывает базу и источник миграций. Закрывать через Close.
+func NewMigrator(dsn string, migrations fs.FS) (*Migrator, error) {
+	src, err := iofs.New(migrations, ".")
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+	latest, err := lastVersion(src)
+	if err != nil {
+		return nil, err
+	}
+	// golang-migrate закрывает src вместе с экземпляром, поэтому для него открываем отдельный
+	instanceSrc, err := iofs.New(migrations, ".")
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+	m, err := migrate.NewWithSourceInstance("iofs", instanceSrc, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not create migrate instance: %w", err)
+	}
+	return &Migrator{m: m, src: src, dsn: dsn, latest: latest}, nil
+}
+
+func lastVersion(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not read migrations: %w", err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("could not read migrations: %w", err)
+		}
+		version = next
+	}
+}
+
+// Status возвращает текущую версию схемы.
+func (mg *Migrator) Status() (MigrationStatus, error) {
+	version, dirty, err := mg.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, err
+	}
+	return MigrationStatus{Version: version, Latest: mg.latest, Dirty: dirty}, nil
+}
+
+// Up применяет все новые миграции.
+func (mg *Migrator) Up(ctx context.Context) error {
+	return mg.locked(ctx, func() error {
+		if err := mg.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("could not run migrations: %w", err)
+		}
+		return nil
+	})
+}
+
+// Down откатывает n последних миграций.
+func (mg *Migrator) Down(ctx context.Context, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("number of migrations to roll back must be positive, got %d", n)
+	}
+	return mg.locked(ctx, func() error {
+		if err := mg.m.Steps(-n); err != nil {
+			return fmt.Errorf("could not roll back migrations: %w", err)
+		}
+		return nil
+	})
+}
+
+// Force записывает версию схемы без выполнения миграций и снимает признак dirty.
+// Нужен после ручного исправления упавшей миграции.
+func (mg *Migrator) Force(ctx context.Context, version int) error {
+	return mg.locked(ctx, func() error {
+		if err := mg.m.Force(version); err != nil {
+			return fmt.Errorf("could not force version: %w", err)
+		}
+		return nil
+	})
+}
+
+// Close закрывает соединение с базой и источник миграций.
+func (mg *Migrator) Close() error {
+	srcErr, dbErr := mg.m.Close()
+	return errors.Join(srcErr, dbErr, mg.src.Close())
+}
+
+// locked выполняет fn под advisory-блокировкой PostgreSQL, чтобы одновременно запущенные
+// экземпляры не меняли схему параллельно. SQLite блокирует файл базы сама.
+func (mg *Migrator) locked(ctx context.Context, fn func() error) error {
+	if strings.HasPrefix(mg.dsn, SQLiteScheme) {
+		return fn()
+	}
+	zLog := logger.Named(logger.ModuleStorage)
+	conn, err := pgx.Connect(ctx, mg.dsn)
+	if err != nil {
+		return fmt.Errorf("could not connect for migration lock: %w", err)
+	}
+	defer conn.Close(context.Background())
+	zLog.Debug().Msg("waiting for migration lock")
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("could not take migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			zLog.Error().Err(err).Msg("failed to release migration lock")
+		}
+	}()
+	return fn()
+}
+
+// Migrations применяет все новые миграции и закрывает Migrator.
+func Migrations(ctx context.Context, dsn string, migrations fs.FS) error {
+	mg, err := NewMigrator(dsn, migrations)
+	if err != nil {
+		return err
+	}
+	defer mg.Close()
+	return mg.Up(ctx)
+}
+
+// CheckMigrations проверяет при старте, что схема не грязная и не отстаёт от встроенных миграций.
+// Схема новее бинарника допустима: так бывает при откате версии сервиса после миграции.
+func CheckMigrations(dsn string, migrations fs.FS) (MigrationStatus, error) {
+	mg, err := NewMigrator(dsn, migrations)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	defer mg.Close()
+	status, err := mg.Status()
+	if err != nil {
+		return status, err
+	}
+	if status.Dirty || status.Version < status.Latest {
+		return status, fmt.Errorf("%w: version %d (dirty %t), latest %d",
+			ErrSchemaOutdated, status.Version, status.Dirty, status.Latest)
+	}
+	return status, nil
+}
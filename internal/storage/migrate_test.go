@@ -0,0 +1,80 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/Rustam2595/library_service/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	dsn := storage.SQLiteScheme + filepath.Join(t.TempDir(), "library.db")
+	mg, err := storage.NewMigrator(dsn, migrations.SQLite())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = mg.Close() })
+
+	status, err := mg.Status()
+	require.NoError(t, err)
+	assert.Equal(t, storage.MigrationStatus{Version: 0, Latest: 3}, status)
+	_, err = storage.CheckMigrations(dsn, migrations.SQLite())
+	assert.ErrorIs(t, err, storage.ErrSchemaOutdated)
+
+	require.NoError(t, mg.Up(ctx))
+	require.NoError(t, mg.Up(ctx), "up without new migrations is not an error")
+	status, err = mg.Status()
+	require.NoError(t, err)
+	assert.Equal(t, storage.MigrationStatus{Version: 3, Latest: 3}, status)
+	_, err = storage.CheckMigrations(dsn, migrations.SQLite())
+	assert.NoError(t, err)
+
+	require.NoError(t, mg.Force(ctx, 3))
+	assert.Error(t, mg.Down(ctx, 0))
+	require.NoError(t, mg.Down(ctx, 1))
+	status, err = mg.Status()
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), status.Version)
+}
+
+// TestMigratorDirty - упавшая миграция оставляет схему грязной: сервер не стартует,
+// пока её не исправят и не выставят версию через force.
+func TestMigratorDirty(t *testing.T) {
+	ctx := context.Background()
+	dsn := storage.SQLiteScheme + filepath.Join(t.TempDir(), "library.db")
+	src := fstest.MapFS{
+		"01_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"01_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"02_b.up.sql":   {Data: []byte("CREATE TABLE b (;")},
+		"02_b.down.sql": {Data: []byte("DROP TABLE b;")},
+	}
+	mg, err := storage.NewMigrator(dsn, src)
+	require.NoError(t, err)
+	assert.Error(t, mg.Up(ctx))
+	status, err := mg.Status()
+	require.NoError(t, err)
+	assert.Equal(t, storage.MigrationStatus{Version: 2, Latest: 2, Dirty: true}, status)
+	_, err = storage.CheckMigrations(dsn, src)
+	assert.ErrorIs(t, err, storage.ErrSchemaOutdated)
+
+	require.NoError(t, mg.Force(ctx, 1))
+	require.NoError(t, mg.Close())
+	src["02_b.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE b (id INTEGER);")}
+	require.NoError(t, storage.Migrations(ctx, dsn, src))
+	status, err = storage.CheckMigrations(dsn, src)
+	require.NoError(t, err)
+	assert.Equal(t, storage.MigrationStatus{Version: 2, Latest: 2}, status)
+}
+
+func TestPostgresMigrationsEmbedded(t *testing.T) {
+	mg, err := storage.NewMigrator(storage.SQLiteScheme+filepath.Join(t.TempDir(), "unused.db"), migrations.Postgres())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = mg.Close() })
+	status, err := mg.Status()
+	require.NoError(t, err)
+	assert.Equal(t, uint(7), status.Latest, "all postgres migrations are embedded, sqlite/ is not mixed in")
+}
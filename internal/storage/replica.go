@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// replicaCheckInterval - период проверки доступности и отставания реплик.
+const replicaCheckInterval = 5 * time.Second
+
+// DefaultReplicaMaxLag - отставание, после которого реплика считается неисправной.
+const DefaultReplicaMaxLag = 10 * time.Second
+
+// на primary pg_last_xact_replay_timestamp() равен NULL, отставание считается нулевым
+const replicaLagQuery = `SELECT CASE WHEN pg_is_in_recovery()
+	THEN COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	ELSE 0 END`
+
+// querier - общая часть пулов primary и реплик, нужная читающим методам.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// replica - пул соединений с репликой и результат её последней проверки.
+type replica struct {
+	name    string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+	// lag - отставание в наносекундах
+	lag atomic.Int64
+}
+
+// ReplicaStatus - состояние реплики для метрик.
+type ReplicaStatus struct {
+	Name    string
+	Healthy bool
+	Lag     time.Duration
+}
+
+type primaryKey struct{}
+
+// WithPrimary помечает контекст: читающие методы Repository пойдут в primary, а не в реплику,
+// а кеш пропустит свои записи. Нужен там, где читают только что записанное.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// PrimaryRequested сообщает, помечен ли контекст WithPrimary.
+func PrimaryRequested(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+func newReplica(ctx context.Context, dsn string, opts RepoOptions) (*replica, error) {
+	cfg, err := poolConfig(dsn, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replica dsn: %w", err)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &replica{
+		name: fmt.Sprintf("%s:%d", cfg.ConnConfig.Host, cfg.ConnConfig.Port),
+		pool: pool,
+	}, nil
+}
+
+// pickReplica по кругу выбирает исправную реплику. nil - читать из primary.
+func (r *Repository) pickReplica(ctx context.Context) *replica {
+	if len(r.replicas) == 0 || PrimaryRequested(ctx) {
+		return nil
+	}
+	start := r.next.Add(1)
+	for i := range r.replicas {
+		rep := r.replicas[(int(start)+i)%len(r.replicas)]
+		if rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// read выполняет чтение на реплике, а если до неё не достучаться - помечает её неисправной
+// и повторяет чтение на primary.
+func (r *Repository) read(ctx context.Context, fn func(q querier) error) error {
+	rep := r.pickReplica(ctx)
+	if rep == nil {
+		return fn(r.conn)
+	}
+	err := fn(rep.pool)
+	if err == nil || ctx.Err() != nil || !isConnError(err) {
+		return err
+	}
+	rep.healthy.Store(false)
+	zLog := logger.Named(logger.ModuleStorage)
+	zLog.Warn().Err(err).Str("replica", rep.name).Msg("replica read failed, falling back to primary")
+	return fn(r.conn)
+}
+
+// isConnError отличает недоступность сервера от ошибок самого запроса.
+func isConnError(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) || pgconn.SafeToRetry(err)
+}
+
+// checkReplicas обновляет состояние реплик: недоступная или отстающая больше maxLag выводится из чтения.
+func (r *Repository) checkReplicas(ctx context.Context) {
+	zLog := logger.Named(logger.ModuleStorage)
+	for _, rep := range r.replicas {
+		lag, err := rep.checkLag(ctx, r.readTimeout)
+		healthy := err == nil && lag <= r.maxLag
+		if healthy != rep.healthy.Swap(healthy) {
+			zLog.Warn().Err(err).Str("replica", rep.name).Dur("lag", lag).Bool("healthy", healthy).
+				Msg("replica health changed")
+		}
+	}
+}
+
+func (rep *replica) checkLag(ctx context.Context, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var seconds float64
+	if err := rep.pool.QueryRow(ctx, replicaLagQuery).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	lag := time.Duration(seconds * float64(time.Second))
+	rep.lag.Store(int64(lag))
+	return lag, nil
+}
+
+// monitorReplicas проверяет реплики до вызова Close.
+func (r *Repository) monitorReplicas() {
+	defer close(r.monitorDone)
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.checkReplicas(context.Background())
+		}
+	}
+}
+
+// Replicas возвращает состояние реплик на момент последней проверки.
+func (r *Repository) Replicas() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		statuses = append(statuses, ReplicaStatus{
+			Name:    rep.name,
+			Healthy: rep.healthy.Load(),
+			Lag:     time.Duration(rep.lag.Load()),
+		})
+	}
+	return statuses
+}
@@ -1,101 +1,407 @@
 package storage
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/Rustam2595/library_service/internal/domain/models"
 	"github.com/Rustam2595/library_service/internal/logger"
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/Rustam2595/library_service/internal/password"
+	"github.com/exaring/otelpgx"
 	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/lib/pq"
 )
 
+// ctxTimeout - таймаут операций SQLite и умолчание для операций Repository.
 const ctxTimeout = 2 * time.Second
 
+// DefaultExportTimeout - умолчание RepoOptions.ExportTimeout.
+const DefaultExportTimeout = 10 * time.Minute
+
+const userColumns = "uid, name, email, pass, deleted_user, role, totp_secret, totp_enabled, version"
+
+// user_uid может быть NULL у книг, чей автор стёр аккаунт
+const bookColumns = "bid, label, author, deleted, COALESCE(user_uid, '') AS user_uid, created_at, version"
+
+const revisionColumns = "bid, version, label, author, edited_by, edited_at"
+
+const apiKeyColumns = "id, name, prefix, key_hash, owner_uid, scopes, expires_at, revoked, created_at"
+
 type Repository struct {
-	conn *pgxpool.Pool
+	conn   *pgxpool.Pool
+	hasher password.Hasher
+	// readTimeout и writeTimeout ограничивают читающие и изменяющие методы
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// exportTimeout ограничивает всю транзакцию ExportBooks
+	exportTimeout time.Duration
+	// replicas обслуживают GetBooks, GetBookByID, GetBookByUID и GetUsers
+	replicas    []*replica
+	next        atomic.Uint32
+	maxLag      time.Duration
+	stop        chan struct{}
+	monitorDone chan struct{}
+}
+
+// RepoOptions - необязательные настройки Repository. Нулевые значения - умолчания pgxpool и пакета.
+type RepoOptions struct {
+	// MaxConns и MaxConnLifetime - размер пула и время жизни соединения, для primary и каждой реплики
+	MaxConns        int32
+	MaxConnLifetime time.Duration
+	// StatementTimeout - statement_timeout сессии: сервер сам прерывает слишком долгие запросы
+	StatementTimeout time.Duration
+	// ReadTimeout и WriteTimeout - дедлайны читающих и изменяющих методов, по умолчанию 2 секунды
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// ExportTimeout - дедлайн всей выгрузки каталога, по умолчанию DefaultExportTimeout
+	ExportTimeout time.Duration
+	// SlowQuery - запросы дольше этого порога пишутся в лог; 0 и меньше - не логировать
+	SlowQuery time.Duration
+	// ReplicaDSNs - реплики для чтения; пока реплика недоступна, чтения идут в primary
+	ReplicaDSNs []string
+	// ReplicaMaxLag - допустимое отставание реплики, по умолчанию DefaultReplicaMaxLag
+	ReplicaMaxLag time.Duration
+	// Hasher хеширует пароли, по умолчанию password.Default()
+	Hasher password.Hasher
+}
+
+func NewRepo(ctx context.Context, dbAddr string, opts RepoOptions) (*Repository, error) {
+	cfg, err := poolConfig(dbAddr, opts)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	repo := &Repository{
+		conn:          conn,
+		hasher:        hasherOr(opts.Hasher),
+		readTimeout:   cmp.Or(opts.ReadTimeout, ctxTimeout),
+		writeTimeout:  cmp.Or(opts.WriteTimeout, ctxTimeout),
+		exportTimeout: cmp.Or(opts.ExportTimeout, DefaultExportTimeout),
+		maxLag:        cmp.Or(opts.ReplicaMaxLag, DefaultReplicaMaxLag),
+		stop:          make(chan struct{}),
+		monitorDone:   make(chan struct{}),
+	}
+	for _, dsn := range opts.ReplicaDSNs {
+		rep, err := newReplica(ctx, dsn, opts)
+		if err != nil {
+			close(repo.monitorDone)
+			repo.Close()
+			return nil, err
+		}
+		repo.replicas = append(repo.replicas, rep)
+	}
+	if len(repo.replicas) == 0 {
+		close(repo.monitorDone)
+		return repo, nil
+	}
+	// реплика, недоступная при старте, не мешает запуску: чтения пойдут в primary
+	repo.checkReplicas(ctx)
+	go repo.monitorReplicas()
+	return repo, nil
 }
 
-func NewRepo(ctx context.Context, dbAddr string) (*Repository, error) {
-	conn, err := pgxpool.New(ctx, dbAddr)
+// poolConfig разбирает DSN и применяет к нему настройки пула и трассировки.
+func poolConfig(dsn string, opts RepoOptions) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
-	return &Repository{
-		conn: conn,
-	}, nil
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = opts.MaxConns
+	}
+	if opts.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.StatementTimeout > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+	// каждый запрос к БД становится дочерним спаном трассы HTTP-запроса
+	var tracer pgx.QueryTracer = otelpgx.NewTracer()
+	if opts.SlowQuery > 0 {
+		tracer = multitracer.New(tracer, newSlowQueryTracer(opts.SlowQuery))
+	}
+	cfg.ConnConfig.Tracer = tracer
+	return cfg, nil
 }
 
-func (r *Repository) SaveUser(user models.User) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+// Close останавливает проверку реплик и закрывает все пулы соединений.
+func (r *Repository) Close() {
+	select {
+	case <-r.stop:
+		return
+	default:
+		close(r.stop)
+	}
+	<-r.monitorDone
+	for _, rep := range r.replicas {
+		rep.pool.Close()
+	}
+	r.conn.Close()
+}
+
+// Stat возвращает статистику пула соединений.
+func (r *Repository) Stat() *pgxpool.Stat {
+	return r.conn.Stat()
+}
+
+// Ping проверяет доступность базы данных.
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.conn.Ping(ctx)
+}
+
+func (r *Repository) SaveUser(ctx context.Context, user models.User) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
 	defer cancel()
+	hash, err := r.hasher.Hash(user.Pass)
+	if err != nil {
+		return "", err
+	}
 	UID := uuid.NewString()
-	_, err := r.conn.Exec(ctx, "INSERT INTO Users(uid, name, email, pass) VALUES($1, $2, $3, $4)",
-		UID, user.Name, user.Email, user.Pass)
+	_, err = r.conn.Exec(ctx, "INSERT INTO Users(uid, name, email, pass) VALUES($1, $2, $3, $4)",
+		UID, user.Name, user.Email, hash)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return "", ErrEmailTaken
+		}
 		return "", err
 	}
 	return UID, nil
 }
 
-func (r *Repository) ValidateUser(user models.User) (string, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) ValidateUser(ctx context.Context, user models.User) (string, error) {
+	zLog := logger.Named(logger.ModuleStorage)
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
 	defer cancel()
-	row := r.conn.QueryRow(ctx, "SELECT uid, pass FROM Users WHERE email = $1 AND uid = $2 AND deleted_user = false",
-		user.Email, user.UID)
+	row := r.conn.QueryRow(ctx, "SELECT uid, pass FROM Users WHERE email = $1 AND deleted_user = false",
+		user.Email)
 	var pass, uid string
 	if err := row.Scan(&uid, &pass); err != nil {
-		return "", "", ErrUserNotFound
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	ok, needsRehash, err := r.hasher.Verify(pass, user.Pass)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrInvalidAuthData
 	}
-	if pass != user.Pass {
-		return "", "", ErrInvalidAuthData
+	if needsRehash {
+		hash, err := r.hasher.Hash(user.Pass)
+		if err != nil {
+			return "", err
+		}
+		if _, err = r.conn.Exec(ctx, "UPDATE Users SET pass = $1 WHERE uid = $2", hash, uid); err != nil {
+			zLog.Error().Err(err).Str("uid", uid).Msg("password rehash failed")
+		}
 	}
-	return uid, pass, nil
+	return uid, nil
 }
 
-func (r *Repository) GetUsers() ([]models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) GetUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
 	defer cancel()
-	rows, err := r.conn.Query(ctx, "SELECT * FROM Users WHERE deleted_user = false")
+	var users []models.User
+	err := r.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, "SELECT "+userColumns+" FROM Users WHERE deleted_user = false ORDER BY email")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		users = make([]models.User, 0)
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.UID, &user.Name, &user.Email, &user.Pass, &user.DeletedUser); err != nil {
-			return nil, err
+	return users, nil
+}
+
+func (r *Repository) GetUserByID(ctx context.Context, uid string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
+	defer cancel()
+	row := r.conn.QueryRow(ctx, "SELECT "+userColumns+" FROM Users WHERE uid = $1 AND deleted_user = false", uid)
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, ErrUserNotFound
 		}
-		users = append(users, user)
+		return models.User{}, err
 	}
-	if len(users) == 0 {
-		return nil, ErrUserListEmpty
+	return user, nil
+}
+
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
+	defer cancel()
+	row := r.conn.QueryRow(ctx, "SELECT "+userColumns+" FROM Users WHERE email = $1 AND deleted_user = false", email)
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, ErrUserNotFound
+		}
+		return models.User{}, err
 	}
-	return users, nil
+	return user, nil
 }
 
-func (r *Repository) UpdateUser(uid string, user models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) SetTOTPSecret(ctx context.Context, uid, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
 	defer cancel()
-	_, err := r.conn.Exec(ctx,
-		"UPDATE Users SET name = $1, email = $2, pass = $3 WHERE uid = $4 AND deleted_user = false",
-		user.Name, user.Email, user.Pass, uid)
+	result, err := r.conn.Exec(ctx,
+		"UPDATE Users SET totp_secret = $1, totp_enabled = false, version = version + 1 WHERE uid = $2 AND deleted_user = false",
+		secret, uid)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *Repository) EnableTOTP(ctx context.Context, uid string, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	transaction, err := r.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err = transaction.Rollback(ctx); err != nil {
+			return
+		}
+	}()
+	result, err := transaction.Exec(ctx,
+		"UPDATE Users SET totp_enabled = true, version = version + 1 WHERE uid = $1 AND deleted_user = false AND totp_secret <> ''", uid)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTOTPNotEnrolled
+	}
+	if _, err = transaction.Exec(ctx, "DELETE FROM RecoveryCodes WHERE user_uid = $1", uid); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err = transaction.Exec(ctx,
+			"INSERT INTO RecoveryCodes(user_uid, code_hash) VALUES($1, $2)", uid, hash); err != nil {
+			return err
+		}
+	}
+	if err := transaction.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) UseRecoveryCode(ctx context.Context, uid, codeHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	result, err := r.conn.Exec(ctx,
+		"UPDATE RecoveryCodes SET used = true WHERE user_uid = $1 AND code_hash = $2 AND used = false",
+		uid, codeHash)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+func (r *Repository) UpdateUser(ctx context.Context, uid string, user models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	var hash string
+	if user.Pass != "" {
+		var err error
+		if hash, err = r.hasher.Hash(user.Pass); err != nil {
+			return err
+		}
+	}
+	result, err := r.conn.Exec(ctx,
+		`UPDATE Users SET name = $1, email = $2, pass = COALESCE(NULLIF($3, ''), pass), version = version + 1
+		WHERE uid = $4 AND deleted_user = false AND ($5::bigint = 0 OR version = $5)`,
+		user.Name, user.Email, hash, uid, user.Version)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return missingOrStale(ctx, r.conn, activeUserQuery, uid, user.Version, ErrUserNotFound)
+	}
+	return nil
+}
+
+func (r *Repository) SetPendingEmail(ctx context.Context, uid, email, tokenHash string, expires time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	result, err := r.conn.Exec(ctx,
+		`UPDATE Users SET pending_email = $1, email_token_hash = $2, email_token_expires = $3
+		WHERE uid = $4 AND deleted_user = false`,
+		email, tokenHash, expires, uid)
 	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
 		return ErrUserNotFound
 	}
 	return nil
 }
 
-func (r *Repository) DeleteUser(uid string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) ConfirmEmail(ctx context.Context, uid, tokenHash string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	row := r.conn.QueryRow(ctx,
+		`UPDATE Users SET email = pending_email, pending_email = '', email_token_hash = '', email_token_expires = NULL,
+		version = version + 1
+		WHERE uid = $1 AND deleted_user = false AND pending_email <> ''
+		AND email_token_hash = $2 AND email_token_expires > NOW()
+		RETURNING email`,
+		uid, tokenHash)
+	var email string
+	if err := row.Scan(&email); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrEmailTokenInvalid
+		}
+		if isUniqueViolation(err) {
+			return "", ErrEmailTaken
+		}
+		return "", err
+	}
+	return email, nil
+}
+
+func (r *Repository) DeleteUser(ctx context.Context, uid string, version int64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
 	defer cancel()
 	transaction, err := r.conn.Begin(ctx)
 	if err != nil {
@@ -108,25 +414,64 @@ func (r *Repository) DeleteUser(uid string) error {
 	}()
 	if _, err = transaction.Prepare(ctx,
 		"update user",
-		"UPDATE Users SET deleted_user = true WHERE uid = $1"); err != nil {
+		`UPDATE Users SET deleted_user = true, version = version + 1
+		WHERE uid = $1 AND deleted_user = false AND ($2::bigint = 0 OR version = $2)`); err != nil {
 		return err
 	}
-	result, err := transaction.Exec(ctx, "update user", uid)
+	result, err := transaction.Exec(ctx, "update user", uid, version)
 	if err != nil {
-		return ErrUserNotFound
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return missingOrStale(ctx, transaction, activeUserQuery, uid, version, ErrUserNotFound)
+	}
+	if err := transaction.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// EraseUser обезличивает пользователя по запросу на удаление данных: стирает имя, почту,
+// пароль и секреты, удаляет ключи и помечает запись удалённой. Книги пользователя остаются в каталоге.
+func (r *Repository) EraseUser(ctx context.Context, uid string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	transaction, err := r.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err = transaction.Rollback(ctx); err != nil {
+			return
+		}
+	}()
+	result, err := transaction.Exec(ctx,
+		`UPDATE Users SET name = $1, email = $2, pass = '', role = $3, totp_secret = '', totp_enabled = false,
+		pending_email = '', email_token_hash = '', email_token_expires = NULL, deleted_user = true,
+		version = version + 1
+		WHERE uid = $4 AND deleted_user = false`,
+		ErasedUserName, erasedEmail(uid), models.RoleMember, uid)
+	if err != nil {
+		return err
 	}
 	if result.RowsAffected() == 0 {
 		return ErrUserNotFound
 	}
+	if _, err = transaction.Exec(ctx, "DELETE FROM RecoveryCodes WHERE user_uid = $1", uid); err != nil {
+		return err
+	}
+	if _, err = transaction.Exec(ctx, "DELETE FROM ApiKeys WHERE owner_uid = $1", uid); err != nil {
+		return err
+	}
 	if err := transaction.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 	return nil
 }
 
-func (r *Repository) DeleteUsers() error {
-	zLog := logger.Get()
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) DeleteUsers(ctx context.Context) error {
+	zLog := logger.Named(logger.ModuleStorage)
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
 	defer cancel()
 	result, err := r.conn.Exec(ctx, "DELETE FROM users WHERE deleted_user = true")
 	if err != nil {
@@ -138,38 +483,118 @@ func (r *Repository) DeleteUsers() error {
 	return nil
 }
 
-func (r *Repository) GetBooks() ([]models.Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) GetBooks(ctx context.Context) ([]models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
+	defer cancel()
+	var books []models.Book
+	err := r.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, "SELECT "+bookColumns+" FROM Books WHERE deleted = false ORDER BY created_at, bid")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		books = make([]models.Book, 0)
+		for rows.Next() {
+			var book models.Book
+			if err := rows.Scan(&book.BID, &book.Label, &book.Author, &book.Deleted, &book.UserUID, &book.CreatedAt, &book.Version); err != nil {
+				return err
+			}
+			books = append(books, book)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// exportBatch - сколько книг ExportBooks читает из базы за один запрос.
+const exportBatch = 500
+
+// ExportBooks передаёт fn книги в порядке GetBooks, не загружая каталог в память: книги читаются
+// серверным курсором пачками по exportBatch внутри транзакции REPEATABLE READ, так что выгрузка -
+// согласованный снимок каталога на момент её начала. Пока fn пишет книги клиенту, транзакция занимает
+// соединение пула и удерживает снимок, поэтому вся выгрузка ограничена ExportTimeout, а каждая пачка -
+// таймаутом чтения; число одновременных выгрузок ограничивает обработчик.
+// Ошибка fn прерывает выгрузку и возвращается как есть. Выгрузка идёт с реплики, если она есть,
+// но без переключения на primary при сбое: часть книг к этому моменту уже передана в fn.
+func (r *Repository) ExportBooks(ctx context.Context, fn func(models.Book) error) error {
+	ctx, cancel := context.WithTimeout(ctx, r.exportTimeout)
+	defer cancel()
+	pool := r.conn
+	if rep := r.pickReplica(ctx); rep != nil {
+		pool = rep.pool
+	}
+	transaction, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		// ctx к этому моменту может быть отменён, а откат по отменённому контексту не дойдёт до сервера
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.writeTimeout)
+		defer cancel()
+		_ = transaction.Rollback(ctx)
+	}()
+	if _, err = transaction.Exec(ctx, "DECLARE export_books NO SCROLL CURSOR FOR SELECT "+bookColumns+
+		" FROM Books WHERE deleted = false ORDER BY created_at, bid"); err != nil {
+		return fmt.Errorf("failed to declare cursor: %w", err)
+	}
+	for {
+		books, err := r.fetchBooks(ctx, transaction)
+		if err != nil {
+			return err
+		}
+		for _, book := range books {
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+		if len(books) < exportBatch {
+			return nil
+		}
+	}
+}
+
+// fetchBooks читает следующую пачку книг из курсора export_books.
+func (r *Repository) fetchBooks(ctx context.Context, transaction pgx.Tx) ([]models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
 	defer cancel()
-	rows, err := r.conn.Query(ctx, "SELECT * FROM Books WHERE deleted = false")
+	rows, err := transaction.Query(ctx, "FETCH "+strconv.Itoa(exportBatch)+" FROM export_books")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var books []models.Book
+	books := make([]models.Book, 0, exportBatch)
 	for rows.Next() {
 		var book models.Book
-		if err := rows.Scan(&book.BID, &book.Label, &book.Author, &book.Deleted, &book.UserUID, &book.CreatedAt); err != nil {
+		if err := rows.Scan(&book.BID, &book.Label, &book.Author, &book.Deleted, &book.UserUID, &book.CreatedAt, &book.Version); err != nil {
 			return nil, err
 		}
 		books = append(books, book)
 	}
-	if len(books) == 0 {
-		return nil, ErrBooksListEmpty
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
 	}
 	return books, nil
 }
 
-func (r *Repository) GetBookByID(bid string) (models.Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) GetBookByID(ctx context.Context, bid string) (models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
 	defer cancel()
-	row := r.conn.QueryRow(ctx, "SELECT * FROM Books WHERE bid = $1", bid)
 	var book models.Book
-	if err := row.Scan(&book.BID, &book.Label, &book.Author, &book.Deleted, &book.UserUID, &book.CreatedAt); err != nil {
+	err := r.read(ctx, func(q querier) error {
+		row := q.QueryRow(ctx, "SELECT "+bookColumns+" FROM Books WHERE bid = $1", bid)
+		return row.Scan(&book.BID, &book.Label, &book.Author, &book.Deleted, &book.UserUID, &book.CreatedAt, &book.Version)
+	})
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return models.Book{}, fmt.Errorf("book with id = %s, does not exist", bid)
+			return models.Book{}, ErrBookNotFound
 		}
-		return models.Book{}, ErrBookNotFound
+		return models.Book{}, err
 	}
 	if book.Deleted {
 		return models.Book{}, ErrBookWasDeleted
@@ -177,15 +602,26 @@ func (r *Repository) GetBookByID(bid string) (models.Book, error) {
 	return book, nil
 }
 
-func (r *Repository) GetBookByUID(uid string) ([]models.Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) GetBookByUID(ctx context.Context, uid string) ([]models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
 	defer cancel()
-	rows, err := r.conn.Query(ctx,
-		"SELECT bid,label,author,deleted,user_uid,created_at FROM Books WHERE deleted = false AND user_uid = $1", uid)
+	var books []models.Book
+	err := r.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx,
+			"SELECT "+bookColumns+" FROM Books WHERE deleted = false AND user_uid = $1 ORDER BY created_at, bid", uid)
+		if err != nil {
+			return err
+		}
+		// Автоматически сканирует все строки в слайс
+		books, err = pgx.CollectRows(rows, pgx.RowToStructByName[models.Book])
+		if err != nil {
+			return fmt.Errorf("failed to collect books: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 	//books := make([]models.Book, 0)
 	//for rows.Next() {
 	//	var book models.Book
@@ -198,19 +634,14 @@ func (r *Repository) GetBookByUID(uid string) ([]models.Book, error) {
 	//if err := rows.Err(); err != nil {
 	//	return nil, fmt.Errorf("error iterating rows: %w", err)
 	//}
-	// Автоматически сканирует все строки в слайс
-	books, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Book])
-	if err != nil {
-		return nil, fmt.Errorf("failed to collect books: %w", err)
-	}
-	if len(books) == 0 {
-		return nil, ErrBooksListEmpty
+	if books == nil {
+		books = make([]models.Book, 0)
 	}
 	return books, nil
 }
 
-func (r *Repository) SaveBook(book models.Book) error {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) SaveBook(ctx context.Context, book models.Book) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
 	defer cancel()
 	_, err := r.conn.Exec(ctx, "INSERT INTO Books VALUES($1, $2, $3, $4, $5, $6)",
 		uuid.NewString(), book.Label, book.Author, book.Deleted, book.UserUID, time.Now())
@@ -220,9 +651,127 @@ func (r *Repository) SaveBook(book models.Book) error {
 	return nil
 }
 
-func (r *Repository) DeleteBook(bid string) error {
-	zLog := logger.Get()
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+// SaveBooks сохраняет книги одной транзакцией через COPY: сохраняются либо все, либо ни одной.
+// created_at растёт по порядку книг, поэтому списки отдают их в порядке импорта.
+func (r *Repository) SaveBooks(ctx context.Context, books []models.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	transaction, err := r.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err = transaction.Rollback(ctx); err != nil {
+			return
+		}
+	}()
+	now := time.Now()
+	rows := make([][]any, len(books))
+	for i, book := range books {
+		rows[i] = []any{uuid.NewString(), book.Label, book.Author, book.Deleted, book.UserUID, now.Add(time.Duration(i) * time.Microsecond)}
+	}
+	if _, err = transaction.CopyFrom(ctx, pgx.Identifier{"books"},
+		[]string{"bid", "label", "author", "deleted", "user_uid", "created_at"}, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("failed to copy books: %w", err)
+	}
+	if err := transaction.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdateBook заменяет название и автора книги и сохраняет прежнюю версию в историю от имени editor.
+// book.Version - ожидаемая версия, 0 - без проверки.
+func (r *Repository) UpdateBook(ctx context.Context, book models.Book, editor string) (models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	transaction, err := r.conn.Begin(ctx)
+	if err != nil {
+		return models.Book{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err = transaction.Rollback(ctx); err != nil {
+			return
+		}
+	}()
+	var prev models.Book
+	err = transaction.QueryRow(ctx,
+		"SELECT label, author, version FROM Books WHERE bid = $1 AND deleted = false FOR UPDATE", book.BID).
+		Scan(&prev.Label, &prev.Author, &prev.Version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Book{}, ErrBookNotFound
+		}
+		return models.Book{}, err
+	}
+	if book.Version != 0 && book.Version != prev.Version {
+		return models.Book{}, ErrVersionMismatch
+	}
+	if _, err = transaction.Exec(ctx,
+		"INSERT INTO BookRevisions("+revisionColumns+") VALUES($1, $2, $3, $4, $5, $6)",
+		book.BID, prev.Version, prev.Label, prev.Author, editor, time.Now()); err != nil {
+		return models.Book{}, err
+	}
+	var updated models.Book
+	err = transaction.QueryRow(ctx,
+		"UPDATE Books SET label = $1, author = $2, version = version + 1 WHERE bid = $3 RETURNING "+bookColumns,
+		book.Label, book.Author, book.BID).
+		Scan(&updated.BID, &updated.Label, &updated.Author, &updated.Deleted, &updated.UserUID, &updated.CreatedAt, &updated.Version)
+	if err != nil {
+		return models.Book{}, fmt.Errorf("failed to update book: %w", err)
+	}
+	if err := transaction.Commit(ctx); err != nil {
+		return models.Book{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
+}
+
+// GetBookHistory возвращает прежние версии книги по возрастанию номера.
+func (r *Repository) GetBookHistory(ctx context.Context, bid string) ([]models.BookRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
+	defer cancel()
+	var revisions []models.BookRevision
+	err := r.read(ctx, func(q querier) error {
+		var deleted bool
+		if err := q.QueryRow(ctx, "SELECT deleted FROM Books WHERE bid = $1", bid).Scan(&deleted); err != nil {
+			return err
+		}
+		if deleted {
+			return ErrBookWasDeleted
+		}
+		rows, err := q.Query(ctx, "SELECT "+revisionColumns+" FROM BookRevisions WHERE bid = $1 ORDER BY version", bid)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		revisions = make([]models.BookRevision, 0)
+		for rows.Next() {
+			var rev models.BookRevision
+			if err := rows.Scan(&rev.BID, &rev.Version, &rev.Label, &rev.Author, &rev.EditedBy, &rev.EditedAt); err != nil {
+				return err
+			}
+			revisions = append(revisions, rev)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBookNotFound
+		}
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (r *Repository) DeleteBook(ctx context.Context, bid string, version int64) error {
+	zLog := logger.Named(logger.ModuleStorage)
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
 	defer cancel()
 	transaction, err := r.conn.Begin(ctx)
 	if err != nil {
@@ -235,15 +784,16 @@ func (r *Repository) DeleteBook(bid string) error {
 	}()
 	if _, err = transaction.Prepare(ctx,
 		"update book",
-		"UPDATE Books SET deleted = true WHERE bid = $1"); err != nil {
+		`UPDATE Books SET deleted = true, version = version + 1
+		WHERE bid = $1 AND deleted = false AND ($2::bigint = 0 OR version = $2)`); err != nil {
 		return err
 	}
-	result, err := transaction.Exec(ctx, "update book", bid)
+	result, err := transaction.Exec(ctx, "update book", bid, version)
 	if err != nil {
 		return fmt.Errorf("failed to delete book: %w", err)
 	}
 	if result.RowsAffected() == 0 {
-		return ErrBookNotFound
+		return missingOrStale(ctx, transaction, activeBookQuery, bid, version, ErrBookNotFound)
 	}
 	zLog.Debug().Msgf("book id = %s, deleted = %t", bid, true)
 
@@ -253,9 +803,9 @@ func (r *Repository) DeleteBook(bid string) error {
 	return nil
 }
 
-func (r *Repository) DeleteBooks() error {
-	zLog := logger.Get()
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+func (r *Repository) DeleteBooks(ctx context.Context) error {
+	zLog := logger.Named(logger.ModuleStorage)
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
 	defer cancel()
 	res, err := r.conn.Exec(ctx, "DELETE FROM Books WHERE deleted = true")
 	if err != nil {
@@ -267,16 +817,119 @@ func (r *Repository) DeleteBooks() error {
 	return nil
 }
 
-func Migrations(dbAddr, migrationsPath string) error {
-	migratePath := fmt.Sprintf("file://%s", migrationsPath)
-	m, err := migrate.New(migratePath, dbAddr)
+func (r *Repository) SaveAPIKey(ctx context.Context, key models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	_, err := r.conn.Exec(ctx,
+		"INSERT INTO ApiKeys("+apiKeyColumns+") VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		key.ID, key.Name, key.Prefix, key.Hash, key.OwnerUID, key.Scopes, key.ExpiresAt, key.Revoked, key.CreatedAt)
 	if err != nil {
-		return fmt.Errorf("could not create migrate instance: %w", err)
+		return err
 	}
-	// Применение всех миграций
-	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		return fmt.Errorf("could not run migrations: %w", err)
+	return nil
+}
+
+func (r *Repository) GetAPIKeyByID(ctx context.Context, id string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
+	defer cancel()
+	row := r.conn.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM ApiKeys WHERE id = $1", id)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.APIKey{}, ErrAPIKeyNotFound
+		}
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (r *Repository) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
+	defer cancel()
+	row := r.conn.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM ApiKeys WHERE key_hash = $1", hash)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.APIKey{}, ErrAPIKeyNotFound
+		}
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (r *Repository) GetAPIKeysByOwner(ctx context.Context, uid string) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.readTimeout)
+	defer cancel()
+	rows, err := r.conn.Query(ctx,
+		"SELECT "+apiKeyColumns+" FROM ApiKeys WHERE owner_uid = $1 ORDER BY created_at", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *Repository) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.writeTimeout)
+	defer cancel()
+	result, err := r.conn.Exec(ctx, "UPDATE ApiKeys SET revoked = true WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
 	}
-	log.Println("Migrations successfully created")
 	return nil
 }
+
+// Запросы существования записи для missingOrStale.
+const (
+	activeUserQuery = "SELECT true FROM Users WHERE uid = $1 AND deleted_user = false"
+	activeBookQuery = "SELECT true FROM Books WHERE bid = $1 AND deleted = false"
+)
+
+// missingOrStale объясняет, почему условное изменение не затронуло ни одной строки:
+// записи нет (notFound) или у неё другая версия (ErrVersionMismatch).
+func missingOrStale(ctx context.Context, q querier, exists, id string, version int64, notFound error) error {
+	if version == 0 {
+		return notFound
+	}
+	var found bool
+	if err := q.QueryRow(ctx, exists, id).Scan(&found); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound
+		}
+		return err
+	}
+	return ErrVersionMismatch
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
+
+func scanAPIKey(row pgx.Row) (models.APIKey, error) {
+	var key models.APIKey
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &key.OwnerUID, &key.Scopes,
+		&key.ExpiresAt, &key.Revoked, &key.CreatedAt)
+	return key, err
+}
+
+func scanUser(row pgx.Row) (models.User, error) {
+	var user models.User
+	err := row.Scan(&user.UID, &user.Name, &user.Email, &user.Pass, &user.DeletedUser,
+		&user.Role, &user.TOTPSecret, &user.TOTPEnabled, &user.Version)
+	return user, err
+}
@@ -0,0 +1,733 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/Rustam2595/library_service/internal/password"
+	"github.com/google/uuid"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteScheme - схема DSN встроенной базы: sqlite:///var/lib/library.db или sqlite://library.db.
+const SQLiteScheme = "sqlite://"
+
+// SQLiteStorage - хранилище на встроенном SQLite (чистый Go, без cgo) для стендов и локальной разработки.
+// Схема базы - migrations/sqlite. Время хранится в микросекундах Unix, как точность timestamp в PostgreSQL.
+type SQLiteStorage struct {
+	db     *sql.DB
+	hasher password.Hasher
+}
+
+// NewSQLite открывает базу по DSN вида sqlite://path. Файл создаётся, если его нет.
+// hasher хеширует пароли; nil - password.Default().
+func NewSQLite(ctx context.Context, dsn string, hasher password.Hasher) (*SQLiteStorage, error) {
+	path, ok := strings.CutPrefix(dsn, SQLiteScheme)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("invalid sqlite dsn, want %spath", SQLiteScheme)
+	}
+	path, _, _ = strings.Cut(path, "?")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite допускает одного писателя; одно соединение избавляет от SQLITE_BUSY на параллельных транзакциях
+	db.SetMaxOpenConns(1)
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteStorage{
+		db:     db,
+		hasher: hasherOr(hasher),
+	}, nil
+}
+
+// Ping проверяет доступность базы данных.
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close закрывает базу.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStorage) SaveUser(ctx context.Context, user models.User) (string, error) {
+	// хеширование медленное и не должно съедать таймаут запроса к базе
+	hash, err := s.hasher.Hash(user.Pass)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	UID := uuid.NewString()
+	_, err = s.db.ExecContext(ctx, "INSERT INTO Users(uid, name, email, pass) VALUES(?, ?, ?, ?)",
+		UID, user.Name, user.Email, hash)
+	if err != nil {
+		if isSQLiteUnique(err) {
+			return "", ErrEmailTaken
+		}
+		return "", err
+	}
+	return UID, nil
+}
+
+func (s *SQLiteStorage) ValidateUser(ctx context.Context, user models.User) (string, error) {
+	zLog := logger.Named(logger.ModuleStorage)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	row := s.db.QueryRowContext(ctx, "SELECT uid, pass FROM Users WHERE email = ? AND deleted_user = false",
+		user.Email)
+	var pass, uid string
+	if err := row.Scan(&uid, &pass); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	ok, needsRehash, err := s.hasher.Verify(pass, user.Pass)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrInvalidAuthData
+	}
+	if needsRehash {
+		hash, err := s.hasher.Hash(user.Pass)
+		if err != nil {
+			return "", err
+		}
+		if _, err = s.db.ExecContext(ctx, "UPDATE Users SET pass = ? WHERE uid = ?", hash, uid); err != nil {
+			zLog.Error().Err(err).Str("uid", uid).Msg("password rehash failed")
+		}
+	}
+	return uid, nil
+}
+
+func (s *SQLiteStorage) GetUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, "SELECT "+userColumns+" FROM Users WHERE deleted_user = false ORDER BY email")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]models.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return users, nil
+}
+
+func (s *SQLiteStorage) GetUserByID(ctx context.Context, uid string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	row := s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM Users WHERE uid = ? AND deleted_user = false", uid)
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, ErrUserNotFound
+		}
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteStorage) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	row := s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM Users WHERE email = ? AND deleted_user = false", email)
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, ErrUserNotFound
+		}
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (s *SQLiteStorage) SetTOTPSecret(ctx context.Context, uid, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE Users SET totp_secret = ?, totp_enabled = false, version = version + 1 WHERE uid = ? AND deleted_user = false",
+		secret, uid)
+	return affected(result, err, ErrUserNotFound)
+}
+
+func (s *SQLiteStorage) EnableTOTP(ctx context.Context, uid string, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = transaction.Rollback()
+	}()
+	result, err := transaction.ExecContext(ctx,
+		"UPDATE Users SET totp_enabled = true, version = version + 1 WHERE uid = ? AND deleted_user = false AND totp_secret <> ''", uid)
+	if err = affected(result, err, ErrTOTPNotEnrolled); err != nil {
+		return err
+	}
+	if _, err = transaction.ExecContext(ctx, "DELETE FROM RecoveryCodes WHERE user_uid = ?", uid); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err = transaction.ExecContext(ctx,
+			"INSERT INTO RecoveryCodes(user_uid, code_hash) VALUES(?, ?)", uid, hash); err != nil {
+			return err
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) UseRecoveryCode(ctx context.Context, uid, codeHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE RecoveryCodes SET used = true WHERE user_uid = ? AND code_hash = ? AND used = false",
+		uid, codeHash)
+	return affected(result, err, ErrRecoveryCodeInvalid)
+}
+
+func (s *SQLiteStorage) UpdateUser(ctx context.Context, uid string, user models.User) error {
+	var hash string
+	if user.Pass != "" {
+		var err error
+		if hash, err = s.hasher.Hash(user.Pass); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE Users SET name = ?, email = ?, pass = COALESCE(NULLIF(?, ''), pass), version = version + 1
+		WHERE uid = ? AND deleted_user = false AND (? = 0 OR version = ?)`,
+		user.Name, user.Email, hash, uid, user.Version, user.Version)
+	if err != nil && isSQLiteUnique(err) {
+		return ErrEmailTaken
+	}
+	if err = affected(result, err, ErrUserNotFound); errors.Is(err, ErrUserNotFound) {
+		return s.missingOrStale(ctx, sqliteActiveUserQuery, uid, user.Version, ErrUserNotFound)
+	}
+	return err
+}
+
+func (s *SQLiteStorage) SetPendingEmail(ctx context.Context, uid, email, tokenHash string, expires time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE Users SET pending_email = ?, email_token_hash = ?, email_token_expires = ?
+		WHERE uid = ? AND deleted_user = false`,
+		email, tokenHash, expires.UnixMicro(), uid)
+	return affected(result, err, ErrUserNotFound)
+}
+
+func (s *SQLiteStorage) ConfirmEmail(ctx context.Context, uid, tokenHash string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	row := s.db.QueryRowContext(ctx,
+		`UPDATE Users SET email = pending_email, pending_email = '', email_token_hash = '', email_token_expires = NULL,
+		version = version + 1
+		WHERE uid = ? AND deleted_user = false AND pending_email <> ''
+		AND email_token_hash = ? AND email_token_expires > ?
+		RETURNING email`,
+		uid, tokenHash, time.Now().UnixMicro())
+	var email string
+	if err := row.Scan(&email); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrEmailTokenInvalid
+		}
+		if isSQLiteUnique(err) {
+			return "", ErrEmailTaken
+		}
+		return "", err
+	}
+	return email, nil
+}
+
+func (s *SQLiteStorage) DeleteUser(ctx context.Context, uid string, version int64) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE Users SET deleted_user = true, version = version + 1
+		WHERE uid = ? AND deleted_user = false AND (? = 0 OR version = ?)`,
+		uid, version, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if err = affected(result, nil, ErrUserNotFound); errors.Is(err, ErrUserNotFound) {
+		return s.missingOrStale(ctx, sqliteActiveUserQuery, uid, version, ErrUserNotFound)
+	}
+	return err
+}
+
+// EraseUser обезличивает пользователя так же, как Repository.EraseUser.
+func (s *SQLiteStorage) EraseUser(ctx context.Context, uid string) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = transaction.Rollback()
+	}()
+	result, err := transaction.ExecContext(ctx,
+		`UPDATE Users SET name = ?, email = ?, pass = '', role = ?, totp_secret = '', totp_enabled = false,
+		pending_email = '', email_token_hash = '', email_token_expires = NULL, deleted_user = true,
+		version = version + 1
+		WHERE uid = ? AND deleted_user = false`,
+		ErasedUserName, erasedEmail(uid), models.RoleMember, uid)
+	if err = affected(result, err, ErrUserNotFound); err != nil {
+		return err
+	}
+	if _, err = transaction.ExecContext(ctx, "DELETE FROM RecoveryCodes WHERE user_uid = ?", uid); err != nil {
+		return err
+	}
+	if _, err = transaction.ExecContext(ctx, "DELETE FROM ApiKeys WHERE owner_uid = ?", uid); err != nil {
+		return err
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteUsers(ctx context.Context) error {
+	zLog := logger.Named(logger.ModuleStorage)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, "DELETE FROM Users WHERE deleted_user = true")
+	if err != nil {
+		zLog.Error().Err(err).Msg("deleted users failed")
+		return err
+	}
+	deletedCount, _ := result.RowsAffected()
+	zLog.Debug().Msgf("%d users deleted!", deletedCount)
+	return nil
+}
+
+func (s *SQLiteStorage) GetBooks(ctx context.Context) ([]models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, "SELECT "+bookColumns+" FROM Books WHERE deleted = false ORDER BY created_at, bid")
+	if err != nil {
+		return nil, err
+	}
+	return collectSQLiteBooks(rows)
+}
+
+// ExportBooks передаёт fn книги в порядке GetBooks пачками по exportBatch. У SQLite одно соединение,
+// поэтому вместо курсора каждая пачка - отдельный запрос от последней выданной книги,
+// и соединение не занято, пока fn пишет книги клиенту.
+func (s *SQLiteStorage) ExportBooks(ctx context.Context, fn func(models.Book) error) error {
+	var (
+		lastCreated int64
+		lastBID     string
+	)
+	for {
+		books, err := s.booksAfter(ctx, lastCreated, lastBID)
+		if err != nil {
+			return err
+		}
+		for _, book := range books {
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+		if len(books) < exportBatch {
+			return nil
+		}
+		last := books[len(books)-1]
+		lastCreated, lastBID = last.CreatedAt.UnixMicro(), last.BID
+	}
+}
+
+// booksAfter возвращает до exportBatch книг, идущих в порядке GetBooks после (created, bid).
+func (s *SQLiteStorage) booksAfter(ctx context.Context, created int64, bid string) ([]models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, "SELECT "+bookColumns+
+		" FROM Books WHERE deleted = false AND (created_at, bid) > (?, ?) ORDER BY created_at, bid LIMIT ?",
+		created, bid, exportBatch)
+	if err != nil {
+		return nil, err
+	}
+	return collectSQLiteBooks(rows)
+}
+
+func (s *SQLiteStorage) GetBookByID(ctx context.Context, bid string) (models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	row := s.db.QueryRowContext(ctx, "SELECT "+bookColumns+" FROM Books WHERE bid = ?", bid)
+	book, err := scanSQLiteBook(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Book{}, ErrBookNotFound
+		}
+		return models.Book{}, err
+	}
+	if book.Deleted {
+		return models.Book{}, ErrBookWasDeleted
+	}
+	return book, nil
+}
+
+func (s *SQLiteStorage) GetBookByUID(ctx context.Context, uid string) ([]models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+bookColumns+" FROM Books WHERE deleted = false AND user_uid = ? ORDER BY created_at, bid", uid)
+	if err != nil {
+		return nil, err
+	}
+	return collectSQLiteBooks(rows)
+}
+
+func (s *SQLiteStorage) SaveBook(ctx context.Context, book models.Book) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO Books(bid, label, author, deleted, user_uid, created_at) VALUES(?, ?, ?, ?, ?, ?)",
+		uuid.NewString(), book.Label, book.Author, book.Deleted, book.UserUID, time.Now().UnixMicro())
+	return err
+}
+
+// SaveBooks сохраняет книги одной транзакцией так же, как Repository.SaveBooks.
+func (s *SQLiteStorage) SaveBooks(ctx context.Context, books []models.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = transaction.Rollback()
+	}()
+	stmt, err := transaction.PrepareContext(ctx,
+		"INSERT INTO Books(bid, label, author, deleted, user_uid, created_at) VALUES(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().UnixMicro()
+	for i, book := range books {
+		if _, err = stmt.ExecContext(ctx, uuid.NewString(), book.Label, book.Author, book.Deleted, book.UserUID, now+int64(i)); err != nil {
+			return fmt.Errorf("failed to insert book: %w", err)
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdateBook заменяет название и автора книги так же, как Repository.UpdateBook.
+func (s *SQLiteStorage) UpdateBook(ctx context.Context, book models.Book, editor string) (models.Book, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Book{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = transaction.Rollback()
+	}()
+	var prev models.Book
+	err = transaction.QueryRowContext(ctx,
+		"SELECT label, author, version FROM Books WHERE bid = ? AND deleted = false", book.BID).
+		Scan(&prev.Label, &prev.Author, &prev.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Book{}, ErrBookNotFound
+		}
+		return models.Book{}, err
+	}
+	if book.Version != 0 && book.Version != prev.Version {
+		return models.Book{}, ErrVersionMismatch
+	}
+	if _, err = transaction.ExecContext(ctx,
+		"INSERT INTO BookRevisions("+revisionColumns+") VALUES(?, ?, ?, ?, ?, ?)",
+		book.BID, prev.Version, prev.Label, prev.Author, editor, time.Now().UnixMicro()); err != nil {
+		return models.Book{}, err
+	}
+	updated, err := scanSQLiteBook(transaction.QueryRowContext(ctx,
+		"UPDATE Books SET label = ?, author = ?, version = version + 1 WHERE bid = ? RETURNING "+bookColumns,
+		book.Label, book.Author, book.BID))
+	if err != nil {
+		return models.Book{}, fmt.Errorf("failed to update book: %w", err)
+	}
+	if err := transaction.Commit(); err != nil {
+		return models.Book{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return updated, nil
+}
+
+// GetBookHistory возвращает прежние версии книги по возрастанию номера.
+func (s *SQLiteStorage) GetBookHistory(ctx context.Context, bid string) ([]models.BookRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	var deleted bool
+	if err := s.db.QueryRowContext(ctx, "SELECT deleted FROM Books WHERE bid = ?", bid).Scan(&deleted); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrBookNotFound
+		}
+		return nil, err
+	}
+	if deleted {
+		return nil, ErrBookWasDeleted
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+revisionColumns+" FROM BookRevisions WHERE bid = ? ORDER BY version", bid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := make([]models.BookRevision, 0)
+	for rows.Next() {
+		var (
+			rev    models.BookRevision
+			edited int64
+		)
+		if err := rows.Scan(&rev.BID, &rev.Version, &rev.Label, &rev.Author, &rev.EditedBy, &edited); err != nil {
+			return nil, err
+		}
+		rev.EditedAt = time.UnixMicro(edited).UTC()
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return revisions, nil
+}
+
+func (s *SQLiteStorage) DeleteBook(ctx context.Context, bid string, version int64) error {
+	zLog := logger.Named(logger.ModuleStorage)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE Books SET deleted = true, version = version + 1
+		WHERE bid = ? AND deleted = false AND (? = 0 OR version = ?)`,
+		bid, version, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete book: %w", err)
+	}
+	err = affected(result, nil, ErrBookNotFound)
+	if errors.Is(err, ErrBookNotFound) {
+		err = s.missingOrStale(ctx, sqliteActiveBookQuery, bid, version, ErrBookNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	zLog.Debug().Msgf("book id = %s, deleted = %t", bid, true)
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteBooks(ctx context.Context) error {
+	zLog := logger.Named(logger.ModuleStorage)
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, "DELETE FROM Books WHERE deleted = true")
+	if err != nil {
+		zLog.Error().Err(err).Msg("deleted books failed")
+		return err
+	}
+	deletedCount, _ := res.RowsAffected()
+	zLog.Debug().Msgf("%d books deleted!", deletedCount)
+	return nil
+}
+
+func (s *SQLiteStorage) SaveAPIKey(ctx context.Context, key models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+	var expires sql.NullInt64
+	if key.ExpiresAt != nil {
+		expires = sql.NullInt64{Int64: key.ExpiresAt.UnixMicro(), Valid: true}
+	}
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO ApiKeys("+apiKeyColumns+") VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		key.ID, key.Name, key.Prefix, key.Hash, key.OwnerUID, string(scopes), expires, key.Revoked, key.CreatedAt.UnixMicro())
+	return err
+}
+
+func (s *SQLiteStorage) GetAPIKeyByID(ctx context.Context, id string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	row := s.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM ApiKeys WHERE id = ?", id)
+	key, err := scanSQLiteAPIKey(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIKey{}, ErrAPIKeyNotFound
+		}
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (s *SQLiteStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	row := s.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM ApiKeys WHERE key_hash = ?", hash)
+	key, err := scanSQLiteAPIKey(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.APIKey{}, ErrAPIKeyNotFound
+		}
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (s *SQLiteStorage) GetAPIKeysByOwner(ctx context.Context, uid string) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+apiKeyColumns+" FROM ApiKeys WHERE owner_uid = ? ORDER BY created_at", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		key, err := scanSQLiteAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *SQLiteStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, "UPDATE ApiKeys SET revoked = true WHERE id = ?", id)
+	return affected(result, err, ErrAPIKeyNotFound)
+}
+
+// affected возвращает notFound, если запрос не изменил ни одной строки.
+func affected(result sql.Result, err, notFound error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// Запросы существования записи для missingOrStale.
+const (
+	sqliteActiveUserQuery = "SELECT true FROM Users WHERE uid = ? AND deleted_user = false"
+	sqliteActiveBookQuery = "SELECT true FROM Books WHERE bid = ? AND deleted = false"
+)
+
+// missingOrStale - то же, что одноимённая функция Repository: отличает отсутствующую запись от устаревшей версии.
+func (s *SQLiteStorage) missingOrStale(ctx context.Context, exists, id string, version int64, notFound error) error {
+	if version == 0 {
+		return notFound
+	}
+	var found bool
+	if err := s.db.QueryRowContext(ctx, exists, id).Scan(&found); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFound
+		}
+		return err
+	}
+	return ErrVersionMismatch
+}
+
+func isSQLiteUnique(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func collectSQLiteBooks(rows *sql.Rows) ([]models.Book, error) {
+	defer rows.Close()
+	books := make([]models.Book, 0)
+	for rows.Next() {
+		book, err := scanSQLiteBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return books, nil
+}
+
+type sqliteRow interface {
+	Scan(dest ...any) error
+}
+
+func scanSQLiteBook(row sqliteRow) (models.Book, error) {
+	var (
+		book    models.Book
+		created int64
+	)
+	if err := row.Scan(&book.BID, &book.Label, &book.Author, &book.Deleted, &book.UserUID, &created, &book.Version); err != nil {
+		return models.Book{}, err
+	}
+	book.CreatedAt = time.UnixMicro(created).UTC()
+	return book, nil
+}
+
+func scanSQLiteAPIKey(row sqliteRow) (models.APIKey, error) {
+	var (
+		key     models.APIKey
+		scopes  string
+		expires sql.NullInt64
+		created int64
+	)
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &key.OwnerUID, &scopes,
+		&expires, &key.Revoked, &created)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if err = json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return models.APIKey{}, fmt.Errorf("invalid scopes of api key %s: %w", key.ID, err)
+	}
+	if expires.Valid {
+		t := time.UnixMicro(expires.Int64).UTC()
+		key.ExpiresAt = &t
+	}
+	key.CreatedAt = time.UnixMicro(created).UTC()
+	return key, nil
+}
@@ -1,25 +1,47 @@
 package storage
 
-import (
-	"errors"
-
-	errMess "github.com/Rustam2595/library_service/internal/domain/errors"
-)
+import errMess "github.com/Rustam2595/library_service/internal/domain/errors"
+
+// Контракт хранилища. Все реализации (MemStorage, Repository) обязаны его соблюдать,
+// это проверяет общий набор тестов storagetest.Run:
+//   - поиск одной записи по ключу возвращает ErrUserNotFound, ErrBookNotFound или ErrAPIKeyNotFound,
+//     если записи нет или она помечена удалённой; удалённая книга по id отдаёт ErrBookWasDeleted;
+//   - изменение и удаление отсутствующей или уже удалённой записи возвращает те же ошибки "не найдено";
+//   - списки никогда не возвращают ошибку из-за пустоты: результат - пустой срез, не nil;
+//   - пользователи упорядочены по email, книги - по времени создания и id, API-ключи - по времени создания;
+//   - запись с версией: новая запись получает версию 1, каждое изменение, видимое клиенту, увеличивает её;
+//     изменение с ожидаемой версией, отличной от текущей, - ErrVersionMismatch, 0 - без проверки версии;
+//   - изменение книги сохраняет её прежнюю версию в истории; история упорядочена по версии
+//     и вычищается вместе с книгой;
+//   - занятый email при создании или изменении пользователя - ErrEmailTaken;
+//   - прочие ошибки (сеть, таймауты, БД) возвращаются как есть и не маскируются под "не найдено".
 
 // ErrInvalidAuthData возвращается, когда переданы некорректные учётные данные (логин/пароль).
-var ErrInvalidAuthData = errors.New(errMess.InvalidAuthDataError)
+var ErrInvalidAuthData = errMess.New(errMess.CodeInvalidAuthData, errMess.InvalidAuthDataError)
 
 // ErrUserNotFound сигнализирует, что пользователь с указанными параметрами не найден.
-var ErrUserNotFound = errors.New(errMess.UserNotFoundError)
-
-// ErrUserListEmpty означает, что в хранилище отсутствуют какие‑либо пользователи.
-var ErrUserListEmpty = errors.New(errMess.UserListEmptyError)
+var ErrUserNotFound = errMess.New(errMess.CodeUserNotFound, errMess.UserNotFoundError)
 
 // ErrBookNotFound возвращается, когда книга с указанным идентификатором не найдена.
-var ErrBookNotFound = errors.New(errMess.BookNotFoundError)
-
-// ErrBooksListEmpty указывает, что в хранилище нет ни одной книги.
-var ErrBooksListEmpty = errors.New(errMess.BooksListEmptyError)
+var ErrBookNotFound = errMess.New(errMess.CodeBookNotFound, errMess.BookNotFoundError)
 
 // ErrBookWasDeleted означает, что запрошенная книга была ранее удалена и недоступна.
-var ErrBookWasDeleted = errors.New(errMess.BookWasDeletedError)
+var ErrBookWasDeleted = errMess.New(errMess.CodeBookDeleted, errMess.BookWasDeletedError)
+
+// ErrRecoveryCodeInvalid возвращается, когда код восстановления не найден или уже был использован.
+var ErrRecoveryCodeInvalid = errMess.New(errMess.CodeRecoveryCode, errMess.RecoveryCodeInvalidError)
+
+// ErrTOTPNotEnrolled означает, что у пользователя нет секрета TOTP.
+var ErrTOTPNotEnrolled = errMess.New(errMess.CodeTOTPNotEnrolled, errMess.TOTPNotEnrolledError)
+
+// ErrAPIKeyNotFound возвращается, когда API-ключ не найден.
+var ErrAPIKeyNotFound = errMess.New(errMess.CodeAPIKeyNotFound, errMess.APIKeyNotFoundError)
+
+// ErrEmailTaken означает, что адрес электронной почты уже принадлежит другому пользователю.
+var ErrEmailTaken = errMess.New(errMess.CodeEmailTaken, errMess.EmailTakenError)
+
+// ErrEmailTokenInvalid возвращается, когда код подтверждения почты неверен или просрочен.
+var ErrEmailTokenInvalid = errMess.New(errMess.CodeEmailTokenInvalid, errMess.EmailTokenInvalidError)
+
+// ErrVersionMismatch означает, что запись изменили после того, как клиент прочитал её версию.
+var ErrVersionMismatch = errMess.New(errMess.CodeVersionMismatch, errMess.VersionMismatchError)
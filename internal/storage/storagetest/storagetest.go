@@ -0,0 +1,915 @@
+// Package storagetest - общий набор поведенческих тестов для реализаций server.Storage.
+// Новое хранилище подключается одной функцией:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) server.Storage { return newEmptyStore(t) })
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/domain/models"
+	"github.com/Rustam2595/library_service/internal/server"
+	"github.com/Rustam2595/library_service/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory возвращает пустое хранилище. Вызывается перед каждым случаем.
+type Factory func(t *testing.T) server.Storage
+
+const testPass = "qwerty1234"
+
+// Run проверяет хранилище на соответствие контракту из пакета storage:
+// ошибки, CRUD, версии записей, историю изменений книг, мягкое удаление, вычистку удалённых записей, порядок списков и параллельный доступ.
+func Run(t *testing.T, newStore Factory) {
+	t.Run("errors", func(t *testing.T) { testErrors(t, newStore) })
+	t.Run("users", func(t *testing.T) { testUsers(t, newStore) })
+	t.Run("books", func(t *testing.T) { testBooks(t, newStore) })
+	t.Run("api keys", func(t *testing.T) { testAPIKeys(t, newStore) })
+	t.Run("versions", func(t *testing.T) { testVersions(t, newStore) })
+	t.Run("history", func(t *testing.T) { testHistory(t, newStore) })
+	t.Run("soft delete", func(t *testing.T) { testSoftDelete(t, newStore) })
+	t.Run("purge", func(t *testing.T) { testPurge(t, newStore) })
+	t.Run("ordering", func(t *testing.T) { testOrdering(t, newStore) })
+	t.Run("concurrency", func(t *testing.T) { testConcurrency(t, newStore) })
+}
+
+func testErrors(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	missing := uuid.NewString()
+	testCases := []struct {
+		name string
+		run  func(t *testing.T, s server.Storage) error
+		want error
+	}{
+		{
+			name: "Test GetUserByID() func; Case 1: missing user",
+			run: func(t *testing.T, s server.Storage) error {
+				_, err := s.GetUserByID(ctx, missing)
+				return err
+			},
+			want: storage.ErrUserNotFound,
+		},
+		{
+			name: "Test GetUserByEmail() func; Case 2: missing user",
+			run: func(t *testing.T, s server.Storage) error {
+				_, err := s.GetUserByEmail(ctx, "nobody@ya.ru")
+				return err
+			},
+			want: storage.ErrUserNotFound,
+		},
+		{
+			name: "Test ValidateUser() func; Case 3: unknown email",
+			run: func(t *testing.T, s server.Storage) error {
+				_, err := s.ValidateUser(ctx, models.User{Email: "nobody@ya.ru", Pass: testPass})
+				return err
+			},
+			want: storage.ErrUserNotFound,
+		},
+		{
+			name: "Test ValidateUser() func; Case 4: wrong password",
+			run: func(t *testing.T, s server.Storage) error {
+				SaveUser(t, s, "user@ya.ru")
+				_, err := s.ValidateUser(ctx, models.User{Email: "user@ya.ru", Pass: "wrong"})
+				return err
+			},
+			want: storage.ErrInvalidAuthData,
+		},
+		{
+			name: "Test SaveUser() func; Case 5: email taken",
+			run: func(t *testing.T, s server.Storage) error {
+				SaveUser(t, s, "user@ya.ru")
+				_, err := s.SaveUser(ctx, models.User{Name: "Other", Email: "user@ya.ru", Pass: testPass})
+				return err
+			},
+			want: storage.ErrEmailTaken,
+		},
+		{
+			name: "Test UpdateUser() func; Case 6: missing user",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.UpdateUser(ctx, missing, models.User{Name: "Name", Email: "user@ya.ru"})
+			},
+			want: storage.ErrUserNotFound,
+		},
+		{
+			name: "Test UpdateUser() func; Case 7: email taken",
+			run: func(t *testing.T, s server.Storage) error {
+				SaveUser(t, s, "first@ya.ru")
+				uid := SaveUser(t, s, "second@ya.ru")
+				return s.UpdateUser(ctx, uid, models.User{Name: "Name", Email: "first@ya.ru"})
+			},
+			want: storage.ErrEmailTaken,
+		},
+		{
+			name: "Test DeleteUser() func; Case 8: missing user",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.DeleteUser(ctx, missing, 0)
+			},
+			want: storage.ErrUserNotFound,
+		},
+		{
+			name: "Test SetTOTPSecret() func; Case 9: missing user",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.SetTOTPSecret(ctx, missing, "secret")
+			},
+			want: storage.ErrUserNotFound,
+		},
+		{
+			name: "Test EnableTOTP() func; Case 10: not enrolled",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.EnableTOTP(ctx, SaveUser(t, s, "user@ya.ru"), []string{"hash"})
+			},
+			want: storage.ErrTOTPNotEnrolled,
+		},
+		{
+			name: "Test UseRecoveryCode() func; Case 11: unknown code",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.UseRecoveryCode(ctx, SaveUser(t, s, "user@ya.ru"), "hash")
+			},
+			want: storage.ErrRecoveryCodeInvalid,
+		},
+		{
+			name: "Test SetPendingEmail() func; Case 12: missing user",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.SetPendingEmail(ctx, missing, "new@ya.ru", "hash", time.Now().Add(time.Hour))
+			},
+			want: storage.ErrUserNotFound,
+		},
+		{
+			name: "Test ConfirmEmail() func; Case 13: wrong token",
+			run: func(t *testing.T, s server.Storage) error {
+				uid := SaveUser(t, s, "user@ya.ru")
+				require.NoError(t, s.SetPendingEmail(ctx, uid, "new@ya.ru", "hash", time.Now().Add(time.Hour)))
+				_, err := s.ConfirmEmail(ctx, uid, "other")
+				return err
+			},
+			want: storage.ErrEmailTokenInvalid,
+		},
+		{
+			name: "Test ConfirmEmail() func; Case 14: expired token",
+			run: func(t *testing.T, s server.Storage) error {
+				uid := SaveUser(t, s, "user@ya.ru")
+				require.NoError(t, s.SetPendingEmail(ctx, uid, "new@ya.ru", "hash", time.Now().Add(-time.Hour)))
+				_, err := s.ConfirmEmail(ctx, uid, "hash")
+				return err
+			},
+			want: storage.ErrEmailTokenInvalid,
+		},
+		{
+			name: "Test EraseUser() func; Case 15: missing user",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.EraseUser(ctx, missing)
+			},
+			want: storage.ErrUserNotFound,
+		},
+		{
+			name: "Test GetBookByID() func; Case 16: missing book",
+			run: func(t *testing.T, s server.Storage) error {
+				_, err := s.GetBookByID(ctx, missing)
+				return err
+			},
+			want: storage.ErrBookNotFound,
+		},
+		{
+			name: "Test DeleteBook() func; Case 17: missing book",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.DeleteBook(ctx, missing, 0)
+			},
+			want: storage.ErrBookNotFound,
+		},
+		{
+			name: "Test GetAPIKeyByID() func; Case 18: missing key",
+			run: func(t *testing.T, s server.Storage) error {
+				_, err := s.GetAPIKeyByID(ctx, missing)
+				return err
+			},
+			want: storage.ErrAPIKeyNotFound,
+		},
+		{
+			name: "Test GetAPIKeyByHash() func; Case 19: missing key",
+			run: func(t *testing.T, s server.Storage) error {
+				_, err := s.GetAPIKeyByHash(ctx, "hash")
+				return err
+			},
+			want: storage.ErrAPIKeyNotFound,
+		},
+		{
+			name: "Test RevokeAPIKey() func; Case 20: missing key",
+			run: func(t *testing.T, s server.Storage) error {
+				return s.RevokeAPIKey(ctx, missing)
+			},
+			want: storage.ErrAPIKeyNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorIs(t, tc.run(t, newStore(t)), tc.want)
+		})
+	}
+
+	t.Run("Test lists func; Case 21: empty lists are not errors", func(t *testing.T) {
+		s := newStore(t)
+		users, err := s.GetUsers(ctx)
+		require.NoError(t, err)
+		assert.NotNil(t, users)
+		assert.Empty(t, users)
+		books, err := s.GetBooks(ctx)
+		require.NoError(t, err)
+		assert.NotNil(t, books)
+		assert.Empty(t, books)
+		books, err = s.GetBookByUID(ctx, missing)
+		require.NoError(t, err)
+		assert.NotNil(t, books)
+		assert.Empty(t, books)
+		keys, err := s.GetAPIKeysByOwner(ctx, missing)
+		require.NoError(t, err)
+		assert.NotNil(t, keys)
+		assert.Empty(t, keys)
+	})
+}
+
+func testUsers(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Test SaveUser() func; Case 1: stored with defaults", func(t *testing.T) {
+		s := newStore(t)
+		uid, err := s.SaveUser(ctx, models.User{Name: "Sergei", Email: "user@ya.ru", Pass: testPass, Role: models.RoleAdmin})
+		require.NoError(t, err)
+		user, err := s.GetUserByID(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, uid, user.UID)
+		assert.Equal(t, "Sergei", user.Name)
+		assert.Equal(t, "user@ya.ru", user.Email)
+		assert.Equal(t, models.RoleMember, user.Role)
+		assert.NotEqual(t, testPass, user.Pass)
+		assert.False(t, user.DeletedUser)
+		byEmail, err := s.GetUserByEmail(ctx, "user@ya.ru")
+		require.NoError(t, err)
+		assert.Equal(t, user, byEmail)
+		validated, err := s.ValidateUser(ctx, models.User{Email: "user@ya.ru", Pass: testPass})
+		require.NoError(t, err)
+		assert.Equal(t, uid, validated)
+	})
+
+	t.Run("Test UpdateUser() func; Case 2: empty password is kept", func(t *testing.T) {
+		s := newStore(t)
+		uid := SaveUser(t, s, "user@ya.ru")
+		require.NoError(t, s.UpdateUser(ctx, uid, models.User{Name: "Ivan", Email: "ivan@ya.ru"}))
+		user, err := s.GetUserByID(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, "Ivan", user.Name)
+		assert.Equal(t, "ivan@ya.ru", user.Email)
+		_, err = s.ValidateUser(ctx, models.User{Email: "ivan@ya.ru", Pass: testPass})
+		assert.NoError(t, err)
+		require.NoError(t, s.UpdateUser(ctx, uid, models.User{Name: "Ivan", Email: "ivan@ya.ru", Pass: "newpass"}))
+		_, err = s.ValidateUser(ctx, models.User{Email: "ivan@ya.ru", Pass: "newpass"})
+		assert.NoError(t, err)
+		_, err = s.GetUserByEmail(ctx, "user@ya.ru")
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+		// старый адрес освобождается сразу
+		SaveUser(t, s, "user@ya.ru")
+	})
+
+	t.Run("Test EnableTOTP() func; Case 3: recovery codes are single use", func(t *testing.T) {
+		s := newStore(t)
+		uid := SaveUser(t, s, "user@ya.ru")
+		require.NoError(t, s.SetTOTPSecret(ctx, uid, "secret"))
+		user, err := s.GetUserByID(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", user.TOTPSecret)
+		assert.False(t, user.TOTPEnabled)
+		require.NoError(t, s.EnableTOTP(ctx, uid, []string{"a", "b"}))
+		user, err = s.GetUserByID(ctx, uid)
+		require.NoError(t, err)
+		assert.True(t, user.TOTPEnabled)
+		require.NoError(t, s.UseRecoveryCode(ctx, uid, "a"))
+		assert.ErrorIs(t, s.UseRecoveryCode(ctx, uid, "a"), storage.ErrRecoveryCodeInvalid)
+		assert.NoError(t, s.UseRecoveryCode(ctx, uid, "b"))
+	})
+
+	t.Run("Test ConfirmEmail() func; Case 4: pending email applied once", func(t *testing.T) {
+		s := newStore(t)
+		uid := SaveUser(t, s, "user@ya.ru")
+		require.NoError(t, s.SetPendingEmail(ctx, uid, "new@ya.ru", "hash", time.Now().Add(time.Hour)))
+		email, err := s.ConfirmEmail(ctx, uid, "hash")
+		require.NoError(t, err)
+		assert.Equal(t, "new@ya.ru", email)
+		user, err := s.GetUserByEmail(ctx, "new@ya.ru")
+		require.NoError(t, err)
+		assert.Equal(t, uid, user.UID)
+		_, err = s.ConfirmEmail(ctx, uid, "hash")
+		assert.ErrorIs(t, err, storage.ErrEmailTokenInvalid)
+	})
+
+	t.Run("Test EraseUser() func; Case 5: personal data removed", func(t *testing.T) {
+		s := newStore(t)
+		uid := SaveUser(t, s, "user@ya.ru")
+		SaveAPIKey(t, s, uid, "hash")
+		bid := SaveBook(t, s, uid, "Label")
+		require.NoError(t, s.EraseUser(ctx, uid))
+		_, err := s.GetUserByID(ctx, uid)
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+		_, err = s.GetUserByEmail(ctx, "user@ya.ru")
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+		_, err = s.GetAPIKeyByHash(ctx, "hash")
+		assert.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+		_, err = s.GetBookByID(ctx, bid)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, s.EraseUser(ctx, uid), storage.ErrUserNotFound)
+	})
+}
+
+func testBooks(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Test SaveBook() func; Case 1: book is readable", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		started := time.Now().Add(-time.Second)
+		bid := SaveBook(t, s, owner, "Label")
+		book, err := s.GetBookByID(ctx, bid)
+		require.NoError(t, err)
+		assert.Equal(t, bid, book.BID)
+		assert.Equal(t, "Label", book.Label)
+		assert.Equal(t, "Author", book.Author)
+		assert.Equal(t, owner, book.UserUID)
+		assert.False(t, book.Deleted)
+		assert.True(t, book.CreatedAt.After(started))
+		books, err := s.GetBooks(ctx)
+		require.NoError(t, err)
+		require.Len(t, books, 1)
+		assert.Equal(t, bid, books[0].BID)
+	})
+
+	t.Run("Test GetBookByUID() func; Case 2: only owner's books", func(t *testing.T) {
+		s := newStore(t)
+		first := SaveUser(t, s, "first@ya.ru")
+		second := SaveUser(t, s, "second@ya.ru")
+		bid := SaveBook(t, s, first, "First")
+		SaveBook(t, s, second, "Second")
+		books, err := s.GetBookByUID(ctx, first)
+		require.NoError(t, err)
+		require.Len(t, books, 1)
+		assert.Equal(t, bid, books[0].BID)
+		assert.Equal(t, first, books[0].UserUID)
+	})
+
+	t.Run("Test SaveBooks() func; Case 3: batch keeps its order", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		SaveBook(t, s, owner, "Before")
+		batch := make([]models.Book, 0, 50)
+		want := []string{"Before"}
+		for i := range 50 {
+			label := fmt.Sprintf("Imported %02d", i)
+			batch = append(batch, models.Book{Label: label, Author: "Author", UserUID: owner})
+			want = append(want, label)
+		}
+		require.NoError(t, s.SaveBooks(ctx, batch))
+		require.NoError(t, s.SaveBooks(ctx, nil))
+		books, err := s.GetBookByUID(ctx, owner)
+		require.NoError(t, err)
+		assert.Equal(t, want, labels(books))
+		for _, book := range books {
+			assert.NotEmpty(t, book.BID)
+			assert.Equal(t, int64(1), book.Version)
+			assert.False(t, book.Deleted)
+		}
+	})
+
+	t.Run("Test ExportBooks() func; Case 4: same books as GetBooks across batches", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		batch := make([]models.Book, 1201)
+		for i := range batch {
+			batch[i] = models.Book{Label: fmt.Sprintf("Book %04d", i), Author: "Author", UserUID: owner}
+		}
+		require.NoError(t, s.SaveBooks(ctx, batch))
+		require.NoError(t, s.DeleteBook(ctx, SaveBook(t, s, owner, "Deleted"), 0))
+		want, err := s.GetBooks(ctx)
+		require.NoError(t, err)
+		require.Len(t, want, len(batch))
+		got := make([]models.Book, 0, len(want))
+		require.NoError(t, s.ExportBooks(ctx, func(book models.Book) error {
+			got = append(got, book)
+			return nil
+		}))
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("Test ExportBooks() func; Case 5: callback error stops export", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		SaveBook(t, s, owner, "First")
+		SaveBook(t, s, owner, "Second")
+		stop := errors.New("client gone")
+		calls := 0
+		err := s.ExportBooks(ctx, func(models.Book) error {
+			calls++
+			return stop
+		})
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, 1, calls)
+		// прерванная выгрузка не оставляет хранилище занятым
+		require.NoError(t, s.ExportBooks(ctx, func(models.Book) error { return nil }))
+	})
+}
+
+func testAPIKeys(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Test SaveAPIKey() func; Case 1: lookup and revoke", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		key := SaveAPIKey(t, s, owner, "hash")
+		byID, err := s.GetAPIKeyByID(ctx, key.ID)
+		require.NoError(t, err)
+		assertAPIKey(t, key, byID)
+		byHash, err := s.GetAPIKeyByHash(ctx, "hash")
+		require.NoError(t, err)
+		assertAPIKey(t, key, byHash)
+		keys, err := s.GetAPIKeysByOwner(ctx, owner)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assertAPIKey(t, key, keys[0])
+		require.NoError(t, s.RevokeAPIKey(ctx, key.ID))
+		revoked, err := s.GetAPIKeyByID(ctx, key.ID)
+		require.NoError(t, err)
+		assert.True(t, revoked.Revoked)
+	})
+}
+
+func testVersions(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Test UpdateUser() func; Case 1: version grows on every change", func(t *testing.T) {
+		s := newStore(t)
+		uid := SaveUser(t, s, "user@ya.ru")
+		user, err := s.GetUserByID(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), user.Version)
+		require.NoError(t, s.UpdateUser(ctx, uid, models.User{Name: "Ivan", Email: "user@ya.ru", Version: 1}))
+		require.NoError(t, s.UpdateUser(ctx, uid, models.User{Name: "Petr", Email: "user@ya.ru"}))
+		require.NoError(t, s.SetTOTPSecret(ctx, uid, "secret"))
+		user, err = s.GetUserByID(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), user.Version)
+		// отложенная смена почты не видна клиенту и версию не меняет
+		require.NoError(t, s.SetPendingEmail(ctx, uid, "new@ya.ru", "hash", time.Now().Add(time.Hour)))
+		user, err = s.GetUserByID(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), user.Version)
+	})
+
+	t.Run("Test UpdateUser() func; Case 2: stale version is rejected", func(t *testing.T) {
+		s := newStore(t)
+		uid := SaveUser(t, s, "user@ya.ru")
+		require.NoError(t, s.UpdateUser(ctx, uid, models.User{Name: "Ivan", Email: "user@ya.ru", Version: 1}))
+		err := s.UpdateUser(ctx, uid, models.User{Name: "Petr", Email: "user@ya.ru", Version: 1})
+		assert.ErrorIs(t, err, storage.ErrVersionMismatch)
+		user, err := s.GetUserByID(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, "Ivan", user.Name)
+		assert.Equal(t, int64(2), user.Version)
+		assert.ErrorIs(t, s.DeleteUser(ctx, uid, 1), storage.ErrVersionMismatch)
+		require.NoError(t, s.DeleteUser(ctx, uid, 2))
+		// удалённая запись - "не найдено", даже с устаревшей версией
+		assert.ErrorIs(t, s.DeleteUser(ctx, uid, 2), storage.ErrUserNotFound)
+		assert.ErrorIs(t, s.UpdateUser(ctx, uid, models.User{Name: "Petr", Email: "user@ya.ru", Version: 2}), storage.ErrUserNotFound)
+	})
+
+	t.Run("Test DeleteBook() func; Case 3: stale version is rejected", func(t *testing.T) {
+		s := newStore(t)
+		bid := SaveBook(t, s, SaveUser(t, s, "user@ya.ru"), "Label")
+		book, err := s.GetBookByID(ctx, bid)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), book.Version)
+		books, err := s.GetBooks(ctx)
+		require.NoError(t, err)
+		require.Len(t, books, 1)
+		assert.Equal(t, int64(1), books[0].Version)
+		assert.ErrorIs(t, s.DeleteBook(ctx, bid, 2), storage.ErrVersionMismatch)
+		require.NoError(t, s.DeleteBook(ctx, bid, 1))
+		assert.ErrorIs(t, s.DeleteBook(ctx, bid, 1), storage.ErrBookNotFound)
+	})
+}
+
+func testHistory(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Test UpdateBook() func; Case 1: previous versions are kept", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "owner@ya.ru")
+		editor := SaveUser(t, s, "editor@ya.ru")
+		bid := SaveBook(t, s, owner, "Dune")
+		history, err := s.GetBookHistory(ctx, bid)
+		require.NoError(t, err)
+		assert.Empty(t, history)
+
+		book, err := s.UpdateBook(ctx, models.Book{BID: bid, Label: "Dune Messiah", Author: "Herbert", Version: 1}, editor)
+		require.NoError(t, err)
+		assert.Equal(t, "Dune Messiah", book.Label)
+		assert.Equal(t, "Herbert", book.Author)
+		assert.Equal(t, owner, book.UserUID)
+		assert.Equal(t, int64(2), book.Version)
+		_, err = s.UpdateBook(ctx, models.Book{BID: bid, Label: "Children of Dune", Author: "Herbert"}, owner)
+		require.NoError(t, err)
+		stored, err := s.GetBookByID(ctx, bid)
+		require.NoError(t, err)
+		assert.Equal(t, "Children of Dune", stored.Label)
+		assert.Equal(t, int64(3), stored.Version)
+
+		history, err = s.GetBookHistory(ctx, bid)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, []string{"Dune", "Dune Messiah"}, []string{history[0].Label, history[1].Label})
+		assert.Equal(t, []string{"Author", "Herbert"}, []string{history[0].Author, history[1].Author})
+		assert.Equal(t, []int64{1, 2}, []int64{history[0].Version, history[1].Version})
+		assert.Equal(t, []string{editor, owner}, []string{history[0].EditedBy, history[1].EditedBy})
+		for _, revision := range history {
+			assert.Equal(t, bid, revision.BID)
+			assert.WithinDuration(t, time.Now(), revision.EditedAt, time.Minute)
+		}
+	})
+
+	t.Run("Test UpdateBook() func; Case 2: stale version changes nothing", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "owner@ya.ru")
+		bid := SaveBook(t, s, owner, "Dune")
+		_, err := s.UpdateBook(ctx, models.Book{BID: bid, Label: "Other", Author: "Other", Version: 2}, owner)
+		assert.ErrorIs(t, err, storage.ErrVersionMismatch)
+		book, err := s.GetBookByID(ctx, bid)
+		require.NoError(t, err)
+		assert.Equal(t, "Dune", book.Label)
+		assert.Equal(t, int64(1), book.Version)
+		history, err := s.GetBookHistory(ctx, bid)
+		require.NoError(t, err)
+		assert.Empty(t, history)
+	})
+
+	t.Run("Test GetBookHistory() func; Case 3: missing and deleted books", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "owner@ya.ru")
+		missing := uuid.NewString()
+		_, err := s.UpdateBook(ctx, models.Book{BID: missing, Label: "Dune", Author: "Herbert"}, owner)
+		assert.ErrorIs(t, err, storage.ErrBookNotFound)
+		_, err = s.GetBookHistory(ctx, missing)
+		assert.ErrorIs(t, err, storage.ErrBookNotFound)
+
+		bid := SaveBook(t, s, owner, "Dune")
+		_, err = s.UpdateBook(ctx, models.Book{BID: bid, Label: "Dune Messiah", Author: "Herbert"}, owner)
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteBook(ctx, bid, 0))
+		_, err = s.UpdateBook(ctx, models.Book{BID: bid, Label: "Dune", Author: "Herbert"}, owner)
+		assert.ErrorIs(t, err, storage.ErrBookNotFound)
+		_, err = s.GetBookHistory(ctx, bid)
+		assert.ErrorIs(t, err, storage.ErrBookWasDeleted)
+		// вычистка удаляет книгу вместе с историей
+		require.NoError(t, s.DeleteBooks(ctx))
+		_, err = s.GetBookHistory(ctx, bid)
+		assert.ErrorIs(t, err, storage.ErrBookNotFound)
+	})
+}
+
+func testSoftDelete(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Test DeleteUser() func; Case 1: user is hidden", func(t *testing.T) {
+		s := newStore(t)
+		keep := SaveUser(t, s, "keep@ya.ru")
+		gone := SaveUser(t, s, "gone@ya.ru")
+		require.NoError(t, s.DeleteUser(ctx, gone, 0))
+		_, err := s.GetUserByID(ctx, gone)
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+		_, err = s.GetUserByEmail(ctx, "gone@ya.ru")
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+		_, err = s.ValidateUser(ctx, models.User{Email: "gone@ya.ru", Pass: testPass})
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+		assert.ErrorIs(t, s.UpdateUser(ctx, gone, models.User{Name: "Name", Email: "gone@ya.ru"}), storage.ErrUserNotFound)
+		assert.ErrorIs(t, s.DeleteUser(ctx, gone, 0), storage.ErrUserNotFound)
+		users, err := s.GetUsers(ctx)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, keep, users[0].UID)
+	})
+
+	t.Run("Test DeleteUser() func; Case 2: email stays taken until purge", func(t *testing.T) {
+		s := newStore(t)
+		require.NoError(t, s.DeleteUser(ctx, SaveUser(t, s, "user@ya.ru"), 0))
+		_, err := s.SaveUser(ctx, models.User{Name: "Other", Email: "user@ya.ru", Pass: testPass})
+		assert.ErrorIs(t, err, storage.ErrEmailTaken)
+	})
+
+	t.Run("Test DeleteBook() func; Case 3: book is hidden", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		keep := SaveBook(t, s, owner, "Keep")
+		gone := SaveBook(t, s, owner, "Gone")
+		require.NoError(t, s.DeleteBook(ctx, gone, 0))
+		_, err := s.GetBookByID(ctx, gone)
+		assert.ErrorIs(t, err, storage.ErrBookWasDeleted)
+		assert.ErrorIs(t, s.DeleteBook(ctx, gone, 0), storage.ErrBookNotFound)
+		books, err := s.GetBooks(ctx)
+		require.NoError(t, err)
+		require.Len(t, books, 1)
+		assert.Equal(t, keep, books[0].BID)
+		books, err = s.GetBookByUID(ctx, owner)
+		require.NoError(t, err)
+		require.Len(t, books, 1)
+		assert.Equal(t, keep, books[0].BID)
+	})
+}
+
+func testPurge(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Test DeleteBooks() func; Case 1: deleted books are removed", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		keep := SaveBook(t, s, owner, "Keep")
+		gone := SaveBook(t, s, owner, "Gone")
+		require.NoError(t, s.DeleteBook(ctx, gone, 0))
+		require.NoError(t, s.DeleteBooks(ctx))
+		_, err := s.GetBookByID(ctx, gone)
+		assert.ErrorIs(t, err, storage.ErrBookNotFound)
+		_, err = s.GetBookByID(ctx, keep)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Test DeleteUsers() func; Case 2: deleted users are removed, books are kept", func(t *testing.T) {
+		s := newStore(t)
+		keep := SaveUser(t, s, "keep@ya.ru")
+		gone := SaveUser(t, s, "gone@ya.ru")
+		bid := SaveBook(t, s, gone, "Orphan")
+		key := SaveAPIKey(t, s, gone, "hash")
+		require.NoError(t, s.DeleteUser(ctx, gone, 0))
+		require.NoError(t, s.DeleteUsers(ctx))
+		_, err := s.GetUserByID(ctx, keep)
+		assert.NoError(t, err)
+		_, err = s.GetAPIKeyByID(ctx, key.ID)
+		assert.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+		book, err := s.GetBookByID(ctx, bid)
+		require.NoError(t, err)
+		assert.Empty(t, book.UserUID)
+		_, err = s.SaveUser(ctx, models.User{Name: "Other", Email: "gone@ya.ru", Pass: testPass})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Test DeleteUsers() func; Case 3: nothing to purge", func(t *testing.T) {
+		s := newStore(t)
+		uid := SaveUser(t, s, "user@ya.ru")
+		require.NoError(t, s.DeleteUsers(ctx))
+		require.NoError(t, s.DeleteBooks(ctx))
+		_, err := s.GetUserByID(ctx, uid)
+		assert.NoError(t, err)
+	})
+}
+
+func testOrdering(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Test GetUsers() func; Case 1: ordered by email", func(t *testing.T) {
+		s := newStore(t)
+		for _, email := range []string{"c@ya.ru", "a@ya.ru", "b@ya.ru"} {
+			SaveUser(t, s, email)
+		}
+		users, err := s.GetUsers(ctx)
+		require.NoError(t, err)
+		emails := make([]string, 0, len(users))
+		for _, user := range users {
+			emails = append(emails, user.Email)
+		}
+		assert.Equal(t, []string{"a@ya.ru", "b@ya.ru", "c@ya.ru"}, emails)
+	})
+
+	t.Run("Test GetBooks() func; Case 2: ordered by creation time", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		want := []string{"Third", "First", "Second"}
+		for _, label := range want {
+			SaveBook(t, s, owner, label)
+			// created_at в БД хранится с точностью до микросекунд
+			time.Sleep(time.Millisecond)
+		}
+		books, err := s.GetBooks(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, labels(books))
+		books, err = s.GetBookByUID(ctx, owner)
+		require.NoError(t, err)
+		assert.Equal(t, want, labels(books))
+	})
+
+	t.Run("Test GetAPIKeysByOwner() func; Case 3: ordered by creation time", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		now := time.Now().Truncate(time.Millisecond)
+		for i, offset := range []time.Duration{2 * time.Hour, 0, time.Hour} {
+			key := newAPIKey(owner, fmt.Sprintf("hash-%d", i))
+			key.CreatedAt = now.Add(offset)
+			require.NoError(t, s.SaveAPIKey(ctx, key))
+		}
+		keys, err := s.GetAPIKeysByOwner(ctx, owner)
+		require.NoError(t, err)
+		hashes := make([]string, 0, len(keys))
+		for _, key := range keys {
+			hashes = append(hashes, key.Hash)
+		}
+		assert.Equal(t, []string{"hash-1", "hash-2", "hash-0"}, hashes)
+	})
+}
+
+func testConcurrency(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	const workers = 16
+
+	t.Run("Test SaveBook() func; Case 1: parallel writes and reads", func(t *testing.T) {
+		s := newStore(t)
+		owner := SaveUser(t, s, "user@ya.ru")
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*3)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := s.SaveBook(ctx, models.Book{Label: fmt.Sprintf("Book %d", i), Author: "Author", UserUID: owner}); err != nil {
+					errs <- err
+				}
+				if _, err := s.GetBooks(ctx); err != nil {
+					errs <- err
+				}
+				if _, err := s.GetBookByUID(ctx, owner); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+		books, err := s.GetBookByUID(ctx, owner)
+		require.NoError(t, err)
+		assert.Len(t, books, workers)
+	})
+
+	t.Run("Test SaveUser() func; Case 2: one email wins", func(t *testing.T) {
+		s := newStore(t)
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			saved int
+		)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.SaveUser(ctx, models.User{Name: "Sergei", Email: "user@ya.ru", Pass: testPass})
+				if err == nil {
+					mu.Lock()
+					saved++
+					mu.Unlock()
+					return
+				}
+				assert.ErrorIs(t, err, storage.ErrEmailTaken)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, saved)
+	})
+
+	t.Run("Test DeleteBook() func; Case 3: one delete wins", func(t *testing.T) {
+		s := newStore(t)
+		bid := SaveBook(t, s, SaveUser(t, s, "user@ya.ru"), "Label")
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			deleted int
+		)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := s.DeleteBook(ctx, bid, 0)
+				if err == nil {
+					mu.Lock()
+					deleted++
+					mu.Unlock()
+					return
+				}
+				assert.ErrorIs(t, err, storage.ErrBookNotFound)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, deleted)
+	})
+
+	t.Run("Test UpdateUser() func; Case 4: one update of a version wins", func(t *testing.T) {
+		s := newStore(t)
+		uid := SaveUser(t, s, "user@ya.ru")
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			updated int
+		)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := s.UpdateUser(ctx, uid, models.User{Name: fmt.Sprintf("Name %d", i), Email: "user@ya.ru", Version: 1})
+				if err == nil {
+					mu.Lock()
+					updated++
+					mu.Unlock()
+					return
+				}
+				assert.ErrorIs(t, err, storage.ErrVersionMismatch)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, updated)
+	})
+}
+
+// SaveUser сохраняет пользователя с паролем testPass и возвращает его uid.
+func SaveUser(t *testing.T, s server.Storage, email string) string {
+	t.Helper()
+	uid, err := s.SaveUser(context.Background(), models.User{Name: "Sergei", Email: email, Pass: testPass})
+	require.NoError(t, err)
+	return uid
+}
+
+// SaveBook сохраняет книгу и возвращает её id. SaveBook id не отдаёт, поэтому книга ищется среди книг владельца.
+func SaveBook(t *testing.T, s server.Storage, owner, label string) string {
+	t.Helper()
+	ctx := context.Background()
+	before, err := s.GetBookByUID(ctx, owner)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveBook(ctx, models.Book{Label: label, Author: "Author", UserUID: owner}))
+	after, err := s.GetBookByUID(ctx, owner)
+	require.NoError(t, err)
+	require.Len(t, after, len(before)+1)
+	known := make(map[string]bool, len(before))
+	for _, book := range before {
+		known[book.BID] = true
+	}
+	for _, book := range after {
+		if !known[book.BID] {
+			return book.BID
+		}
+	}
+	t.Fatal("saved book not found")
+	return ""
+}
+
+// SaveAPIKey сохраняет активный ключ владельца owner.
+func SaveAPIKey(t *testing.T, s server.Storage, owner, hash string) models.APIKey {
+	t.Helper()
+	key := newAPIKey(owner, hash)
+	require.NoError(t, s.SaveAPIKey(context.Background(), key))
+	return key
+}
+
+func newAPIKey(owner, hash string) models.APIKey {
+	// timestamp в БД без часового пояса и с точностью до микросекунд
+	expires := time.Now().UTC().Add(time.Hour).Truncate(time.Millisecond)
+	return models.APIKey{
+		ID:        uuid.NewString(),
+		Name:      "ci",
+		Prefix:    "lsk_0000",
+		Hash:      hash,
+		OwnerUID:  owner,
+		Scopes:    []string{models.ScopeBooksRead},
+		ExpiresAt: &expires,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+}
+
+func assertAPIKey(t *testing.T, want, got models.APIKey) {
+	t.Helper()
+	assert.Equal(t, want.ID, got.ID)
+	assert.Equal(t, want.Name, got.Name)
+	assert.Equal(t, want.Prefix, got.Prefix)
+	assert.Equal(t, want.Hash, got.Hash)
+	assert.Equal(t, want.OwnerUID, got.OwnerUID)
+	assert.Equal(t, want.Scopes, got.Scopes)
+	assert.Equal(t, want.Revoked, got.Revoked)
+	require.NotNil(t, got.ExpiresAt)
+	assert.True(t, want.ExpiresAt.Equal(*got.ExpiresAt))
+	assert.True(t, want.CreatedAt.Equal(got.CreatedAt))
+}
+
+func labels(books []models.Book) []string {
+	out := make([]string, 0, len(books))
+	for _, book := range books {
+		out = append(out, book.Label)
+	}
+	return out
+}
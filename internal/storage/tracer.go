@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rustam2595/library_service/internal/logger"
+	"github.com/jackc/pgx/v5"
+)
+
+// slowQueryTracer пишет в лог запросы, выполнявшиеся дольше порога, вместе с аргументами.
+// Открыто пишутся только аргументы столбцов из safeColumns, почта маскируется частично, остальное скрывается.
+type slowQueryTracer struct {
+	threshold time.Duration
+}
+
+type slowQueryKey struct{}
+
+type slowQueryStart struct {
+	at   time.Time
+	sql  string
+	args []any
+}
+
+func newSlowQueryTracer(threshold time.Duration) *slowQueryTracer {
+	return &slowQueryTracer{threshold: threshold}
+}
+
+func (t *slowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, slowQueryKey{}, slowQueryStart{at: time.Now(), sql: data.SQL, args: data.Args})
+}
+
+func (t *slowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(slowQueryKey{}).(slowQueryStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.at)
+	if elapsed < t.threshold {
+		return
+	}
+	zLog := logger.Named(logger.ModuleStorage)
+	zLog.Warn().
+		Str("sql", strings.Join(strings.Fields(start.sql), " ")).
+		Any("args", redactArgs(start.sql, start.args)).
+		Dur("duration", elapsed).
+		Err(data.Err).
+		Msg("slow query")
+}
+
+var (
+	// столбец = $n в WHERE и SET, в том числе внутри функций: pass = COALESCE(NULLIF($3, ''), pass)
+	argComparison = regexp.MustCompile(`(?i)(\w+)\s*(?:=|<>|!=)\s*(?:\w+\s*\(\s*)*\$(\d+)`)
+	// INSERT INTO t(a, b) VALUES($1, $2)
+	argInsert = regexp.MustCompile(`(?is)INSERT\s+INTO\s+\w+\s*\(([^)]*)\)\s*VALUES\s*\(([^)]*)\)`)
+
+	emailColumn = regexp.MustCompile(`(?i)^email$|_email$`)
+)
+
+// safeColumns - столбцы без персональных данных и секретов. Аргумент, который не удалось
+// сопоставить со столбцом (IN ($1), lower(email) = lower($1), INSERT ... SELECT), скрывается.
+var safeColumns = map[string]bool{
+	"id":         true,
+	"uid":        true,
+	"bid":        true,
+	"user_uid":   true,
+	"owner_uid":  true,
+	"edited_by":  true,
+	"version":    true,
+	"label":      true,
+	"author":     true,
+	"role":       true,
+	"created_at": true,
+	"edited_at":  true,
+	"delete":     true,
+}
+
+// argColumns сопоставляет номера параметров ($1, $2, ...) со столбцами, к которым они относятся.
+// Параметр, попавший в разные столбцы, остаётся без столбца.
+func argColumns(sql string) map[int]string {
+	columns := make(map[int]string)
+	set := func(n int, column string) {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if prev, ok := columns[n]; ok && prev != column {
+			column = ""
+		}
+		columns[n] = column
+	}
+	for _, m := range argComparison.FindAllStringSubmatch(sql, -1) {
+		if n, err := strconv.Atoi(m[2]); err == nil {
+			set(n, m[1])
+		}
+	}
+	for _, m := range argInsert.FindAllStringSubmatch(sql, -1) {
+		names := strings.Split(m[1], ",")
+		values := strings.Split(m[2], ",")
+		for i := 0; i < len(names) && i < len(values); i++ {
+			value := strings.TrimSpace(values[i])
+			if n, err := strconv.Atoi(strings.TrimPrefix(value, "$")); err == nil && strings.HasPrefix(value, "$") {
+				set(n, names[i])
+			}
+		}
+	}
+	return columns
+}
+
+// redactArgs возвращает копию аргументов запроса, безопасную для лога.
+func redactArgs(sql string, args []any) []any {
+	columns := argColumns(sql)
+	out := make([]any, len(args))
+	for i, arg := range args {
+		column := columns[i+1]
+		switch {
+		case safeColumns[column]:
+			out[i] = arg
+		case emailColumn.MatchString(column):
+			if s, ok := arg.(string); ok {
+				out[i] = logger.MaskEmail(s)
+				continue
+			}
+			out[i] = logger.MaskSecret("-")
+		default:
+			out[i] = logger.MaskSecret("-")
+		}
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/exaring/otelpgx"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactArgs(t *testing.T) {
+	type test struct {
+		name string
+		sql  string
+		args []any
+		want []any
+	}
+	tests := []test{
+		{
+			name: "Test redactArgs() func; Case 1: insert with column list",
+			sql:  "INSERT INTO Users(uid, name, email, pass) VALUES($1, $2, $3, $4)",
+			args: []any{"u1", "Ann", "ann@example.com", "$argon2id$hash"},
+			want: []any{"u1", "[REDACTED]", "a***@example.com", "[REDACTED]"},
+		},
+		{
+			name: "Test redactArgs() func; Case 2: parameter inside function call",
+			sql:  "UPDATE Users SET name = $1, email = $2, pass = COALESCE(NULLIF($3, ''), pass) WHERE uid = $4",
+			args: []any{"Ann", "ann@example.com", "$argon2id$hash", "u1"},
+			want: []any{"[REDACTED]", "a***@example.com", "[REDACTED]", "u1"},
+		},
+		{
+			name: "Test redactArgs() func; Case 3: multiline update with token hash",
+			sql: `UPDATE Users SET pending_email = $1, email_token_hash = $2, email_token_expires = $3
+		WHERE uid = $4 AND deleted_user = false`,
+			args: []any{"new@example.com", "abcdef", 42, "u1"},
+			want: []any{"n***@example.com", "[REDACTED]", "[REDACTED]", "u1"},
+		},
+		{
+			name: "Test redactArgs() func; Case 4: lookup by key hash",
+			sql:  "SELECT id FROM ApiKeys WHERE key_hash = $1",
+			args: []any{"deadbeef"},
+			want: []any{"[REDACTED]"},
+		},
+		{
+			name: "Test redactArgs() func; Case 5: insert without column list",
+			sql:  "INSERT INTO Books VALUES($1, $2, $3, $4, $5, $6)",
+			args: []any{"b1", "Dune", "Herbert", false, "u1", nil},
+			want: []any{"[REDACTED]", "[REDACTED]", "[REDACTED]", "[REDACTED]", "[REDACTED]", "[REDACTED]"},
+		},
+		{
+			name: "Test redactArgs() func; Case 6: safe columns",
+			sql:  "UPDATE Books SET label = $1, author = $2, version = version + 1 WHERE bid = $3 AND version = $4",
+			args: []any{"Dune", "Herbert", "b1", int64(3)},
+			want: []any{"Dune", "Herbert", "b1", int64(3)},
+		},
+		{
+			name: "Test redactArgs() func; Case 7: column inside function call",
+			sql:  "SELECT uid FROM Users WHERE lower(email) = lower($1) AND uid <> $2",
+			args: []any{"ann@example.com", "u1"},
+			want: []any{"[REDACTED]", "u1"},
+		},
+		{
+			name: "Test redactArgs() func; Case 8: IN list and ANY",
+			sql:  "SELECT uid FROM Users WHERE name IN ($1, $2) OR uid = ANY($3)",
+			args: []any{"Ann", "Bob", []string{"u1"}},
+			want: []any{"[REDACTED]", "[REDACTED]", []string{"u1"}},
+		},
+		{
+			name: "Test redactArgs() func; Case 9: insert from select",
+			sql:  "INSERT INTO Archive(uid, email) SELECT $1, $2 FROM Users WHERE pass = $3",
+			args: []any{"u1", "ann@example.com", "secret"},
+			want: []any{"[REDACTED]", "[REDACTED]", "[REDACTED]"},
+		},
+		{
+			name: "Test redactArgs() func; Case 10: one parameter in different columns",
+			sql:  "SELECT uid FROM Users WHERE uid = $1 OR name = $1",
+			args: []any{"Ann"},
+			want: []any{"[REDACTED]"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := redactArgs(tc.sql, tc.args)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPoolConfig(t *testing.T) {
+	cfg, err := poolConfig(downPrimary, RepoOptions{
+		MaxConns:         7,
+		MaxConnLifetime:  time.Minute,
+		StatementTimeout: 1500 * time.Millisecond,
+		SlowQuery:        time.Second,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), cfg.MaxConns)
+	assert.Equal(t, time.Minute, cfg.MaxConnLifetime)
+	assert.Equal(t, "1500", cfg.ConnConfig.RuntimeParams["statement_timeout"])
+	assert.IsType(t, &multitracer.Tracer{}, cfg.ConnConfig.Tracer)
+
+	// без порога остаётся только трассировка OpenTelemetry
+	cfg, err = poolConfig(downPrimary, RepoOptions{SlowQuery: -1})
+	require.NoError(t, err)
+	assert.NotContains(t, cfg.ConnConfig.RuntimeParams, "statement_timeout")
+	assert.IsType(t, &otelpgx.Tracer{}, cfg.ConnConfig.Tracer)
+}
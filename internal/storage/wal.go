@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// Запись журнала: заголовок из длины и CRC32-C полезной нагрузки (оба uint32, little endian),
+// затем JSON с номером записи и изменениями одного commit.
+const (
+	walHeaderSize = 8
+	// walMaxRecord ограничивает длину записи, чтобы мусор в заголовке не приводил к огромной аллокации
+	walMaxRecord = 64 << 20
+)
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord - одна запись журнала. Seq растёт монотонно и сохраняется в снимке:
+// при восстановлении записи, уже вошедшие в снимок, пропускаются.
+type walRecord struct {
+	Seq     uint64   `json:"seq"`
+	Changes []change `json:"changes"`
+}
+
+// wal - журнал изменений MemStorage, открытый на дозапись.
+type wal struct {
+	mu sync.Mutex
+	f  *os.File
+	// syncEach - fsync после каждой записи (SyncAlways)
+	syncEach bool
+	// dirty - есть записи, ещё не сброшенные на диск
+	dirty bool
+	seq   uint64
+}
+
+// openWAL открывает журнал и читает из него целые записи. Оборванная или повреждённая запись
+// в конце считается следствием сбоя при записи: файл обрезается до последней целой записи.
+func openWAL(path string, syncEach bool) (*wal, []walRecord, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, good, err := readWAL(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	if err = f.Truncate(good); err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("failed to truncate wal: %w", err)
+	}
+	if _, err = f.Seek(good, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	w := &wal{f: f, syncEach: syncEach}
+	if len(records) > 0 {
+		w.seq = records[len(records)-1].Seq
+	}
+	return w, records, nil
+}
+
+// readWAL читает записи с начала файла и возвращает их вместе со смещением конца последней целой записи.
+func readWAL(r io.Reader) ([]walRecord, int64, error) {
+	var records []walRecord
+	var good int64
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, good, nil
+			}
+			return nil, 0, err
+		}
+		size := binary.LittleEndian.Uint32(header[:4])
+		sum := binary.LittleEndian.Uint32(header[4:])
+		if size == 0 || size > walMaxRecord {
+			return records, good, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, good, nil
+			}
+			return nil, 0, err
+		}
+		if crc32.Checksum(payload, walTable) != sum {
+			return records, good, nil
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return records, good, nil
+		}
+		records = append(records, rec)
+		good += walHeaderSize + int64(size)
+	}
+}
+
+// append дописывает изменения одной записью. Если запись не удалась, хвост файла откатывается,
+// чтобы следующая запись не легла после оборванной.
+func (w *wal) append(changes []change) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	payload, err := json.Marshal(walRecord{Seq: w.seq + 1, Changes: changes})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(payload, walTable))
+	buf = append(buf, payload...)
+
+	offset, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = w.f.Write(buf); err != nil {
+		w.rewind(offset)
+		return err
+	}
+	if w.syncEach {
+		if err = w.f.Sync(); err != nil {
+			w.rewind(offset)
+			return err
+		}
+	} else {
+		w.dirty = true
+	}
+	w.seq++
+	return nil
+}
+
+// rewind отрезает недописанную запись.
+func (w *wal) rewind(offset int64) {
+	_ = w.f.Truncate(offset)
+	_, _ = w.f.Seek(offset, io.SeekStart)
+}
+
+// sync сбрасывает накопленные записи на диск (для SyncInterval).
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		return nil
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// reset очищает журнал после того, как его содержимое вошло в снимок.
+func (w *wal) reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+func (w *wal) lastSeq() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.f.Sync(); err != nil {
+		_ = w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestInit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		exporter string
+		endpoint string
+		wantErr  bool
+	}{
+		{name: "Test Init() func; Case 1: none", exporter: ExporterNone},
+		{name: "Test Init() func; Case 2: empty means none", exporter: ""},
+		{name: "Test Init() func; Case 3: stdout", exporter: ExporterStdout},
+		{name: "Test Init() func; Case 4: otlp", exporter: ExporterOTLP, endpoint: "http://localhost:4317"},
+		{name: "Test Init() func; Case 5: unknown exporter", exporter: "zipkin", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			shutdown, err := Init(context.Background(), tc.exporter, tc.endpoint, "library_service")
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, shutdown(context.Background()))
+		})
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	shutdown, err := Init(context.Background(), ExporterStdout, "", "library_service")
+	assert.NoError(t, err)
+	defer func() { _ = shutdown(context.Background()) }()
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+	assert.True(t, span.SpanContext().IsValid())
+
+	header := http.Header{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+	assert.Contains(t, header.Get("traceparent"), span.SpanContext().TraceID().String())
+}
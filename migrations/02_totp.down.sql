@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS RecoveryCodes;
+ALTER TABLE Users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE Users DROP COLUMN IF EXISTS totp_secret;
+ALTER TABLE Users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,11 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS totp_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes(
+    user_uid VARCHAR(36) NOT NULL,
+    code_hash TEXT NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (user_uid, code_hash),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_uid) REFERENCES Users(uid) ON DELETE CASCADE
+);
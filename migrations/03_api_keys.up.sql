@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS ApiKeys(
+    id VARCHAR(36) PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL,
+    owner_uid VARCHAR(36) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT NOW() NOT NULL,
+    CONSTRAINT fk_api_keys_user FOREIGN KEY (owner_uid) REFERENCES Users(uid) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_hash ON ApiKeys (key_hash);
+CREATE INDEX IF NOT EXISTS idx_api_keys_owner_uid ON ApiKeys (owner_uid);
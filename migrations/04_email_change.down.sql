@@ -0,0 +1,3 @@
+ALTER TABLE Users DROP COLUMN IF EXISTS email_token_expires;
+ALTER TABLE Users DROP COLUMN IF EXISTS email_token_hash;
+ALTER TABLE Users DROP COLUMN IF EXISTS pending_email;
@@ -0,0 +1,3 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS pending_email TEXT NOT NULL DEFAULT '';
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS email_token_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS email_token_expires TIMESTAMP;
@@ -0,0 +1,12 @@
+-- Книги стёртых пользователей - часть каталога: откат не удаляет их, а останавливается,
+-- пока им не назначат владельца.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM Books WHERE user_uid IS NULL) THEN
+        RAISE EXCEPTION 'books without owner exist, assign them to a user before rolling back';
+    END IF;
+END
+$$;
+ALTER TABLE Books DROP CONSTRAINT IF EXISTS fk_books_user;
+ALTER TABLE Books ALTER COLUMN user_uid SET NOT NULL;
+ALTER TABLE Books ADD CONSTRAINT fk_books_user FOREIGN KEY (user_uid) REFERENCES Users(uid) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+ALTER TABLE Books DROP CONSTRAINT IF EXISTS fk_books_user;
+ALTER TABLE Books ALTER COLUMN user_uid DROP NOT NULL;
+ALTER TABLE Books ADD CONSTRAINT fk_books_user FOREIGN KEY (user_uid) REFERENCES Users(uid) ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE Books DROP COLUMN IF EXISTS version;
+ALTER TABLE Users DROP COLUMN IF EXISTS version;
@@ -0,0 +1,3 @@
+-- Версия записи для оптимистичных блокировок: растёт при каждом изменении, видимом клиенту.
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE Books ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
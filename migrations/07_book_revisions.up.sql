@@ -0,0 +1,11 @@
+-- История изменений книг: по строке на каждую заменённую версию.
+CREATE TABLE IF NOT EXISTS BookRevisions(
+    bid VARCHAR(36) NOT NULL,
+    version BIGINT NOT NULL,
+    label TEXT NOT NULL,
+    author TEXT NOT NULL,
+    edited_by VARCHAR(36) NOT NULL,
+    edited_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (bid, version),
+    CONSTRAINT fk_book_revisions_book FOREIGN KEY (bid) REFERENCES Books(bid) ON DELETE CASCADE
+);
@@ -0,0 +1,28 @@
+// Package migrations встраивает SQL-миграции в бинарник, чтобы он не зависел от каталога на диске.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed *.sql
+var postgres embed.FS
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// Postgres возвращает миграции PostgreSQL.
+func Postgres() fs.FS {
+	return postgres
+}
+
+// SQLite возвращает миграции встроенной базы SQLite.
+func SQLite() fs.FS {
+	sub, err := fs.Sub(sqlite, "sqlite")
+	if err != nil {
+		// путь задан константой и проверен go:embed, ошибка здесь невозможна
+		panic(err)
+	}
+	return sub
+}
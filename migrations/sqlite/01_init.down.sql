@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS ApiKeys;
+DROP TABLE IF EXISTS RecoveryCodes;
+DROP TABLE IF EXISTS Books;
+DROP TABLE IF EXISTS Users;
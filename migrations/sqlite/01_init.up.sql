@@ -0,0 +1,50 @@
+-- Схема для встроенного SQLite. Соответствует миграциям PostgreSQL 01-05,
+-- время хранится в микросекундах Unix, scopes - JSON-массивом.
+CREATE TABLE IF NOT EXISTS Users (
+    uid TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+    pass TEXT NOT NULL,
+    deleted_user BOOLEAN NOT NULL DEFAULT false,
+    role TEXT NOT NULL DEFAULT 'member',
+    totp_secret TEXT NOT NULL DEFAULT '',
+    totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    pending_email TEXT NOT NULL DEFAULT '',
+    email_token_hash TEXT NOT NULL DEFAULT '',
+    email_token_expires INTEGER
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON Users (email);
+
+CREATE TABLE IF NOT EXISTS Books(
+    bid TEXT PRIMARY KEY,
+    label TEXT NOT NULL,
+    author TEXT NOT NULL,
+    deleted BOOLEAN NOT NULL DEFAULT false,
+    user_uid TEXT REFERENCES Users(uid) ON DELETE SET NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_books_user_uid ON Books (user_uid);
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes(
+    user_uid TEXT NOT NULL REFERENCES Users(uid) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (user_uid, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS ApiKeys(
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL,
+    owner_uid TEXT NOT NULL REFERENCES Users(uid) ON DELETE CASCADE,
+    scopes TEXT NOT NULL DEFAULT '[]',
+    expires_at INTEGER,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created_at INTEGER NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_hash ON ApiKeys (key_hash);
+CREATE INDEX IF NOT EXISTS idx_api_keys_owner_uid ON ApiKeys (owner_uid);
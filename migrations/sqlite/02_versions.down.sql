@@ -0,0 +1,2 @@
+ALTER TABLE Books DROP COLUMN version;
+ALTER TABLE Users DROP COLUMN version;
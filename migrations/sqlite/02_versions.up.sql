@@ -0,0 +1,3 @@
+-- Соответствует миграции PostgreSQL 06.
+ALTER TABLE Users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE Books ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
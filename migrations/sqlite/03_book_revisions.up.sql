@@ -0,0 +1,10 @@
+-- Соответствует миграции PostgreSQL 07.
+CREATE TABLE IF NOT EXISTS BookRevisions(
+    bid TEXT NOT NULL REFERENCES Books(bid) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    label TEXT NOT NULL,
+    author TEXT NOT NULL,
+    edited_by TEXT NOT NULL,
+    edited_at INTEGER NOT NULL,
+    PRIMARY KEY (bid, version)
+);
@@ -0,0 +1,83 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/Rustam2595/library_service/internal/gen/go (interfaces: AuthServiceClient)
+//
+// Generated by this command:
+//
+//	mockgen -destination=mocks/mock_auth.go -package=mocks github.com/Rustam2595/library_service/internal/gen/go AuthServiceClient
+//
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	authservicev1 "github.com/Rustam2595/library_service/internal/gen/go"
+	gomock "go.uber.org/mock/gomock"
+	grpc "google.golang.org/grpc"
+)
+
+// MockAuthServiceClient is a mock of AuthServiceClient interface.
+type MockAuthServiceClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuthServiceClientMockRecorder
+	isgomock struct{}
+}
+
+// MockAuthServiceClientMockRecorder is the mock recorder for MockAuthServiceClient.
+type MockAuthServiceClientMockRecorder struct {
+	mock *MockAuthServiceClient
+}
+
+// NewMockAuthServiceClient creates a new mock instance.
+func NewMockAuthServiceClient(ctrl *gomock.Controller) *MockAuthServiceClient {
+	mock := &MockAuthServiceClient{ctrl: ctrl}
+	mock.recorder = &MockAuthServiceClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuthServiceClient) EXPECT() *MockAuthServiceClientMockRecorder {
+	return m.recorder
+}
+
+// Login mocks base method.
+func (m *MockAuthServiceClient) Login(ctx context.Context, in *authservicev1.UserCreds, opts ...grpc.CallOption) (*authservicev1.AuthResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Login", varargs...)
+	ret0, _ := ret[0].(*authservicev1.AuthResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Login indicates an expected call of Login.
+func (mr *MockAuthServiceClientMockRecorder) Login(ctx, in any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockAuthServiceClient)(nil).Login), varargs...)
+}
+
+// Register mocks base method.
+func (m *MockAuthServiceClient) Register(ctx context.Context, in *authservicev1.User, opts ...grpc.CallOption) (*authservicev1.AuthResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Register", varargs...)
+	ret0, _ := ret[0].(*authservicev1.AuthResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Register indicates an expected call of Register.
+func (mr *MockAuthServiceClientMockRecorder) Register(ctx, in any, opts ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockAuthServiceClient)(nil).Register), varargs...)
+}
@@ -10,7 +10,9 @@
 package mocks
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/Rustam2595/library_service/internal/domain/models"
 	gomock "go.uber.org/mock/gomock"
@@ -40,177 +42,422 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// ConfirmEmail mocks base method.
+func (m *MockStorage) ConfirmEmail(arg0 context.Context, arg1, arg2 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmEmail", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConfirmEmail indicates an expected call of ConfirmEmail.
+func (mr *MockStorageMockRecorder) ConfirmEmail(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmEmail", reflect.TypeOf((*MockStorage)(nil).ConfirmEmail), arg0, arg1, arg2)
+}
+
 // DeleteBook mocks base method.
-func (m *MockStorage) DeleteBook(arg0 string) error {
+func (m *MockStorage) DeleteBook(arg0 context.Context, arg1 string, arg2 int64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteBook", arg0)
+	ret := m.ctrl.Call(m, "DeleteBook", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteBook indicates an expected call of DeleteBook.
-func (mr *MockStorageMockRecorder) DeleteBook(arg0 any) *gomock.Call {
+func (mr *MockStorageMockRecorder) DeleteBook(arg0, arg1, arg2 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBook", reflect.TypeOf((*MockStorage)(nil).DeleteBook), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBook", reflect.TypeOf((*MockStorage)(nil).DeleteBook), arg0, arg1, arg2)
 }
 
 // DeleteBooks mocks base method.
-func (m *MockStorage) DeleteBooks() error {
+func (m *MockStorage) DeleteBooks(arg0 context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteBooks")
+	ret := m.ctrl.Call(m, "DeleteBooks", arg0)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteBooks indicates an expected call of DeleteBooks.
-func (mr *MockStorageMockRecorder) DeleteBooks() *gomock.Call {
+func (mr *MockStorageMockRecorder) DeleteBooks(arg0 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBooks", reflect.TypeOf((*MockStorage)(nil).DeleteBooks))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBooks", reflect.TypeOf((*MockStorage)(nil).DeleteBooks), arg0)
 }
 
 // DeleteUser mocks base method.
-func (m *MockStorage) DeleteUser(arg0 string) error {
+func (m *MockStorage) DeleteUser(arg0 context.Context, arg1 string, arg2 int64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteUser", arg0)
+	ret := m.ctrl.Call(m, "DeleteUser", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteUser indicates an expected call of DeleteUser.
-func (mr *MockStorageMockRecorder) DeleteUser(arg0 any) *gomock.Call {
+func (mr *MockStorageMockRecorder) DeleteUser(arg0, arg1, arg2 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockStorage)(nil).DeleteUser), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockStorage)(nil).DeleteUser), arg0, arg1, arg2)
 }
 
 // DeleteUsers mocks base method.
-func (m *MockStorage) DeleteUsers() error {
+func (m *MockStorage) DeleteUsers(arg0 context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteUsers")
+	ret := m.ctrl.Call(m, "DeleteUsers", arg0)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteUsers indicates an expected call of DeleteUsers.
-func (mr *MockStorageMockRecorder) DeleteUsers() *gomock.Call {
+func (mr *MockStorageMockRecorder) DeleteUsers(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUsers", reflect.TypeOf((*MockStorage)(nil).DeleteUsers), arg0)
+}
+
+// EnableTOTP mocks base method.
+func (m *MockStorage) EnableTOTP(arg0 context.Context, arg1 string, arg2 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnableTOTP", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnableTOTP indicates an expected call of EnableTOTP.
+func (mr *MockStorageMockRecorder) EnableTOTP(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableTOTP", reflect.TypeOf((*MockStorage)(nil).EnableTOTP), arg0, arg1, arg2)
+}
+
+// EraseUser mocks base method.
+func (m *MockStorage) EraseUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EraseUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EraseUser indicates an expected call of EraseUser.
+func (mr *MockStorageMockRecorder) EraseUser(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EraseUser", reflect.TypeOf((*MockStorage)(nil).EraseUser), arg0, arg1)
+}
+
+// ExportBooks mocks base method.
+func (m *MockStorage) ExportBooks(arg0 context.Context, arg1 func(models.Book) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportBooks", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportBooks indicates an expected call of ExportBooks.
+func (mr *MockStorageMockRecorder) ExportBooks(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportBooks", reflect.TypeOf((*MockStorage)(nil).ExportBooks), arg0, arg1)
+}
+
+// GetAPIKeyByHash mocks base method.
+func (m *MockStorage) GetAPIKeyByHash(arg0 context.Context, arg1 string) (models.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeyByHash", arg0, arg1)
+	ret0, _ := ret[0].(models.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeyByHash indicates an expected call of GetAPIKeyByHash.
+func (mr *MockStorageMockRecorder) GetAPIKeyByHash(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeyByHash", reflect.TypeOf((*MockStorage)(nil).GetAPIKeyByHash), arg0, arg1)
+}
+
+// GetAPIKeyByID mocks base method.
+func (m *MockStorage) GetAPIKeyByID(arg0 context.Context, arg1 string) (models.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeyByID", arg0, arg1)
+	ret0, _ := ret[0].(models.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeyByID indicates an expected call of GetAPIKeyByID.
+func (mr *MockStorageMockRecorder) GetAPIKeyByID(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUsers", reflect.TypeOf((*MockStorage)(nil).DeleteUsers))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeyByID", reflect.TypeOf((*MockStorage)(nil).GetAPIKeyByID), arg0, arg1)
+}
+
+// GetAPIKeysByOwner mocks base method.
+func (m *MockStorage) GetAPIKeysByOwner(arg0 context.Context, arg1 string) ([]models.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeysByOwner", arg0, arg1)
+	ret0, _ := ret[0].([]models.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeysByOwner indicates an expected call of GetAPIKeysByOwner.
+func (mr *MockStorageMockRecorder) GetAPIKeysByOwner(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeysByOwner", reflect.TypeOf((*MockStorage)(nil).GetAPIKeysByOwner), arg0, arg1)
 }
 
 // GetBookByID mocks base method.
-func (m *MockStorage) GetBookByID(arg0 string) (models.Book, error) {
+func (m *MockStorage) GetBookByID(arg0 context.Context, arg1 string) (models.Book, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBookByID", arg0)
+	ret := m.ctrl.Call(m, "GetBookByID", arg0, arg1)
 	ret0, _ := ret[0].(models.Book)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBookByID indicates an expected call of GetBookByID.
-func (mr *MockStorageMockRecorder) GetBookByID(arg0 any) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetBookByID(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBookByID", reflect.TypeOf((*MockStorage)(nil).GetBookByID), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBookByID", reflect.TypeOf((*MockStorage)(nil).GetBookByID), arg0, arg1)
 }
 
 // GetBookByUID mocks base method.
-func (m *MockStorage) GetBookByUID(arg0 string) ([]models.Book, error) {
+func (m *MockStorage) GetBookByUID(arg0 context.Context, arg1 string) ([]models.Book, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBookByUID", arg0)
+	ret := m.ctrl.Call(m, "GetBookByUID", arg0, arg1)
 	ret0, _ := ret[0].([]models.Book)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBookByUID indicates an expected call of GetBookByUID.
-func (mr *MockStorageMockRecorder) GetBookByUID(arg0 any) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetBookByUID(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBookByUID", reflect.TypeOf((*MockStorage)(nil).GetBookByUID), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBookByUID", reflect.TypeOf((*MockStorage)(nil).GetBookByUID), arg0, arg1)
+}
+
+// GetBookHistory mocks base method.
+func (m *MockStorage) GetBookHistory(arg0 context.Context, arg1 string) ([]models.BookRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBookHistory", arg0, arg1)
+	ret0, _ := ret[0].([]models.BookRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBookHistory indicates an expected call of GetBookHistory.
+func (mr *MockStorageMockRecorder) GetBookHistory(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBookHistory", reflect.TypeOf((*MockStorage)(nil).GetBookHistory), arg0, arg1)
 }
 
 // GetBooks mocks base method.
-func (m *MockStorage) GetBooks() ([]models.Book, error) {
+func (m *MockStorage) GetBooks(arg0 context.Context) ([]models.Book, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBooks")
+	ret := m.ctrl.Call(m, "GetBooks", arg0)
 	ret0, _ := ret[0].([]models.Book)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBooks indicates an expected call of GetBooks.
-func (mr *MockStorageMockRecorder) GetBooks() *gomock.Call {
+func (mr *MockStorageMockRecorder) GetBooks(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBooks", reflect.TypeOf((*MockStorage)(nil).GetBooks), arg0)
+}
+
+// GetUserByEmail mocks base method.
+func (m *MockStorage) GetUserByEmail(arg0 context.Context, arg1 string) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByEmail", arg0, arg1)
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByEmail indicates an expected call of GetUserByEmail.
+func (mr *MockStorageMockRecorder) GetUserByEmail(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByEmail", reflect.TypeOf((*MockStorage)(nil).GetUserByEmail), arg0, arg1)
+}
+
+// GetUserByID mocks base method.
+func (m *MockStorage) GetUserByID(arg0 context.Context, arg1 string) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByID", arg0, arg1)
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByID indicates an expected call of GetUserByID.
+func (mr *MockStorageMockRecorder) GetUserByID(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBooks", reflect.TypeOf((*MockStorage)(nil).GetBooks))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByID", reflect.TypeOf((*MockStorage)(nil).GetUserByID), arg0, arg1)
 }
 
 // GetUsers mocks base method.
-func (m *MockStorage) GetUsers() ([]models.User, error) {
+func (m *MockStorage) GetUsers(arg0 context.Context) ([]models.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUsers")
+	ret := m.ctrl.Call(m, "GetUsers", arg0)
 	ret0, _ := ret[0].([]models.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUsers indicates an expected call of GetUsers.
-func (mr *MockStorageMockRecorder) GetUsers() *gomock.Call {
+func (mr *MockStorageMockRecorder) GetUsers(arg0 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsers", reflect.TypeOf((*MockStorage)(nil).GetUsers))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsers", reflect.TypeOf((*MockStorage)(nil).GetUsers), arg0)
+}
+
+// RevokeAPIKey mocks base method.
+func (m *MockStorage) RevokeAPIKey(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAPIKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAPIKey indicates an expected call of RevokeAPIKey.
+func (mr *MockStorageMockRecorder) RevokeAPIKey(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAPIKey", reflect.TypeOf((*MockStorage)(nil).RevokeAPIKey), arg0, arg1)
+}
+
+// SaveAPIKey mocks base method.
+func (m *MockStorage) SaveAPIKey(arg0 context.Context, arg1 models.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveAPIKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveAPIKey indicates an expected call of SaveAPIKey.
+func (mr *MockStorageMockRecorder) SaveAPIKey(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAPIKey", reflect.TypeOf((*MockStorage)(nil).SaveAPIKey), arg0, arg1)
 }
 
 // SaveBook mocks base method.
-func (m *MockStorage) SaveBook(arg0 models.Book) error {
+func (m *MockStorage) SaveBook(arg0 context.Context, arg1 models.Book) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveBook", arg0)
+	ret := m.ctrl.Call(m, "SaveBook", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveBook indicates an expected call of SaveBook.
-func (mr *MockStorageMockRecorder) SaveBook(arg0 any) *gomock.Call {
+func (mr *MockStorageMockRecorder) SaveBook(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBook", reflect.TypeOf((*MockStorage)(nil).SaveBook), arg0, arg1)
+}
+
+// SaveBooks mocks base method.
+func (m *MockStorage) SaveBooks(arg0 context.Context, arg1 []models.Book) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveBooks", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveBooks indicates an expected call of SaveBooks.
+func (mr *MockStorageMockRecorder) SaveBooks(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBook", reflect.TypeOf((*MockStorage)(nil).SaveBook), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBooks", reflect.TypeOf((*MockStorage)(nil).SaveBooks), arg0, arg1)
 }
 
 // SaveUser mocks base method.
-func (m *MockStorage) SaveUser(arg0 models.User) (string, error) {
+func (m *MockStorage) SaveUser(arg0 context.Context, arg1 models.User) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveUser", arg0)
+	ret := m.ctrl.Call(m, "SaveUser", arg0, arg1)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // SaveUser indicates an expected call of SaveUser.
-func (mr *MockStorageMockRecorder) SaveUser(arg0 any) *gomock.Call {
+func (mr *MockStorageMockRecorder) SaveUser(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveUser", reflect.TypeOf((*MockStorage)(nil).SaveUser), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveUser", reflect.TypeOf((*MockStorage)(nil).SaveUser), arg0, arg1)
+}
+
+// SetPendingEmail mocks base method.
+func (m *MockStorage) SetPendingEmail(arg0 context.Context, arg1, arg2, arg3 string, arg4 time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPendingEmail", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPendingEmail indicates an expected call of SetPendingEmail.
+func (mr *MockStorageMockRecorder) SetPendingEmail(arg0, arg1, arg2, arg3, arg4 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPendingEmail", reflect.TypeOf((*MockStorage)(nil).SetPendingEmail), arg0, arg1, arg2, arg3, arg4)
+}
+
+// SetTOTPSecret mocks base method.
+func (m *MockStorage) SetTOTPSecret(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetTOTPSecret", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTOTPSecret indicates an expected call of SetTOTPSecret.
+func (mr *MockStorageMockRecorder) SetTOTPSecret(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTOTPSecret", reflect.TypeOf((*MockStorage)(nil).SetTOTPSecret), arg0, arg1, arg2)
+}
+
+// UpdateBook mocks base method.
+func (m *MockStorage) UpdateBook(arg0 context.Context, arg1 models.Book, arg2 string) (models.Book, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateBook", arg0, arg1, arg2)
+	ret0, _ := ret[0].(models.Book)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateBook indicates an expected call of UpdateBook.
+func (mr *MockStorageMockRecorder) UpdateBook(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBook", reflect.TypeOf((*MockStorage)(nil).UpdateBook), arg0, arg1, arg2)
 }
 
 // UpdateUser mocks base method.
-func (m *MockStorage) UpdateUser(arg0 string, arg1 models.User) error {
+func (m *MockStorage) UpdateUser(arg0 context.Context, arg1 string, arg2 models.User) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateUser", arg0, arg1)
+	ret := m.ctrl.Call(m, "UpdateUser", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateUser indicates an expected call of UpdateUser.
-func (mr *MockStorageMockRecorder) UpdateUser(arg0, arg1 any) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateUser(arg0, arg1, arg2 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockStorage)(nil).UpdateUser), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockStorage)(nil).UpdateUser), arg0, arg1, arg2)
+}
+
+// UseRecoveryCode mocks base method.
+func (m *MockStorage) UseRecoveryCode(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockStorageMockRecorder) UseRecoveryCode(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockStorage)(nil).UseRecoveryCode), arg0, arg1, arg2)
 }
 
 // ValidateUser mocks base method.
-func (m *MockStorage) ValidateUser(arg0 models.User) (string, string, error) {
+func (m *MockStorage) ValidateUser(arg0 context.Context, arg1 models.User) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ValidateUser", arg0)
+	ret := m.ctrl.Call(m, "ValidateUser", arg0, arg1)
 	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(string)
-	ret2, _ := ret[2].(error)
-	return ret0, ret1, ret2
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // ValidateUser indicates an expected call of ValidateUser.
-func (mr *MockStorageMockRecorder) ValidateUser(arg0 any) *gomock.Call {
+func (mr *MockStorageMockRecorder) ValidateUser(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateUser", reflect.TypeOf((*MockStorage)(nil).ValidateUser), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateUser", reflect.TypeOf((*MockStorage)(nil).ValidateUser), arg0, arg1)
 }